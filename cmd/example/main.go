@@ -18,14 +18,16 @@ type log struct {
 	Entity    model.Entity   `json:"entity"`
 	Context   model.Context  `json:"context"`
 	Extension map[string]any `json:"extension,omitempty"`
+	Changes   []model.Change `json:"changes,omitempty"`
 }
 
 func main() {
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
 	// Parse flag argument
-	var cfgFile string
+	var cfgFile, serviceID string
 	flag.StringVar(&cfgFile, "cfg-file", "", "Directory path to configuration file")
+	flag.StringVar(&serviceID, "service-id", "platform-12345", "ID of the service publishing the logs")
 	flag.Parse()
 
 	config, err := utils.ParseConfig()
@@ -43,7 +45,7 @@ func main() {
 	logger.PrintInfo("connection to message broker established", nil)
 	defer conn.Close()
 
-	msgBroker, err := newMsgBroker(conn, "logs")
+	msgBroker, err := newMsgBroker(conn, "logs", serviceID)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 		return
@@ -59,6 +61,7 @@ func main() {
 		},
 		Entity: model.Entity{
 			Type:      "inventory",
+			ID:        "f66020564728",
 			Extension: map[string]any{"item_id": "f66020564728"},
 		},
 		Context: model.Context{
@@ -67,6 +70,10 @@ func main() {
 			Extension: map[string]any{"inventory_section": "electronics"},
 		},
 		Extension: map[string]any{"notes": "Inventory successfully updated"},
+		Changes: []model.Change{
+			{Path: "quantity", Before: 12, After: 10},
+			{Path: "location.shelf", Before: "A3", After: "B1"},
+		},
 	}
 
 	if err := msgBroker.PublishLog(msg); err != nil {
@@ -9,14 +9,16 @@ import (
 )
 
 type MsgBroker struct {
-	conn  *amqp.Connection
-	queue string
+	conn      *amqp.Connection
+	queue     string
+	serviceID string
 }
 
-func newMsgBroker(conn *amqp.Connection, queue string) (*MsgBroker, error) {
+func newMsgBroker(conn *amqp.Connection, queue, serviceID string) (*MsgBroker, error) {
 	broker := &MsgBroker{
-		conn:  conn,
-		queue: queue,
+		conn:      conn,
+		queue:     queue,
+		serviceID: serviceID,
 	}
 
 	err := broker.setup()
@@ -71,6 +73,7 @@ func (a *MsgBroker) PublishLog(log *log) error {
 		DeliveryMode: amqp.Persistent,
 		Body:         wireData,
 		ContentType:  "application/json",
+		AppId:        a.serviceID, // the service the log is recorded for
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
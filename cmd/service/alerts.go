@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// loadAlertRules loads the alert rules and the saved state of their
+// counts into the alert engine.
+func (svc *service) loadAlertRules(ctx context.Context) error {
+	rules, err := svc.alerts.AllRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		err := svc.engine.SetRule(rule)
+		if err != nil {
+			return fmt.Errorf("alert rule %s: %w", rule.ID.Hex(), err)
+		}
+	}
+
+	states, err := svc.alerts.States(ctx)
+	if err != nil {
+		return err
+	}
+	svc.engine.Restore(states)
+
+	return nil
+}
+
+// evaluateAlerts runs a stored log through the alert rules, saving the
+// state of the counts it changed and the alerts it raised, which are
+// notified to the webhooks subscribed to them.
+func (svc *service) evaluateAlerts(log *model.Log) {
+	alerts, states := svc.engine.Evaluate(log)
+	if len(alerts) == 0 && len(states) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, state := range states {
+		err := svc.alerts.SaveState(ctx, state)
+		if err != nil {
+			svc.logger.PrintError(err, map[string]string{
+				"type":    "failed to save alert state",
+				"rule_id": state.RuleID.Hex(),
+			})
+		}
+	}
+
+	for _, alert := range alerts {
+		err := svc.alerts.AddAlert(ctx, alert)
+		if err != nil {
+			svc.logger.PrintError(err, map[string]string{
+				"type":    "failed to write alert",
+				"rule_id": alert.RuleID.Hex(),
+			})
+			continue
+		}
+
+		svc.logger.PrintInfo("alert raised", map[string]string{
+			"service_id": string(alert.Owner),
+			"rule":       alert.RuleName,
+			"severity":   alert.Severity,
+			"alert_id":   alert.ID.Hex(),
+			"log_id":     alert.LogID.Hex(),
+		})
+
+		svc.notifyAlert(alert)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/anomaly"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// detectAnomalies scores a stored log against the baseline of its actor,
+// and adds the log to the baseline. Anomalies are saved and notified to
+// the webhooks subscribed to them.
+func (svc *service) detectAnomalies(log *model.Log) {
+	if svc.detector == nil || log.Actor.ID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := svc.anomalies.GetBaseline(ctx, log.ServiceID, log.Actor.Type, log.Actor.ID)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		b = anomaly.NewBaseline(log)
+	case err != nil:
+		svc.logger.PrintError(err, map[string]string{
+			"type":     "failed to read actor baseline",
+			"actor_id": log.Actor.ID,
+		})
+		return
+	}
+
+	a := svc.detector.Observe(b, log)
+
+	err = svc.anomalies.SaveBaseline(ctx, b)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type":     "failed to save actor baseline",
+			"actor_id": log.Actor.ID,
+		})
+	}
+
+	if a == nil {
+		return
+	}
+
+	err = svc.anomalies.AddAnomaly(ctx, a)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type":   "failed to write anomaly",
+			"log_id": log.ID.Hex(),
+		})
+		return
+	}
+
+	svc.logger.PrintInfo("anomaly detected", map[string]string{
+		"service_id": string(a.ServiceID),
+		"actor_id":   a.ActorID,
+		"score":      fmt.Sprint(a.Score),
+		"log_id":     a.LogID.Hex(),
+	})
+
+	svc.notifyAnomaly(a)
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
-	"github.com/IkehAkinyemi/logaudit/internal/utils"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -70,40 +70,47 @@ func (svc *service) processLogs() {
 
 	go func() {
 		for msg := range msgs {
-			var log model.Log
-
-			err := json.Unmarshal(msg.Body, &log)
-			if err != nil {
-				svc.logger.PrintError(err, map[string]string{
-					"type":  "failed to unmarshal json",
-					"error": fmt.Sprintf("%+v", string(msg.Body)),
+			// Logs are tied to the service named by app_id, which
+			// publishers set as they please unless it has to match the
+			// user_id the broker vouches for.
+			var err error
+			if svc.config.AMQPBindUser && msg.UserId != msg.AppId {
+				err = pipeline.Reject(fmt.Sprintf("app_id %q does not match user_id %q", msg.AppId, msg.UserId))
+			} else {
+				err = svc.ingest(&pipeline.Delivery{
+					Source:     "amqp",
+					ServiceID:  model.ServiceID(msg.AppId),
+					MessageID:  msg.MessageId,
+					Headers:    msg.Headers,
+					Body:       msg.Body,
+					ReceivedAt: time.Now().UTC(),
 				})
-				continue
 			}
 
-			v := utils.NewValidator()
-			if utils.ValidateLog(v, &log); !v.Valid() {
-				svc.logger.PrintError(errors.New("failed to validate log"), map[string]string{
-					"errors": fmt.Sprintf("%+v", v.Errors),
-					"log":    fmt.Sprintf("%+v", log),
+			var rejection *pipeline.Rejection
+			switch {
+			case err == nil:
+				msg.Ack(false)
+			case errors.Is(err, pipeline.ErrDropped):
+				svc.logger.PrintInfo("log dropped", map[string]string{
+					"message_id": msg.MessageId,
+					"reason":     err.Error(),
 				})
-				continue
-			}
-
-			id, err := svc.logs.AddLog(&log)
-			if err != nil {
+				msg.Ack(false)
+			case errors.As(err, &rejection):
+				svc.logger.PrintError(rejection, map[string]string{
+					"type":       "log rejected",
+					"processor":  rejection.Processor,
+					"message_id": msg.MessageId,
+					"log":        string(msg.Body),
+				})
+				msg.Reject(false)
+			default:
 				svc.logger.PrintError(err, map[string]string{
 					"type": "failed to write log",
-					"log":  fmt.Sprintf("%+v", log),
+					"log":  string(msg.Body),
 				})
-				continue
 			}
-
-			svc.logger.PrintInfo("log added to data store", map[string]string{
-				"resource_id": fmt.Sprintf("%+v", id),
-			})
-
-			msg.Ack(false)
 		}
 	}()
 
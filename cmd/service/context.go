@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
 )
 
 type contextKey string
 
-var servicContextKey = contextKey("service")
+var (
+	servicContextKey = contextKey("service")
+	connContextKey   = contextKey("conn")
+)
 
 // contextSetService registers an authenticated service per connection
 func (svc *service) contextSetService(r *http.Request, serviceID *model.ServiceID) *http.Request {
@@ -26,3 +31,20 @@ func (svc *service) contextGetService(r *http.Request) *model.ServiceID {
 
 	return serviceID
 }
+
+// contextSetConn registers the underlying connection of a request. It is
+// used as the ConnContext of the server.
+func contextSetConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey, conn)
+}
+
+// extendWriteDeadline pushes back the write deadline of the connection
+// serving r, so long-running responses outlive the server's WriteTimeout.
+func (svc *service) extendWriteDeadline(r *http.Request, d time.Duration) error {
+	conn, ok := r.Context().Value(connContextKey).(net.Conn)
+	if !ok {
+		panic("missing connection value in request context")
+	}
+
+	return conn.SetWriteDeadline(time.Now().Add(d))
+}
@@ -95,3 +95,25 @@ func (svc *service) failedValidationResponse(w http.ResponseWriter, r *http.Requ
 	svc.logger.PrintDebug("request body validation failed", errors)
 	svc.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+// notAcceptableResponse reports that none of the media types accepted by
+// the client can be produced.
+func (svc *service) notAcceptableResponse(w http.ResponseWriter, r *http.Request, supported string) {
+	svc.logDebug(r, "not acceptable")
+	msg := fmt.Sprintf("the resource can only be represented as %s", supported)
+	svc.errorResponse(w, r, http.StatusNotAcceptable, msg)
+}
+
+// conflictResponse reports that a request conflicts with the current state
+// of the resource.
+func (svc *service) conflictResponse(w http.ResponseWriter, r *http.Request, msg string) {
+	svc.logDebug(r, "conflict: "+msg)
+	svc.errorResponse(w, r, http.StatusConflict, msg)
+}
+
+// tooManyRequestsResponse reports that the client holds more of a limited
+// resource than it may.
+func (svc *service) tooManyRequestsResponse(w http.ResponseWriter, r *http.Request, msg string) {
+	svc.logDebug(r, "too many requests: "+msg)
+	svc.errorResponse(w, r, http.StatusTooManyRequests, msg)
+}
@@ -1,11 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/changeset"
+	"github.com/IkehAkinyemi/logaudit/internal/export"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/mongodb"
+	"github.com/IkehAkinyemi/logaudit/internal/session"
+	"github.com/IkehAkinyemi/logaudit/internal/stream"
 	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"github.com/IkehAkinyemi/logaudit/internal/webhook"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // healthcheck maps to "GET /v1/healthcheck". Return info about the server state.
@@ -16,6 +34,8 @@ func (svc *service) healthcheck(w http.ResponseWriter, r *http.Request) {
 			"enviroment": svc.config.Env,
 			"version":    "1.0.0",
 		},
+		"processors": svc.chain.Stats(),
+		"plugins":    svc.plugins.Stats(),
 	}
 
 	err := utils.WriteJSON(w, http.StatusOK, data, nil)
@@ -98,23 +118,33 @@ func (svc *service) resetToken(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// auditTrail maps to "GET /v1/audit-trail?<query_string>".
-// Retrieves logs based on the query_string values.
+// readFilters reads the filter criteria of a request, restricting them to
+// the logs the calling service may read and bounding the time span they
+// may cover by the limit set for it.
+func (svc *service) readFilters(r *http.Request, qs url.Values, v *utils.Validator) utils.Filters {
+	input := utils.ReadFilters(qs, v)
+	input.ServiceID = *svc.contextGetService(r)
+	input.MaxSpan = svc.config.QuerySpan(input.ServiceID)
+	return input
+}
+
+// GetLogs maps to "GET /v1/logs?<query_string>". Retrieves the logs the
+// calling service may read that match the query string, through queryLogs
+// as getViewLogs does.
 func (svc *service) GetLogs(w http.ResponseWriter, r *http.Request) {
-	var input utils.Filters
+	svc.queryLogs(w, r, r.URL.Query(), map[string]string{
+		"service_id":   string(*svc.contextGetService(r)),
+		"query_string": r.URL.String(),
+	})
+}
 
+// queryLogs writes the logs matched by the parameters of a query string,
+// which the calling service may read, and logs the query with the given
+// properties.
+func (svc *service) queryLogs(w http.ResponseWriter, r *http.Request, qs url.Values, properties map[string]string) {
 	v := utils.NewValidator()
 
-	query := r.URL.Query()
-	input.Action = utils.ReadStr(query, "action", "")
-	input.ActorID = utils.ReadStr(query, "actor_id", "")
-	input.ActorType = utils.ReadStr(query, "actor_type", "")
-	input.EntityType = utils.ReadStr(query, "entity_type", "")
-	input.StartTimestamp = utils.ParseTime(query, "start_timestamp")
-	input.EndTimestamp = utils.ParseTime(query, "end_timestamp")
-	input.SortField, input.SortDescending = utils.SortValues(query, "sort")
-	input.Page = utils.ReadInt(query, "page", 1, v)
-	input.PageSize = utils.ReadInt(query, "page_size", 20, v)
+	input := svc.readFilters(r, qs, v)
 
 	if utils.ValidateFilters(v, input); !v.Valid() {
 		svc.failedValidationResponse(w, r, v.Errors)
@@ -127,13 +157,1765 @@ func (svc *service) GetLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"logs": logs, "metadata": metadata}, nil)
+	if input.Search != "" {
+		for _, log := range logs {
+			log.Highlights = utils.Highlight(log, input.Search)
+		}
+	}
+
+	projected, err := utils.ProjectLogs(logs, input.Fields)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"logs": projected, "metadata": metadata}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+
+	svc.logger.PrintInfo("Queried for logs", properties)
+}
+
+// streamLogs maps to "GET /v1/logs/stream?<query_string>". Pushes the logs
+// matched by the same filters as GetLogs as they are stored, over
+// Server-Sent Events or, for upgrade requests, WebSocket. A client passing
+// the ID of the last log it received, as Last-Event-ID or last_event_id,
+// first gets the matching logs stored since.
+func (svc *service) streamLogs(w http.ResponseWriter, r *http.Request) {
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	input := svc.readFilters(r, qs, v)
+
+	for _, key := range []string{"search", "sort", "facets", "page", "page_size", "cursor"} {
+		v.Check(qs.Get(key) == "", key, "is not supported on live streams")
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = qs.Get("last_event_id")
+	}
+	if lastEventID != "" {
+		id, err := primitive.ObjectIDFromHex(lastEventID)
+		v.Check(err == nil, "last_event_id", "must be the id of a log")
+		input.Cursor = &utils.Cursor{ID: id}
+	}
+
+	// A live stream covers the logs stored from now on, and replays only
+	// those a client missed, so it is not bound by query span limits.
+	input.MaxSpan = 0
+
+	if utils.ValidateFilters(v, input); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sub, err := svc.hub.Subscribe(input.ServiceID, input.Match)
+	if err != nil {
+		switch {
+		case errors.Is(err, stream.ErrTooManySubscriptions):
+			svc.tooManyRequestsResponse(w, r, fmt.Sprintf("at most %d live streams may be open per service", svc.config.StreamMaxConns))
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer sub.Close()
+
+	var ls logStream
+	if websocket.IsWebSocketUpgrade(r) {
+		ls, err = newWebSocketStream(w, r)
+		if err != nil {
+			return
+		}
+	} else {
+		ls, err = svc.newEventStream(w, r)
+		if err != nil {
+			svc.logError(r, err)
+			return
+		}
+	}
+
+	svc.logger.PrintInfo("Opened live stream", map[string]string{
+		"service_id":   string(input.ServiceID),
+		"query_string": r.URL.String(),
+	})
+
+	err = svc.pushLogs(r, ls, sub, input)
+	if err != nil && !errors.Is(err, stream.ErrSlowSubscriber) && !errors.Is(err, stream.ErrClosed) {
+		svc.logError(r, err)
+	}
+	ls.end(err)
+}
+
+// pushLogs sends the logs of a subscription down a live stream until the
+// client leaves or the subscription ends, having first replayed the logs
+// stored after the cursor of the filter criteria, if any.
+func (svc *service) pushLogs(r *http.Request, ls logStream, sub *stream.Subscription, filter utils.Filters) error {
+	send := func(log *model.Log) error {
+		var data interface{} = log
+		if len(filter.Fields) > 0 {
+			projected, err := utils.Project(log, filter.Fields)
+			if err != nil {
+				return err
+			}
+			data = projected
+		}
+
+		js, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return ls.send(log.ID.Hex(), js)
+	}
+
+	// The subscription started before the replay, so logs stored in the
+	// meantime are both replayed and received live. Log IDs increase as
+	// logs are stored, which tells the second copy apart.
+	var last primitive.ObjectID
+	if filter.Cursor != nil {
+		last = filter.Cursor.ID
+		err := svc.logs.StreamLogs(r.Context(), filter, func(log *model.Log) error {
+			last = log.ID
+			return send(log)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(streamPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case log := <-sub.Logs():
+			if bytes.Compare(log.ID[:], last[:]) <= 0 {
+				continue
+			}
+			if err := send(log); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := ls.ping(); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			return sub.Err()
+		case <-ls.gone():
+			return nil
+		}
+	}
+}
+
+// getLogStats maps to "GET /v1/logs/stats?<query_string>". Aggregates the
+// logs matched by the same filters as GetLogs into counts.
+func (svc *service) getLogStats(w http.ResponseWriter, r *http.Request) {
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	input := svc.readFilters(r, qs, v)
+	opts := utils.ReadStatsOptions(qs, v)
+
+	utils.ValidateFilters(v, input)
+	if utils.ValidateStatsOptions(v, opts, input); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := svc.logs.GetStats(r.Context(), input, opts)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"stats": stats}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// maxExportExtensionColumns bounds the extension columns discovered for
+// flattened exports.
+const maxExportExtensionColumns = 500
+
+// exportPageSize is the number of logs the extension columns of flattened
+// exports are discovered from, and held back for until then.
+const exportPageSize = 1000
+
+// exportLogs maps to "GET /v1/logs/export?<query_string>". Streams every
+// log matched by the same filters as GetLogs as NDJSON, CSV or Parquet.
+func (svc *service) exportLogs(w http.ResponseWriter, r *http.Request) {
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	input := svc.readFilters(r, qs, v)
+
+	// Exports run in insertion order, so an interrupted download resumes
+	// from the ID of the last record received.
+	v.Check(qs.Get("sort") == "", "sort", "is not supported on exports")
+	v.Check(qs.Get("fields") == "", "fields", "is not supported on exports, use columns instead")
+	input.Sort = nil
+
+	var format export.Format
+	var ok bool
+	if name := qs.Get("format"); name != "" {
+		format, ok = export.LookupFormat(name)
+		v.Check(ok, "format", "must be one of ndjson, csv or parquet")
+	} else {
+		format, ok = export.Negotiate(r.Header.Get("Accept"))
+		if !ok {
+			svc.notAcceptableResponse(w, r, "application/x-ndjson, text/csv or application/vnd.apache.parquet")
+			return
+		}
+	}
+
+	if utils.ValidateFilters(v, input); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Exports can take far longer than the server's WriteTimeout, so the
+	// deadline is pushed back as records go out.
+	const deadline = 30 * time.Second
+	err := svc.extendWriteDeadline(r, deadline)
 	if err != nil {
 		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Picked columns always include the ID, for interrupted downloads to
+	// resume from.
+	var names []string
+	if qs.Get("columns") != "" {
+		names = strings.Split(qs.Get("columns"), ",")
+		if !utils.PermittedValue("id", names...) {
+			names = append([]string{"id"}, names...)
+		}
+	}
+
+	columns, err := export.Columns(names)
+	if err != nil {
+		svc.failedValidationResponse(w, r, map[string]string{"columns": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs.%s"`, format.Extension))
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if format.Compress && export.AcceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	// Once the response is under way errors can no longer be reported to
+	// the client, so the connection is dropped for the download to show
+	// as incomplete.
+	abort := func(err error) {
+		svc.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	// Flattened formats get a column per extension key used by the first
+	// page of logs, unless the columns are picked explicitly. The page is
+	// held back until the columns are known.
+	discover := format != export.NDJSON && len(names) == 0
+	var ew export.Writer
+	var page []*model.Log
+	start := func() error {
+		columns := columns
+		var err error
+		if discover {
+			discovered := append(export.DefaultColumns[:len(export.DefaultColumns):len(export.DefaultColumns)],
+				export.ExtensionColumns(page, maxExportExtensionColumns)...)
+			columns, err = export.Columns(discovered)
+			if err != nil {
+				return err
+			}
+		}
+
+		ew, err = export.NewWriter(format, out, columns)
+		if err != nil {
+			return err
+		}
+		for _, log := range page {
+			if err := ew.Write(log); err != nil {
+				return err
+			}
+		}
+		page = nil
+		return nil
+	}
+
+	var count int
+	extended := time.Now()
+	err = svc.logs.StreamLogs(r.Context(), input, func(log *model.Log) error {
+		if time.Since(extended) > deadline/3 {
+			extended = time.Now()
+			if err := svc.extendWriteDeadline(r, deadline); err != nil {
+				return err
+			}
+		}
+
+		count++
+		if ew == nil {
+			page = append(page, log)
+			if len(page) < exportPageSize {
+				return nil
+			}
+			return start()
+		}
+		return ew.Write(log)
+	})
+	if err == nil && ew == nil {
+		err = start()
+	}
+	if err != nil {
+		abort(err)
+	}
+
+	err = ew.Close()
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		abort(err)
 	}
 
-	svc.logger.PrintInfo("Queried for logs", map[string]string{
+	svc.logger.PrintInfo("Exported logs", map[string]string{
 		"service_id":   string(*svc.contextGetService(r)),
 		"query_string": r.URL.String(),
+		"format":       format.Name,
+		"records":      strconv.Itoa(count),
 	})
 }
+
+// jobEnvelope wraps a query job along with its progress.
+func jobEnvelope(job *model.QueryJob) utils.Envelope {
+	return utils.Envelope{"job": job, "progress": job.Progress()}
+}
+
+// submitQueryJob maps to "POST /v1/queries". Queues a query over the same
+// filters as GetLogs to run in the background, for queries too large to
+// answer within a request.
+func (svc *service) submitQueryJob(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filter map[string]string `json:"filter"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := utils.NewValidator()
+
+	// Results are materialized in insertion order and paged through with
+	// their own cursor.
+	for _, key := range []string{"sort", "fields", "page", "page_size", "cursor"} {
+		_, ok := input.Filter[key]
+		v.Check(!ok, key, "is not supported on query jobs")
+	}
+
+	filter := svc.readFilters(r, jobFilterValues(input.Filter), v)
+	if utils.ValidateFilters(v, filter); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	now := time.Now().UTC()
+	job := &model.QueryJob{
+		ServiceID: *svc.contextGetService(r),
+		Filter:    input.Filter,
+		Status:    model.JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(svc.config.QueryJobTTL),
+	}
+	if job.Filter == nil {
+		job.Filter = map[string]string{}
+	}
+
+	// Relative times are resolved once, so the job covers the same span
+	// however long it waits or is resumed.
+	if !filter.StartTimestamp.IsZero() {
+		job.Filter["start_timestamp"] = filter.StartTimestamp.Format(time.RFC3339Nano)
+	}
+	if !filter.EndTimestamp.IsZero() {
+		job.Filter["end_timestamp"] = filter.EndTimestamp.Format(time.RFC3339Nano)
+	}
+
+	err = svc.jobs.AddJob(r.Context(), job)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	svc.background(func() { svc.runJob(job) })
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/queries/%s", job.ID.Hex()))
+
+	err = utils.WriteJSON(w, http.StatusAccepted, jobEnvelope(job), headers)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+
+	svc.logger.PrintInfo("Submitted query job", map[string]string{
+		"service_id": string(job.ServiceID),
+		"job_id":     job.ID.Hex(),
+	})
+}
+
+// getQueryJob maps to "GET /v1/queries/:id". Returns the status and
+// progress of a query job.
+func (svc *service) getQueryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := svc.jobs.GetJob(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, jobEnvelope(job), nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelQueryJob maps to "DELETE /v1/queries/:id". Cancels a queued or
+// running query job. The results materialized so far remain available.
+func (svc *service) cancelQueryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := svc.jobs.CancelJob(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrJobFinished):
+			svc.conflictResponse(w, r, "the query job has already finished")
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	svc.runner.cancel(id)
+
+	err = utils.WriteJSON(w, http.StatusOK, jobEnvelope(job), nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getQueryJobResults maps to "GET /v1/queries/:id/results?<query_string>".
+// Pages through the logs materialized by a query job so far.
+func (svc *service) getQueryJobResults(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	pageSize := utils.ReadInt(qs, "page_size", 20, v)
+	cursor := utils.ReadCursor(qs, "cursor", v)
+
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Check(pageSize <= 100, "page_size", "must be a maximum of 100")
+	if cursor != nil {
+		v.Check(len(cursor.Sort) == 0, "cursor", "must be a cursor returned by a previous request")
+	}
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	job, err := svc.jobs.GetJob(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var after primitive.ObjectID
+	if cursor != nil {
+		after = cursor.ID
+	}
+
+	// One extra record is fetched to tell whether there is a next page.
+	logs, err := svc.jobs.GetResults(r.Context(), id, after, pageSize+1)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// While the job runs, the last page also carries a cursor to poll for
+	// the results still to come.
+	metadata := utils.Metadata{PageSize: pageSize, TotalRecords: job.Processed}
+	more := len(logs) > pageSize
+	if more {
+		logs = logs[:pageSize]
+	}
+	if len(logs) > 0 && (more || !job.Status.Finished()) {
+		metadata.NextCursor, _ = utils.EncodeCursor(utils.Cursor{ID: logs[len(logs)-1].ID})
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"logs": logs, "metadata": metadata, "status": job.Status}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+const (
+	// maxRelatedWindow bounds the window related events are looked up in.
+	maxRelatedWindow = 7 * 24 * time.Hour
+
+	// maxRelatedLogs bounds the related events returned by actor and by
+	// entity.
+	maxRelatedLogs = 50
+)
+
+// getLog maps to "GET /v1/logs/:id?<query_string>". Returns a log with its
+// integrity metadata and, with include=related, the events surrounding it
+// from the same actor and on the same entity. With view=diff, its changes
+// are rendered as added, removed and modified fields.
+func (svc *service) getLog(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	include := utils.ReadStr(qs, "include", "")
+	view := utils.ReadStr(qs, "view", "")
+	window := utils.ReadDuration(qs, "window", svc.config.RelatedWindow, v)
+
+	v.Check(include == "" || include == "related", "include", "must be related")
+	v.Check(view == "" || view == "diff", "view", "must be diff")
+	v.Check(window > 0, "window", "must be greater than zero")
+	v.Check(window <= maxRelatedWindow, "window", "must be a maximum of 168h")
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	serviceID := *svc.contextGetService(r)
+
+	log, err := svc.logs.GetLog(r.Context(), id, serviceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := utils.Envelope{"log": log, "integrity": log.Integrity}
+
+	if view == "diff" {
+		env["diff"] = changeset.Compare(log.Changes)
+	}
+
+	if include == "related" {
+		actor, entity, err := svc.logs.RelatedLogs(r.Context(), log, serviceID, window, maxRelatedLogs)
+		if err != nil {
+			svc.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["related"] = utils.Envelope{
+			"window": window.String(),
+			"actor":  actor,
+			"entity": entity,
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getEntityHistory maps to "GET /v1/entities/:type/:id/history?<query_string>".
+// Returns the actions taken on an entity, and by whom, in chronological
+// order. It takes the same filters as GetLogs, apart from sort.
+func (svc *service) getEntityHistory(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	input := svc.readFilters(r, qs, v)
+	input.EntityType, input.EntityID = params.ByName("type"), params.ByName("id")
+
+	for _, key := range []string{"sort", "fields"} {
+		v.Check(qs.Get(key) == "", key, "is not supported on entity history")
+	}
+	input.Sort = []utils.SortKey{{Field: "timestamp"}}
+
+	if utils.ValidateFilters(v, input); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	logs, metadata, err := svc.logs.GetAllLogs(r.Context(), input)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	history := make([]model.HistoryEntry, len(logs))
+	for i, log := range logs {
+		history[i] = model.HistoryEntry{
+			ID:        log.ID,
+			Timestamp: log.Timestamp,
+			Action:    log.Action,
+			Actor:     log.Actor,
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"history": history, "metadata": metadata}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// maxActivityLogs bounds the logs grouped into sessions per request.
+const maxActivityLogs = 5000
+
+// readSessionOptions reads how the logs of an actor are grouped into
+// sessions. An empty session_key groups them by inactivity alone.
+func (svc *service) readSessionOptions(qs url.Values, v *utils.Validator) session.Options {
+	opts := session.Options{
+		Key: svc.config.SessionKey,
+		Gap: utils.ReadDuration(qs, "gap", svc.config.SessionGap, v),
+	}
+	if _, ok := qs["session_key"]; ok {
+		opts.Key = strings.TrimSpace(qs.Get("session_key"))
+	}
+	return opts
+}
+
+// getActorActivity maps to "GET /v1/actors/:type/:id/activity?<query_string>".
+// Returns the logs of an actor grouped into sessions, each with a summary
+// of the activity. It takes the same filters as GetLogs, apart from sort
+// and paging.
+func (svc *service) getActorActivity(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	input := svc.readFilters(r, qs, v)
+	input.ActorType, input.ActorID = params.ByName("type"), params.ByName("id")
+
+	opts := svc.readSessionOptions(qs, v)
+
+	for _, key := range []string{"sort", "fields", "cursor"} {
+		v.Check(qs.Get(key) == "", key, "is not supported on actor activity")
+	}
+	v.Check(opts.Gap > 0, "gap", "must be greater than zero")
+	input.Sort = []utils.SortKey{{Field: "timestamp"}}
+
+	if utils.ValidateFilters(v, input); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Logs are only read up to the limit, plus one to tell whether the
+	// activity was cut short.
+	errLimitReached := errors.New("limit reached")
+
+	var count int
+	builder := session.NewBuilder(opts)
+	err := svc.logs.StreamLogs(r.Context(), input, func(log *model.Log) error {
+		if count == maxActivityLogs {
+			return errLimitReached
+		}
+		count++
+		builder.Add(log)
+		return nil
+	})
+	truncated := errors.Is(err, errLimitReached)
+	if err != nil && !truncated {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := utils.Envelope{"sessions": builder.Sessions(), "truncated": truncated}
+	err = utils.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getEntityState maps to "GET /v1/entities/:type/:id/state?at=<timestamp>".
+// Reconstructs the state of an entity as of a time, by default now, by
+// replaying the change sets of its logs in chronological order.
+func (svc *service) getEntityState(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	qs := r.URL.Query()
+	v := utils.NewValidator()
+	at := utils.ParseTime(qs, "at", v)
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+
+	filter := utils.Filters{
+		EntityType:   params.ByName("type"),
+		EntityID:     params.ByName("id"),
+		EndTimestamp: at,
+		Sort:         []utils.SortKey{{Field: "timestamp"}},
+		ServiceID:    *svc.contextGetService(r),
+	}
+
+	state := make(map[string]interface{})
+	var replayed int
+	var last *model.Log
+	err := svc.logs.StreamLogs(r.Context(), filter, func(log *model.Log) error {
+		if len(log.Changes) == 0 {
+			return nil
+		}
+		changeset.Apply(state, log.Changes)
+		replayed++
+		last = log
+		return nil
+	})
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if last == nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	env := utils.Envelope{
+		"state":       state,
+		"as_of":       at,
+		"change_sets": replayed,
+		"last_log_id": last.ID,
+		"last_change": last.Timestamp,
+	}
+	err = utils.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// readView retrieves the view named in the URL for the calling service.
+// Views shared with the caller are addressed with ?owner=<service_id>. It
+// reports whether the view was found, having written an error response
+// otherwise.
+func (svc *service) readView(w http.ResponseWriter, r *http.Request) (*model.View, bool) {
+	caller := *svc.contextGetService(r)
+	owner := model.ServiceID(utils.ReadStr(r.URL.Query(), "owner", string(caller)))
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	view, err := svc.views.GetView(r.Context(), owner, name, caller)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return view, true
+}
+
+// createView maps to "POST /v1/views". Saves a named query for the
+// calling service.
+func (svc *service) createView(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Params      map[string]string `json:"params"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	view := &model.View{
+		Owner:       *svc.contextGetService(r),
+		Name:        input.Name,
+		Description: input.Description,
+		Params:      input.Params,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateView(v, view); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = svc.views.AddView(r.Context(), view)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateView):
+			svc.failedValidationResponse(w, r, map[string]string{
+				"name": "a view with this name already exists",
+			})
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/views/%s", view.Name))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"view": view}, headers)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listViews maps to "GET /v1/views". Lists the views the calling service
+// owns or was granted.
+func (svc *service) listViews(w http.ResponseWriter, r *http.Request) {
+	views, err := svc.views.ListViews(r.Context(), *svc.contextGetService(r))
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"views": views}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getView maps to "GET /v1/views/:name". Returns a view.
+func (svc *service) getView(w http.ResponseWriter, r *http.Request) {
+	view, ok := svc.readView(w, r)
+	if !ok {
+		return
+	}
+
+	err := utils.WriteJSON(w, http.StatusOK, utils.Envelope{"view": view}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateView maps to "PATCH /v1/views/:name". Updates the description or
+// the parameters of a view owned by the calling service.
+func (svc *service) updateView(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	owner := *svc.contextGetService(r)
+
+	view, err := svc.views.GetView(r.Context(), owner, name, owner)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Description *string           `json:"description"`
+		Params      map[string]string `json:"params"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Description != nil {
+		view.Description = *input.Description
+	}
+	if input.Params != nil {
+		view.Params = input.Params
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateView(v, view); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = svc.views.UpdateView(r.Context(), view)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"view": view}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteView maps to "DELETE /v1/views/:name". Deletes a view owned by the
+// calling service.
+func (svc *service) deleteView(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	err := svc.views.DeleteView(r.Context(), *svc.contextGetService(r), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "view successfully deleted"}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantView maps to "PUT /v1/views/:name/grants/:service_id" and
+// "DELETE /v1/views/:name/grants/:service_id". Shares a view owned by the
+// calling service with another service, or stops sharing it.
+func (svc *service) grantView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	name, grantee := params.ByName("name"), model.ServiceID(params.ByName("service_id"))
+	owner := *svc.contextGetService(r)
+
+	if grantee == owner {
+		svc.badRequestResponse(w, r, errors.New("a view cannot be shared with its owner"))
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodDelete {
+		err = svc.views.Revoke(r.Context(), owner, name, grantee)
+	} else {
+		err = svc.views.Grant(r.Context(), owner, name, grantee)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	view, err := svc.views.GetView(r.Context(), owner, name, owner)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"view": view}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getViewLogs maps to "GET /v1/views/:name/logs?<query_string>". Runs the
+// query saved in a view. Parameters given in the query string override the
+// saved ones.
+func (svc *service) getViewLogs(w http.ResponseWriter, r *http.Request) {
+	view, ok := svc.readView(w, r)
+	if !ok {
+		return
+	}
+
+	overrides := r.URL.Query()
+	overrides.Del("owner")
+
+	svc.queryLogs(w, r, utils.ViewValues(view.Params, overrides), map[string]string{
+		"service_id":   string(*svc.contextGetService(r)),
+		"view_owner":   string(view.Owner),
+		"view":         view.Name,
+		"query_string": r.URL.String(),
+	})
+}
+
+// readAlertRule retrieves the alert rule of the calling service whose ID
+// is in the URL. It reports whether the rule was found, having written an
+// error response otherwise.
+func (svc *service) readAlertRule(w http.ResponseWriter, r *http.Request) (*model.AlertRule, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	rule, err := svc.alerts.GetRule(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return rule, true
+}
+
+// createAlertRule maps to "POST /v1/alert-rules". Adds an alert rule
+// evaluated against the logs visible to the calling service.
+func (svc *service) createAlertRule(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Severity    string         `json:"severity"`
+		Query       string         `json:"query"`
+		GroupBy     []string       `json:"group_by"`
+		Threshold   *int           `json:"threshold"`
+		Window      model.Duration `json:"window"`
+		Enabled     *bool          `json:"enabled"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	rule := &model.AlertRule{
+		Owner:       *svc.contextGetService(r),
+		Name:        input.Name,
+		Description: input.Description,
+		Severity:    input.Severity,
+		Query:       input.Query,
+		GroupBy:     input.GroupBy,
+		Threshold:   1,
+		Window:      input.Window,
+		Enabled:     true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	if input.Threshold != nil {
+		rule.Threshold = *input.Threshold
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateAlertRule(v, rule); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = svc.alerts.AddRule(r.Context(), rule)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateRule):
+			svc.failedValidationResponse(w, r, map[string]string{
+				"name": "an alert rule with this name already exists",
+			})
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = svc.engine.SetRule(rule)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/alert-rules/%s", rule.ID.Hex()))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"alert_rule": rule}, headers)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAlertRules maps to "GET /v1/alert-rules". Lists the alert rules of
+// the calling service.
+func (svc *service) listAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := svc.alerts.ListRules(r.Context(), *svc.contextGetService(r))
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"alert_rules": rules}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getAlertRule maps to "GET /v1/alert-rules/:id". Returns an alert rule.
+func (svc *service) getAlertRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := svc.readAlertRule(w, r)
+	if !ok {
+		return
+	}
+
+	err := utils.WriteJSON(w, http.StatusOK, utils.Envelope{"alert_rule": rule}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateAlertRule maps to "PATCH /v1/alert-rules/:id". Updates an alert
+// rule, whose counts start over.
+func (svc *service) updateAlertRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := svc.readAlertRule(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string         `json:"name"`
+		Description *string         `json:"description"`
+		Severity    *string         `json:"severity"`
+		Query       *string         `json:"query"`
+		GroupBy     []string        `json:"group_by"`
+		Threshold   *int            `json:"threshold"`
+		Window      *model.Duration `json:"window"`
+		Enabled     *bool           `json:"enabled"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		rule.Name = *input.Name
+	}
+	if input.Description != nil {
+		rule.Description = *input.Description
+	}
+	if input.Severity != nil {
+		rule.Severity = *input.Severity
+	}
+	if input.Query != nil {
+		rule.Query = *input.Query
+	}
+	if input.GroupBy != nil {
+		rule.GroupBy = input.GroupBy
+	}
+	if input.Threshold != nil {
+		rule.Threshold = *input.Threshold
+	}
+	if input.Window != nil {
+		rule.Window = *input.Window
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateAlertRule(v, rule); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = svc.alerts.UpdateRule(r.Context(), rule)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateRule):
+			svc.failedValidationResponse(w, r, map[string]string{
+				"name": "an alert rule with this name already exists",
+			})
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = svc.engine.SetRule(rule)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"alert_rule": rule}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAlertRule maps to "DELETE /v1/alert-rules/:id". Deletes an alert
+// rule. The alerts it raised are kept.
+func (svc *service) deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	err = svc.alerts.DeleteRule(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	svc.engine.RemoveRule(id)
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "alert rule successfully deleted"}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAlerts maps to "GET /v1/alerts?rule_id=&before=&limit=". Lists the
+// alerts raised for the calling service, newest first.
+func (svc *service) listAlerts(w http.ResponseWriter, r *http.Request) {
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	before, limit := utils.ReadPage(qs, "an alert", v)
+
+	var ruleID primitive.ObjectID
+	if s := qs.Get("rule_id"); s != "" {
+		id, err := primitive.ObjectIDFromHex(s)
+		v.Check(err == nil, "rule_id", "must be the id of an alert rule")
+		ruleID = id
+	}
+
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	alerts, err := svc.alerts.ListAlerts(r.Context(), *svc.contextGetService(r), ruleID, before, limit)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"alerts": alerts}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWebhook retrieves the webhook of the calling service whose ID is in
+// the URL. It reports whether the webhook was found, having written an
+// error response otherwise.
+func (svc *service) readWebhook(w http.ResponseWriter, r *http.Request) (*model.Webhook, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	hook, err := svc.webhooks.GetWebhook(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return hook, true
+}
+
+// createWebhook maps to "POST /v1/webhooks". Subscribes a URL to events
+// of the calling service. The secret payloads are signed with is only
+// returned here.
+func (svc *service) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL     string            `json:"url"`
+		Events  []string          `json:"events"`
+		Filter  map[string]string `json:"filter"`
+		Enabled *bool             `json:"enabled"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	hook := &model.Webhook{
+		Owner:     *svc.contextGetService(r),
+		URL:       input.URL,
+		Events:    input.Events,
+		Filter:    input.Filter,
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if input.Enabled != nil {
+		hook.Enabled = *input.Enabled
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateWebhook(v, hook); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hook.Secret, err = webhook.NewSecret()
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = svc.webhooks.AddWebhook(r.Context(), hook)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+	svc.registry.set(hook)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%s", hook.ID.Hex()))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"webhook": hook, "secret": hook.Secret}, headers)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhooks maps to "GET /v1/webhooks". Lists the webhooks of the
+// calling service.
+func (svc *service) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := svc.webhooks.ListWebhooks(r.Context(), *svc.contextGetService(r))
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhooks": hooks}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getWebhook maps to "GET /v1/webhooks/:id". Returns a webhook.
+func (svc *service) getWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := svc.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhook": hook}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhook maps to "PATCH /v1/webhooks/:id". Updates a webhook, and
+// returns its new secret when asked to rotate it.
+func (svc *service) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := svc.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL          *string           `json:"url"`
+		Events       []string          `json:"events"`
+		Filter       map[string]string `json:"filter"`
+		Enabled      *bool             `json:"enabled"`
+		RotateSecret bool              `json:"rotate_secret"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Filter != nil {
+		hook.Filter = input.Filter
+	}
+	if input.Enabled != nil {
+		hook.Enabled = *input.Enabled
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateWebhook(v, hook); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := utils.Envelope{"webhook": hook}
+	if input.RotateSecret {
+		hook.Secret, err = webhook.NewSecret()
+		if err != nil {
+			svc.serverErrorResponse(w, r, err)
+			return
+		}
+		env["secret"] = hook.Secret
+	}
+
+	err = svc.webhooks.UpdateWebhook(r.Context(), hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	svc.registry.set(hook)
+
+	err = utils.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWebhook maps to "DELETE /v1/webhooks/:id". Deletes a webhook along
+// with its deliveries.
+func (svc *service) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	err = svc.webhooks.DeleteWebhook(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	svc.registry.remove(id)
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookDeliveries maps to
+// "GET /v1/webhooks/:id/deliveries?status=&before=&limit=". Lists the
+// deliveries to a webhook along with their attempts, newest first.
+func (svc *service) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := svc.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	before, limit := utils.ReadPage(qs, "a delivery", v)
+
+	status := model.DeliveryStatus(qs.Get("status"))
+	if status != "" {
+		v.Check(utils.PermittedValue(string(status), string(model.DeliveryPending), string(model.DeliverySucceeded), string(model.DeliveryFailed)),
+			"status", "must be one of pending, succeeded or failed")
+	}
+
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, err := svc.webhooks.ListDeliveries(r.Context(), hook.ID, hook.Owner, status, before, limit)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhook maps to
+// "POST /v1/webhooks/:id/deliveries/:delivery_id/redeliver". Schedules a
+// delivery to be attempted again right away, with a fresh allowance of
+// retries.
+func (svc *service) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(httprouter.ParamsFromContext(r.Context()).ByName("delivery_id"))
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	d, err := svc.webhooks.Redeliver(r.Context(), deliveryID, id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	svc.registry.signal()
+
+	err = utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"delivery": d}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAnomalies maps to
+// "GET /v1/anomalies?actor_type=&actor_id=&min_score=&before=&limit=".
+// Lists the anomalies flagged in the logs visible to the calling service,
+// newest first.
+func (svc *service) listAnomalies(w http.ResponseWriter, r *http.Request) {
+	v := utils.NewValidator()
+
+	qs := r.URL.Query()
+	filter := mongodb.AnomalyFilter{
+		ActorType: qs.Get("actor_type"),
+		ActorID:   qs.Get("actor_id"),
+	}
+	filter.Before, filter.Limit = utils.ReadPage(qs, "an anomaly", v)
+
+	if s := qs.Get("min_score"); s != "" {
+		score, err := strconv.ParseFloat(s, 64)
+		v.Check(err == nil && score >= 0 && score <= 1, "min_score", "must be a number between 0 and 1")
+		filter.MinScore = score
+	}
+
+	if !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	anomalies, err := svc.anomalies.ListAnomalies(r.Context(), *svc.contextGetService(r), filter)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"anomalies": anomalies}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// readSchema retrieves the version of a schema of the calling service
+// whose ID is in the URL. It reports whether the schema was found, having
+// written an error response otherwise.
+func (svc *service) readSchema(w http.ResponseWriter, r *http.Request) (*model.Schema, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	schema, err := svc.schemas.GetSchema(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	schema.Active = svc.catalog.active(schema)
+
+	return schema, true
+}
+
+// createSchema maps to "POST /v1/schemas". Registers a new version of the
+// JSON Schema the logs of an action, and optionally of an entity type, of
+// the calling service are checked against.
+func (svc *service) createSchema(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Action     string          `json:"action"`
+		EntityType string          `json:"entity_type"`
+		Mode       string          `json:"mode"`
+		Schema     json.RawMessage `json:"schema"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		svc.badRequestResponse(w, r, err)
+		return
+	}
+
+	schema := &model.Schema{
+		Owner:      *svc.contextGetService(r),
+		Action:     input.Action,
+		EntityType: input.EntityType,
+		Mode:       input.Mode,
+		Document:   input.Schema,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateSchema(v, schema); !v.Valid() {
+		svc.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = svc.schemas.AddSchema(r.Context(), schema)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+	err = svc.catalog.set(schema)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+	schema.Active = true
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/schemas/%s", schema.ID.Hex()))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"schema": schema}, headers)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSchemas maps to "GET /v1/schemas?action=". Lists the versions of the
+// schemas of the calling service, newest first.
+func (svc *service) listSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas, err := svc.schemas.ListSchemas(r.Context(), *svc.contextGetService(r), r.URL.Query().Get("action"))
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, schema := range schemas {
+		schema.Active = svc.catalog.active(schema)
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"schemas": schemas}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// getSchema maps to "GET /v1/schemas/:id". Returns a version of a schema.
+func (svc *service) getSchema(w http.ResponseWriter, r *http.Request) {
+	schema, ok := svc.readSchema(w, r)
+	if !ok {
+		return
+	}
+
+	err := utils.WriteJSON(w, http.StatusOK, utils.Envelope{"schema": schema}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSchema maps to "DELETE /v1/schemas/:id". Deletes a version of a
+// schema. Logs are then checked against the previous version, if any.
+func (svc *service) deleteSchema(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		svc.notFoundResponse(w, r)
+		return
+	}
+
+	schema, err := svc.schemas.DeleteSchema(r.Context(), id, *svc.contextGetService(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			svc.notFoundResponse(w, r)
+		default:
+			svc.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = svc.refreshSchema(r.Context(), schema)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "schema successfully deleted"}, nil)
+	if err != nil {
+		svc.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+)
+
+func TestReadFilters(t *testing.T) {
+	svc := &service{}
+
+	logs := map[model.ServiceID]*model.Log{
+		"billing":  {ServiceID: "billing", Action: "paid"},
+		"payments": {ServiceID: "payments", Action: "paid"},
+	}
+	legacy := &model.Log{Action: "paid"}
+
+	// Test that each service only reads its own logs, and those recorded
+	// before logs were tied to a service
+	for caller := range logs {
+		caller := caller
+		r := httptest.NewRequest("GET", "/v1/logs?action=paid", nil)
+		r = svc.contextSetService(r, &caller)
+
+		v := utils.NewValidator()
+		input := svc.readFilters(r, r.URL.Query(), v)
+		if !v.Valid() {
+			t.Fatalf("Expected no error, got %v", v.Errors)
+		}
+		if input.ServiceID != caller {
+			t.Errorf("Expected filters restricted to %s, got %q", caller, input.ServiceID)
+		}
+
+		for owner, log := range logs {
+			if got := input.Match(log); got != (owner == caller) {
+				t.Errorf("Expected the log of %s to be visible to %s: %t, got %t", owner, caller, owner == caller, got)
+			}
+		}
+		if !input.Match(legacy) {
+			t.Errorf("Expected a log without service to be visible to %s", caller)
+		}
+	}
+}
+
+func TestReadSessionOptions(t *testing.T) {
+	svc := &service{}
+	svc.config.SessionKey = "session_id"
+	svc.config.SessionGap = 30 * time.Minute
+
+	tests := []struct {
+		query string
+		key   string
+		gap   time.Duration
+	}{
+		{"", "session_id", 30 * time.Minute},
+		{"session_key=tab_id&gap=15m", "tab_id", 15 * time.Minute},
+		// Test that an empty key turns grouping by session key off
+		{"session_key=", "", 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		qs, _ := url.ParseQuery(tt.query)
+		v := utils.NewValidator()
+		opts := svc.readSessionOptions(qs, v)
+		if !v.Valid() {
+			t.Errorf("%q: expected no error, got %v", tt.query, v.Errors)
+		}
+		if opts.Key != tt.key || opts.Gap != tt.gap {
+			t.Errorf("%q: expected key %q and gap %s, got %q and %s", tt.query, tt.key, tt.gap, opts.Key, opts.Gap)
+		}
+	}
+}
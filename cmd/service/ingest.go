@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/enrich"
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/plugin"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+)
+
+// processors returns the stages of the processor chain, in the order
+// they are named in.
+func processors(configured []utils.Processor, ip *enrich.IP, ua *enrich.UserAgent, plugins *plugin.Dir) ([]pipeline.Stage, error) {
+	builtin := map[string]pipeline.Stage{
+		"ip": {Processor: pipeline.Func("ip", func(_ context.Context, log *model.Log, _ *pipeline.Delivery) error {
+			ip.Enrich(log)
+			return nil
+		})},
+		"user_agent": {Processor: pipeline.Func("user_agent", func(_ context.Context, log *model.Log, _ *pipeline.Delivery) error {
+			ua.Enrich(log)
+			return nil
+		})},
+		// Plugins fail on their own, and are required or not on their own.
+		"plugins": {Processor: plugins},
+	}
+
+	var stages []pipeline.Stage
+	for _, p := range configured {
+		stage, ok := builtin[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q in PROCESSORS", p.Name)
+		}
+		stage.Required = p.Required
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// backfillSearchTerms sets the search terms of the logs stored before
+// extensions were searched, once at startup.
+func (svc *service) backfillSearchTerms() {
+	updated, err := svc.logs.BackfillSearchTerms(svc.ctx)
+	if err != nil {
+		svc.logger.PrintError(fmt.Errorf("backfill search terms: %w", err), nil)
+		return
+	}
+	if updated > 0 {
+		svc.logger.PrintInfo("search terms backfilled", map[string]string{"logs": fmt.Sprint(updated)})
+	}
+}
+
+// reloadPlugins loads the plugins changed since last loaded.
+func (svc *service) reloadPlugins() {
+	ctx, cancel := context.WithTimeout(svc.ctx, time.Minute)
+	defer cancel()
+
+	err := svc.plugins.Reload(ctx)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type": "failed to load plugins",
+			"dir":  svc.config.PluginDir,
+		})
+	}
+}
+
+// watchPlugins reloads the plugins as their directory changes, until the
+// service shuts down.
+func (svc *service) watchPlugins() {
+	ticker := time.NewTicker(svc.config.PluginReload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-svc.ctx.Done():
+			return
+		case <-ticker.C:
+			svc.reloadPlugins()
+		}
+	}
+}
+
+// processorFailed reports the failure of a processor, or of a plugin, on
+// a log.
+func (svc *service) processorFailed(processor string, err error) {
+	svc.logger.PrintError(err, map[string]string{
+		"type":      "processor failed",
+		"processor": processor,
+	})
+}
+
+// ingest stores the log delivered by an ingestion source, once checked
+// against the schema of its action and run through the processor chain,
+// then hands it to what follows stored logs. It returns an error wrapping
+// pipeline.ErrDropped when the log was dropped, and a *pipeline.Rejection
+// when it was refused.
+func (svc *service) ingest(d *pipeline.Delivery) error {
+	// Logs without a service are visible to every service, so only those
+	// recorded before logs were tied to one may have none.
+	if d.ServiceID == "" {
+		return pipeline.Reject("no service, app_id must be set")
+	}
+
+	var log model.Log
+	if err := json.Unmarshal(d.Body, &log); err != nil {
+		return pipeline.Reject(fmt.Sprintf("invalid JSON: %v", err))
+	}
+
+	// What the service derives about a log is never taken from producers.
+	// Findings are only set by detectors, once the log is stored, the ip
+	// and user_agent documents by their processors, if they run, and the
+	// score by full-text searches.
+	log.Findings = nil
+	log.IP = nil
+	log.UserAgent = nil
+	log.Score = 0
+
+	v := utils.NewValidator()
+	if utils.ValidateLog(v, &log); !v.Valid() {
+		return pipeline.Reject(fmt.Sprintf("invalid log: %v", v.Errors))
+	}
+
+	digest := sha256.Sum256(d.Body)
+	log.ServiceID = d.ServiceID
+	log.Integrity = &model.Integrity{
+		Algorithm:  "sha256",
+		Digest:     hex.EncodeToString(digest[:]),
+		ReceivedAt: d.ReceivedAt,
+	}
+
+	if err := svc.checkSchema(&log); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(svc.ctx, 5*time.Second)
+	defer cancel()
+
+	if err := svc.chain.Run(ctx, &log, d); err != nil {
+		return err
+	}
+
+	// Processors may not leave logs invalid.
+	v = utils.NewValidator()
+	if utils.ValidateLog(v, &log); !v.Valid() {
+		return pipeline.Reject(fmt.Sprintf("invalid log after processing: %v", v.Errors))
+	}
+
+	id, err := svc.logs.AddLog(&log)
+	if err != nil {
+		return err
+	}
+
+	svc.logger.PrintInfo("log added to data store", map[string]string{
+		"resource_id": fmt.Sprintf("%+v", id),
+	})
+
+	svc.detectTravel(&log)
+	svc.hub.Publish(&log)
+	svc.evaluateAlerts(&log)
+	svc.detectAnomalies(&log)
+	svc.notifyLog(&log)
+
+	return nil
+}
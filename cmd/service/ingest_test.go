@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+)
+
+func TestIngestWithoutService(t *testing.T) {
+	svc := &service{}
+
+	// Test that logs not tied to a service are rejected before anything
+	// else, as they would be visible to every service
+	err := svc.ingest(&pipeline.Delivery{Source: "amqp", Body: []byte(`{"action": "created"}`)})
+	var rejection *pipeline.Rejection
+	if !errors.As(err, &rejection) {
+		t.Errorf("Expected a rejection, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxRunningJobs bounds the query jobs run at once. Jobs submitted
+	// beyond it stay queued until a slot frees up.
+	maxRunningJobs = 4
+
+	// jobBatchSize is the number of logs materialized per write, which is
+	// also how often progress is recorded.
+	jobBatchSize = 500
+)
+
+// A jobRunner keeps track of the query jobs running in this process, so
+// they can be cancelled.
+type jobRunner struct {
+	slots   chan struct{}
+	mu      sync.Mutex
+	cancels map[primitive.ObjectID]context.CancelFunc
+}
+
+func newJobRunner() *jobRunner {
+	return &jobRunner{
+		slots:   make(chan struct{}, maxRunningJobs),
+		cancels: make(map[primitive.ObjectID]context.CancelFunc),
+	}
+}
+
+// cancel stops a job if it is running in this process.
+func (jr *jobRunner) cancel(id primitive.ObjectID) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	if cancel, ok := jr.cancels[id]; ok {
+		cancel()
+	}
+}
+
+// jobFilterValues turns the filter of a job back into the query string it
+// stands for.
+func jobFilterValues(filter map[string]string) url.Values {
+	qs := make(url.Values, len(filter))
+	for key, value := range filter {
+		qs.Set(key, value)
+	}
+	return qs
+}
+
+// resumeJobs starts the jobs left unfinished when the service last
+// stopped. They carry on from the last batch they materialized.
+func (svc *service) resumeJobs(ctx context.Context) error {
+	jobs, err := svc.jobs.UnfinishedJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		job := job
+		svc.background(func() { svc.runJob(job) })
+	}
+
+	if len(jobs) > 0 {
+		svc.logger.PrintInfo("resumed query jobs", map[string]string{
+			"count": fmt.Sprint(len(jobs)),
+		})
+	}
+	return nil
+}
+
+// runJob runs a query job to completion, unless it is cancelled or the
+// service shuts down first.
+func (svc *service) runJob(job *model.QueryJob) {
+	ctx, cancel := context.WithCancel(svc.ctx)
+
+	svc.runner.mu.Lock()
+	svc.runner.cancels[job.ID] = cancel
+	svc.runner.mu.Unlock()
+
+	defer func() {
+		svc.runner.mu.Lock()
+		delete(svc.runner.cancels, job.ID)
+		svc.runner.mu.Unlock()
+		cancel()
+	}()
+
+	select {
+	case svc.runner.slots <- struct{}{}:
+		defer func() { <-svc.runner.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	err := svc.executeJob(ctx, job)
+	switch {
+	case errors.Is(err, model.ErrJobFinished):
+		// Cancelled, or expired, while it ran.
+		return
+	case ctx.Err() != nil:
+		// Either cancelled, or the service is shutting down, in which
+		// case the job is resumed on the next start.
+		return
+	}
+
+	err = svc.jobs.FinishJob(context.Background(), job.ID, err)
+	if err != nil && !errors.Is(err, model.ErrJobFinished) {
+		svc.logger.PrintError(err, map[string]string{
+			"job_id": job.ID.Hex(),
+		})
+	}
+}
+
+// executeJob materializes the logs matched by the filter of a job, in
+// batches, starting after the last log materialized so far.
+func (svc *service) executeJob(ctx context.Context, job *model.QueryJob) error {
+	v := utils.NewValidator()
+	filter := utils.ReadFilters(jobFilterValues(job.Filter), v)
+	if !v.Valid() {
+		return errors.New("invalid filter")
+	}
+	filter.Sort = nil
+	filter.ServiceID = job.ServiceID
+
+	if job.Status == model.JobQueued {
+		matched, err := svc.logs.CountLogs(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		err = svc.jobs.StartJob(ctx, job.ID, matched)
+		if err != nil {
+			return err
+		}
+		job.Status, job.Matched = model.JobRunning, matched
+	}
+
+	if !job.Cursor.IsZero() {
+		filter.Cursor = &utils.Cursor{ID: job.Cursor}
+	}
+
+	batch := make([]*model.Log, 0, jobBatchSize)
+	err := svc.logs.StreamLogs(ctx, filter, func(log *model.Log) error {
+		batch = append(batch, log)
+		if len(batch) < jobBatchSize {
+			return nil
+		}
+
+		err := svc.jobs.AddResults(ctx, job, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return svc.jobs.AddResults(ctx, job, batch)
+}
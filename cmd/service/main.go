@@ -7,8 +7,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/alert"
+	"github.com/IkehAkinyemi/logaudit/internal/anomaly"
+	"github.com/IkehAkinyemi/logaudit/internal/enrich"
+	"github.com/IkehAkinyemi/logaudit/internal/geo"
 	"github.com/IkehAkinyemi/logaudit/internal/jsonlog"
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/plugin"
+	"github.com/IkehAkinyemi/logaudit/internal/query"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/mongodb"
+	"github.com/IkehAkinyemi/logaudit/internal/stream"
+	"github.com/IkehAkinyemi/logaudit/internal/travel"
 	"github.com/IkehAkinyemi/logaudit/internal/utils"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,8 +31,30 @@ type service struct {
 	config    utils.Config
 	logs      *mongodb.LogRepository
 	tokens    *mongodb.TokenRepository
+	jobs      *mongodb.JobRepository
+	views     *mongodb.ViewRepository
+	alerts    *mongodb.AlertRepository
+	engine    *alert.Engine
+	webhooks  *mongodb.WebhookRepository
+	anomalies *mongodb.AnomalyRepository
+	detector  *anomaly.Detector
+	geo       *geo.DB
+	chain     *pipeline.Pipeline
+	plugins   *plugin.Dir
+	travels   *mongodb.TravelRepository
+	traveller *travel.Detector
+	registry  *webhookRegistry
+	schemas   *mongodb.SchemaRepository
+	catalog   *schemaRegistry
 	msgBroker *msgBroker
+	runner    *jobRunner
+	hub       *stream.Hub
 	wg        sync.WaitGroup
+
+	// ctx is cancelled when the service shuts down, to stop the work
+	// running in the background.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func main() {
@@ -53,6 +84,57 @@ func main() {
 	logger.PrintInfo("database connection established", nil)
 	defer closeDB(client)
 
+	logs := mongodb.NewLogRepository(client)
+	jobs := mongodb.NewJobRepository(client)
+	views := mongodb.NewViewRepository(client)
+	alerts := mongodb.NewAlertRepository(client)
+	webhooks := mongodb.NewWebhookRepository(client)
+	anomalies := mongodb.NewAnomalyRepository(client)
+	travels := mongodb.NewTravelRepository(client)
+	schemas := mongodb.NewSchemaRepository(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = logs.EnsureIndexes(ctx, config.ExtensionIndexes)
+	if err == nil {
+		err = jobs.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = views.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = alerts.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = webhooks.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = anomalies.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = travels.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = schemas.EnsureIndexes(ctx)
+	}
+	cancel()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+
+	geoDB, err := geo.Open(config.GeoIPCityDB, config.GeoIPASNDB)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+	defer geoDB.Close()
+
+	tagger, err := enrich.LoadTagger(config.IPTags)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+
 	msgBroker, err := newMsgBroker(conn, "logs")
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -62,13 +144,72 @@ func main() {
 	service := &service{
 		logger:    logger,
 		config:    *config,
-		logs:      mongodb.NewLogRepository(client),
+		logs:      logs,
 		tokens:    mongodb.NewTokenRepository(client),
+		jobs:      jobs,
+		views:     views,
+		alerts:    alerts,
+		engine:    alert.NewEngine(),
+		webhooks:  webhooks,
+		registry:  newWebhookRegistry(),
+		schemas:   schemas,
+		catalog:   newSchemaRegistry(),
+		anomalies: anomalies,
+		geo:       geoDB,
+		travels:   travels,
+		traveller: travel.NewDetector(config.TravelMaxSpeed),
 		msgBroker: msgBroker,
+		runner:    newJobRunner(),
+		hub:       stream.NewHub(config.StreamMaxConns, config.StreamBuffer),
+	}
+	service.ctx, service.cancel = context.WithCancel(context.Background())
+	if config.AnomalyThreshold > 0 {
+		service.detector = anomaly.NewDetector(config.AnomalyThreshold)
+	}
+
+	limits := plugin.Limits{Timeout: config.PluginTimeout, Memory: config.PluginMemory}
+	service.plugins = plugin.OpenDir(config.PluginDir, limits, service.processorFailed)
+	defer service.plugins.Close(context.Background())
+
+	uaField, _ := query.LookupField(config.UserAgentField)
+	stages, err := processors(config.Processors, enrich.NewIP(geoDB, tagger), enrich.NewUserAgent(uaField), service.plugins)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+	service.chain = pipeline.New(stages, service.processorFailed)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = service.loadAlertRules(ctx)
+	if err == nil {
+		err = service.loadWebhooks(ctx)
+	}
+	if err == nil {
+		err = service.loadSchemas(ctx)
+	}
+	cancel()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+
+	if config.PluginDir != "" {
+		service.reloadPlugins()
+		service.background(service.watchPlugins)
 	}
 
+	service.background(service.backfillSearchTerms)
+	service.startWebhookWorkers()
 	go service.processLogs()
 
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = service.resumeJobs(ctx)
+	cancel()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+
 	err = service.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
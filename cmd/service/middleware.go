@@ -72,6 +72,12 @@ func (svc *service) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				// Aborting a response already under way is left to the
+				// server, which closes the connection without logging.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 				svc.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
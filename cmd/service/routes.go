@@ -18,6 +18,62 @@ func (svc *service) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/tokens/reset", svc.requiredAuthenticatedService(svc.resetToken))
 
 	router.HandlerFunc(http.MethodGet, "/v1/logs", svc.requiredAuthenticatedService(svc.GetLogs))
+	router.HandlerFunc(http.MethodGet, "/v1/logs/:id", svc.requiredAuthenticatedService(svc.logResource))
+
+	router.HandlerFunc(http.MethodGet, "/v1/entities/:type/:id/history", svc.requiredAuthenticatedService(svc.getEntityHistory))
+	router.HandlerFunc(http.MethodGet, "/v1/entities/:type/:id/state", svc.requiredAuthenticatedService(svc.getEntityState))
+	router.HandlerFunc(http.MethodGet, "/v1/actors/:type/:id/activity", svc.requiredAuthenticatedService(svc.getActorActivity))
+
+	router.HandlerFunc(http.MethodPost, "/v1/views", svc.requiredAuthenticatedService(svc.createView))
+	router.HandlerFunc(http.MethodGet, "/v1/views", svc.requiredAuthenticatedService(svc.listViews))
+	router.HandlerFunc(http.MethodGet, "/v1/views/:name", svc.requiredAuthenticatedService(svc.getView))
+	router.HandlerFunc(http.MethodPatch, "/v1/views/:name", svc.requiredAuthenticatedService(svc.updateView))
+	router.HandlerFunc(http.MethodDelete, "/v1/views/:name", svc.requiredAuthenticatedService(svc.deleteView))
+	router.HandlerFunc(http.MethodGet, "/v1/views/:name/logs", svc.requiredAuthenticatedService(svc.getViewLogs))
+	router.HandlerFunc(http.MethodPut, "/v1/views/:name/grants/:service_id", svc.requiredAuthenticatedService(svc.grantView))
+	router.HandlerFunc(http.MethodDelete, "/v1/views/:name/grants/:service_id", svc.requiredAuthenticatedService(svc.grantView))
+
+	router.HandlerFunc(http.MethodPost, "/v1/alert-rules", svc.requiredAuthenticatedService(svc.createAlertRule))
+	router.HandlerFunc(http.MethodGet, "/v1/alert-rules", svc.requiredAuthenticatedService(svc.listAlertRules))
+	router.HandlerFunc(http.MethodGet, "/v1/alert-rules/:id", svc.requiredAuthenticatedService(svc.getAlertRule))
+	router.HandlerFunc(http.MethodPatch, "/v1/alert-rules/:id", svc.requiredAuthenticatedService(svc.updateAlertRule))
+	router.HandlerFunc(http.MethodDelete, "/v1/alert-rules/:id", svc.requiredAuthenticatedService(svc.deleteAlertRule))
+	router.HandlerFunc(http.MethodGet, "/v1/alerts", svc.requiredAuthenticatedService(svc.listAlerts))
+	router.HandlerFunc(http.MethodGet, "/v1/anomalies", svc.requiredAuthenticatedService(svc.listAnomalies))
+
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", svc.requiredAuthenticatedService(svc.createWebhook))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", svc.requiredAuthenticatedService(svc.listWebhooks))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", svc.requiredAuthenticatedService(svc.getWebhook))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", svc.requiredAuthenticatedService(svc.updateWebhook))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", svc.requiredAuthenticatedService(svc.deleteWebhook))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", svc.requiredAuthenticatedService(svc.listWebhookDeliveries))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", svc.requiredAuthenticatedService(svc.redeliverWebhook))
+
+	router.HandlerFunc(http.MethodPost, "/v1/schemas", svc.requiredAuthenticatedService(svc.createSchema))
+	router.HandlerFunc(http.MethodGet, "/v1/schemas", svc.requiredAuthenticatedService(svc.listSchemas))
+	router.HandlerFunc(http.MethodGet, "/v1/schemas/:id", svc.requiredAuthenticatedService(svc.getSchema))
+	router.HandlerFunc(http.MethodDelete, "/v1/schemas/:id", svc.requiredAuthenticatedService(svc.deleteSchema))
+
+	router.HandlerFunc(http.MethodPost, "/v1/queries", svc.requiredAuthenticatedService(svc.submitQueryJob))
+	router.HandlerFunc(http.MethodGet, "/v1/queries/:id", svc.requiredAuthenticatedService(svc.getQueryJob))
+	router.HandlerFunc(http.MethodDelete, "/v1/queries/:id", svc.requiredAuthenticatedService(svc.cancelQueryJob))
+	router.HandlerFunc(http.MethodGet, "/v1/queries/:id/results", svc.requiredAuthenticatedService(svc.getQueryJobResults))
 
 	return svc.recoverPanic(svc.authenticate(router))
 }
+
+// logResource dispatches "GET /v1/logs/:id". httprouter does not allow
+// static routes next to a parameter at the same level, so the endpoints
+// under /v1/logs are told apart here.
+func (svc *service) logResource(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("id") {
+	case "stats":
+		svc.getLogStats(w, r)
+	case "export":
+		svc.exportLogs(w, r)
+	case "stream":
+		svc.streamLogs(w, r)
+	default:
+		svc.getLog(w, r)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// A schemaRegistry holds the latest version of the schema of each action,
+// and entity type, of every service in memory, compiled, so logs can be
+// checked against them as they are ingested.
+type schemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[schemaKey]*registeredSchema
+}
+
+type schemaKey struct {
+	owner      model.ServiceID
+	action     string
+	entityType string
+}
+
+type registeredSchema struct {
+	*model.Schema
+	compiled *jsonschema.Schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{schemas: make(map[schemaKey]*registeredSchema)}
+}
+
+func keyOf(schema *model.Schema) schemaKey {
+	return schemaKey{owner: schema.Owner, action: schema.Action, entityType: schema.EntityType}
+}
+
+// set makes a schema the one of its action and entity type, unless a later
+// version already is.
+func (sr *schemaRegistry) set(schema *model.Schema) error {
+	compiled, err := utils.CompileSchema(schema.Document)
+	if err != nil {
+		return err
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	key := keyOf(schema)
+	if old, ok := sr.schemas[key]; ok && old.Version > schema.Version {
+		return nil
+	}
+	sr.schemas[key] = &registeredSchema{Schema: schema, compiled: compiled}
+	return nil
+}
+
+// remove removes the schema of an action and entity type of a service.
+func (sr *schemaRegistry) remove(key schemaKey) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	delete(sr.schemas, key)
+}
+
+// lookup returns the schema a log is checked against: the one of its
+// action and entity type, or else the one of its action alone.
+func (sr *schemaRegistry) lookup(log *model.Log) (*registeredSchema, bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	key := schemaKey{owner: log.ServiceID, action: log.Action, entityType: log.Entity.Type}
+	if schema, ok := sr.schemas[key]; ok {
+		return schema, true
+	}
+	key.entityType = ""
+	schema, ok := sr.schemas[key]
+	return schema, ok
+}
+
+// active reports whether a version of a schema is the one logs are
+// checked against.
+func (sr *schemaRegistry) active(schema *model.Schema) bool {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	current, ok := sr.schemas[keyOf(schema)]
+	return ok && current.ID == schema.ID
+}
+
+// loadSchemas loads the latest version of the schemas of every service
+// into the registry.
+func (svc *service) loadSchemas(ctx context.Context) error {
+	schemas, err := svc.schemas.ActiveSchemas(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if err := svc.catalog.set(schema); err != nil {
+			return fmt.Errorf("schema %s: %w", schema.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// refreshSchema makes the latest remaining version of the schema of an
+// action and entity type the one logs are checked against, if any, once a
+// version was deleted.
+func (svc *service) refreshSchema(ctx context.Context, deleted *model.Schema) error {
+	latest, err := svc.schemas.LatestSchema(ctx, deleted.Owner, deleted.Action, deleted.EntityType)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		svc.catalog.remove(keyOf(deleted))
+		return nil
+	case err != nil:
+		return err
+	}
+
+	svc.catalog.remove(keyOf(deleted))
+	return svc.catalog.set(latest)
+}
+
+// checkSchema checks a log against the schema registered for its action,
+// if any. In strict mode, a log not matching it is rejected; in warn mode,
+// it is reported and stored anyway.
+func (svc *service) checkSchema(log *model.Log) error {
+	schema, ok := svc.catalog.lookup(log)
+	if !ok {
+		return nil
+	}
+
+	mode := schema.Mode
+	if mode == "" {
+		mode = svc.config.SchemaMode
+	}
+	if mode == utils.SchemaOff {
+		return nil
+	}
+
+	v := utils.NewValidator()
+	if utils.ValidateLogSchema(v, schema.compiled, log); v.Valid() {
+		return nil
+	}
+
+	if mode == utils.SchemaStrict {
+		return pipeline.Reject(fmt.Sprintf("log does not match schema version %d: %v", schema.Version, v.Errors))
+	}
+
+	svc.logger.PrintError(fmt.Errorf("log does not match schema: %v", v.Errors), map[string]string{
+		"type":       "schema violation",
+		"service_id": string(log.ServiceID),
+		"action":     log.Action,
+		"schema_id":  schema.ID.Hex(),
+		"version":    fmt.Sprint(schema.Version),
+	})
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/jsonlog"
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const amountSchema = `{"type": "object", "required": ["extension"], "properties": {"extension": {"required": ["amount"]}}}`
+
+func TestCheckSchema(t *testing.T) {
+	valid := &model.Log{ServiceID: "billing", Action: "paid", Extension: map[string]interface{}{"amount": 10}}
+	invalid := &model.Log{ServiceID: "billing", Action: "paid", Extension: map[string]interface{}{"currency": "EUR"}}
+
+	// Test that the mode of a schema takes precedence over the configured
+	// one, which applies to schemas without a mode
+	tests := []struct {
+		name       string
+		configured string
+		mode       string
+		rejected   bool
+		reported   bool
+	}{
+		{"strict", utils.SchemaStrict, "", true, false},
+		{"warn", utils.SchemaWarn, "", false, true},
+		{"off", utils.SchemaOff, "", false, false},
+		{"strict schema", utils.SchemaWarn, utils.SchemaStrict, true, false},
+		{"warn schema", utils.SchemaStrict, utils.SchemaWarn, false, true},
+		{"off schema", utils.SchemaStrict, utils.SchemaOff, false, false},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		svc := &service{catalog: newSchemaRegistry(), logger: jsonlog.New(&out, jsonlog.LevelInfo)}
+		svc.config.SchemaMode = tt.configured
+
+		schema := &model.Schema{ID: primitive.NewObjectID(), Owner: "billing", Action: "paid", Version: 1, Mode: tt.mode, Document: []byte(amountSchema)}
+		if err := svc.catalog.set(schema); err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+
+		if err := svc.checkSchema(valid); err != nil {
+			t.Errorf("%s: expected matching log to pass, got %v", tt.name, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: expected matching log not to be reported, got %s", tt.name, out.String())
+		}
+
+		err := svc.checkSchema(invalid)
+		var rejection *pipeline.Rejection
+		if got := errors.As(err, &rejection); got != tt.rejected {
+			t.Errorf("%s: expected rejection %t, got %v", tt.name, tt.rejected, err)
+		}
+		if !tt.rejected && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if got := out.Len() > 0; got != tt.reported {
+			t.Errorf("%s: expected report %t, got %q", tt.name, tt.reported, out.String())
+		}
+	}
+}
+
+func TestSchemaLookup(t *testing.T) {
+	sr := newSchemaRegistry()
+	schemas := []*model.Schema{
+		{ID: primitive.NewObjectID(), Owner: "billing", Action: "paid", Version: 1, Document: []byte(`{}`)},
+		{ID: primitive.NewObjectID(), Owner: "billing", Action: "paid", EntityType: "invoice", Version: 1, Document: []byte(`{}`)},
+		{ID: primitive.NewObjectID(), Owner: "payments", Action: "refunded", EntityType: "invoice", Version: 1, Document: []byte(`{}`)},
+	}
+	for _, schema := range schemas {
+		if err := sr.set(schema); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	// Test that logs get the schema of their entity type, else of their
+	// action alone, and only those of their own service
+	tests := []struct {
+		log      *model.Log
+		expected *model.Schema
+	}{
+		{&model.Log{ServiceID: "billing", Action: "paid", Entity: model.Entity{Type: "invoice"}}, schemas[1]},
+		{&model.Log{ServiceID: "billing", Action: "paid", Entity: model.Entity{Type: "order"}}, schemas[0]},
+		{&model.Log{ServiceID: "billing", Action: "paid"}, schemas[0]},
+		{&model.Log{ServiceID: "payments", Action: "refunded", Entity: model.Entity{Type: "invoice"}}, schemas[2]},
+		{&model.Log{ServiceID: "payments", Action: "refunded", Entity: model.Entity{Type: "order"}}, nil},
+		{&model.Log{ServiceID: "payments", Action: "paid", Entity: model.Entity{Type: "invoice"}}, nil},
+	}
+
+	for i, tt := range tests {
+		schema, ok := sr.lookup(tt.log)
+		switch {
+		case tt.expected == nil && ok:
+			t.Errorf("%d: expected no schema, got %s", i, schema.ID.Hex())
+		case tt.expected != nil && (!ok || schema.ID != tt.expected.ID):
+			t.Errorf("%d: expected schema %s, got %v", i, tt.expected.ID.Hex(), schema)
+		}
+	}
+}
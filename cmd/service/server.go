@@ -21,8 +21,13 @@ func (svc *service) serve() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		ConnContext:  contextSetConn,
 	}
 
+	// Live streams never go idle, so they are ended as soon as the server
+	// starts shutting down rather than waited for.
+	server.RegisterOnShutdown(svc.hub.Close)
+
 	shutdownErr := make(chan error)
 
 	// Background job to listen for any shutdown signal
@@ -47,6 +52,7 @@ func (svc *service) serve() error {
 			"addr": server.Addr,
 		})
 
+		svc.cancel()
 		svc.wg.Wait()
 		shutdownErr <- nil
 	}()
@@ -72,3 +78,21 @@ func (svc *service) serve() error {
 
 	return nil
 }
+
+// background runs fn in a goroutine the server waits for when shutting
+// down. Long-running work should return once svc.ctx is done.
+func (svc *service) background(fn func()) {
+	svc.wg.Add(1)
+
+	go func() {
+		defer svc.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				svc.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
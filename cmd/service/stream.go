@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/stream"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamWriteWait bounds the time a write to a live stream may take.
+	streamWriteWait = 10 * time.Second
+	// streamPingPeriod is how often idle live streams are kept alive.
+	streamPingPeriod = 15 * time.Second
+	// streamPongWait is how long a WebSocket client may go without
+	// answering pings before it is considered gone.
+	streamPongWait = time.Minute
+	// streamRetry is the delay, in milliseconds, after which SSE clients
+	// reconnect to a stream that ended.
+	streamRetry = 3000
+)
+
+// A logStream pushes logs to a client tailing them live.
+type logStream interface {
+	// send pushes the JSON representation of the log with the given ID.
+	send(id string, data []byte) error
+	// ping keeps the connection alive while no log is sent.
+	ping() error
+	// gone returns a channel closed once the client disconnected.
+	gone() <-chan struct{}
+	// end closes the stream, telling the client why when reason is not
+	// nil.
+	end(reason error) error
+}
+
+// An eventStream pushes logs as Server-Sent Events, each identified by the
+// ID of its log so reconnecting clients send it back as Last-Event-ID.
+type eventStream struct {
+	svc     *service
+	w       http.ResponseWriter
+	r       *http.Request
+	flusher http.Flusher
+}
+
+// newEventStream starts a Server-Sent Events response.
+func (svc *service) newEventStream(w http.ResponseWriter, r *http.Request) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer does not support flushing")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &eventStream{svc: svc, w: w, r: r, flusher: flusher}
+	return s, s.write(fmt.Sprintf("retry: %d\n\n", streamRetry))
+}
+
+func (s *eventStream) write(msg string) error {
+	err := s.svc.extendWriteDeadline(s.r, streamWriteWait)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(s.w, msg)
+	if err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *eventStream) send(id string, data []byte) error {
+	return s.write(fmt.Sprintf("id: %s\nevent: log\ndata: %s\n\n", id, data))
+}
+
+func (s *eventStream) ping() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *eventStream) gone() <-chan struct{} {
+	return s.r.Context().Done()
+}
+
+func (s *eventStream) end(reason error) error {
+	if reason == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]string{"error": streamEndMessage(reason)})
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("event: error\ndata: %s\n\n", data))
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// A webSocketStream pushes logs as WebSocket text messages holding the ID
+// of the log and the log itself.
+type webSocketStream struct {
+	conn *websocket.Conn
+	done chan struct{}
+}
+
+// newWebSocketStream upgrades the connection to the WebSocket protocol.
+// Upgrade failures have been answered when it returns an error.
+func newWebSocketStream(w http.ResponseWriter, r *http.Request) (*webSocketStream, error) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &webSocketStream{conn: conn, done: make(chan struct{})}
+
+	// Clients only send control frames. Reading processes them, and tells
+	// when the client left or stopped answering pings.
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	go func() {
+		defer close(s.done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *webSocketStream) send(id string, data []byte) error {
+	msg, err := json.Marshal(struct {
+		ID  string          `json:"id"`
+		Log json.RawMessage `json:"log"`
+	}{id, data})
+	if err != nil {
+		return err
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return s.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (s *webSocketStream) ping() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait))
+}
+
+func (s *webSocketStream) gone() <-chan struct{} {
+	return s.done
+}
+
+func (s *webSocketStream) end(reason error) error {
+	defer s.conn.Close()
+
+	code := websocket.CloseNormalClosure
+	switch {
+	case errors.Is(reason, stream.ErrSlowSubscriber):
+		code = websocket.CloseTryAgainLater
+	case errors.Is(reason, stream.ErrClosed):
+		code = websocket.CloseGoingAway
+	case reason != nil:
+		code = websocket.CloseInternalServerErr
+	}
+
+	msg := websocket.FormatCloseMessage(code, streamEndMessage(reason))
+	return s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait))
+}
+
+// streamEndMessage tells clients why a live stream ended.
+func streamEndMessage(reason error) string {
+	switch {
+	case reason == nil:
+		return ""
+	case errors.Is(reason, stream.ErrSlowSubscriber):
+		return "the client fell behind, resume from the last event received"
+	case errors.Is(reason, stream.ErrClosed):
+		return "the server is shutting down, resume from the last event received"
+	default:
+		return "the server encountered a problem and could not continue the stream"
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/travel"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// detectTravel checks a stored log against the travel history of its
+// actor. Findings raise alerts, and are attached to the log.
+func (svc *service) detectTravel(log *model.Log) {
+	if log.Actor.ID == "" {
+		return
+	}
+
+	if log.IP == nil && log.Context.Location == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	h, err := svc.travels.GetHistory(ctx, log.ServiceID, log.Actor.Type, log.Actor.ID)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		h = travel.NewHistory(log)
+	case err != nil:
+		svc.logger.PrintError(err, map[string]string{
+			"type":     "failed to read travel history",
+			"actor_id": log.Actor.ID,
+		})
+		return
+	}
+
+	findings := svc.traveller.Observe(h, log)
+
+	err = svc.travels.SaveHistory(ctx, h)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type":     "failed to save travel history",
+			"actor_id": log.Actor.ID,
+		})
+	}
+
+	if len(findings) == 0 {
+		return
+	}
+
+	group := map[string]interface{}{"actor_type": log.Actor.Type, "actor_id": log.Actor.ID}
+	if log.Context.IPAddr != "" {
+		group["ip_address"] = log.Context.IPAddr
+	}
+
+	for i, f := range findings {
+		alert := &model.Alert{
+			ID:         primitive.NewObjectID(),
+			Owner:      log.ServiceID,
+			RuleName:   f.Kind,
+			Severity:   f.Severity,
+			Group:      group,
+			Count:      1,
+			FirstEvent: log.Timestamp,
+			LastEvent:  log.Timestamp,
+			LogID:      log.ID,
+			Detail:     f.Detail,
+			CreatedAt:  time.Now().UTC(),
+		}
+
+		err := svc.alerts.AddAlert(ctx, alert)
+		if err != nil {
+			svc.logger.PrintError(err, map[string]string{
+				"type":   "failed to write alert",
+				"log_id": log.ID.Hex(),
+			})
+			continue
+		}
+		findings[i].AlertID = alert.ID
+
+		svc.logger.PrintInfo("alert raised", map[string]string{
+			"service_id": string(alert.Owner),
+			"rule":       alert.RuleName,
+			"severity":   alert.Severity,
+			"alert_id":   alert.ID.Hex(),
+			"log_id":     alert.LogID.Hex(),
+		})
+		svc.notifyAlert(alert)
+	}
+
+	err = svc.logs.AttachFindings(ctx, log.ID, findings)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type":   "failed to attach findings",
+			"log_id": log.ID.Hex(),
+		})
+		return
+	}
+	log.Findings = append(log.Findings, findings...)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"github.com/IkehAkinyemi/logaudit/internal/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// webhookWorkers is the number of deliveries attempted at once.
+	webhookWorkers = 4
+	// webhookTimeout bounds an attempt at a delivery.
+	webhookTimeout = 10 * time.Second
+	// webhookLease is how long a claimed delivery is held before another
+	// worker, or the service once restarted, may claim it again.
+	webhookLease = time.Minute
+	// webhookPoll is how often workers look for retries coming due.
+	webhookPoll = 5 * time.Second
+)
+
+// A webhookRegistry holds the webhooks of every service in memory, with
+// their filters parsed, so stored logs can be matched against them.
+type webhookRegistry struct {
+	mu    sync.RWMutex
+	hooks map[primitive.ObjectID]*registeredWebhook
+
+	// due signals the workers that a delivery was enqueued.
+	due chan struct{}
+}
+
+type registeredWebhook struct {
+	*model.Webhook
+	filter utils.Filters
+}
+
+func newWebhookRegistry() *webhookRegistry {
+	return &webhookRegistry{
+		hooks: make(map[primitive.ObjectID]*registeredWebhook),
+		due:   make(chan struct{}, 1),
+	}
+}
+
+// set adds a webhook to the registry, or replaces the one with the same ID.
+func (wr *webhookRegistry) set(hook *model.Webhook) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.hooks[hook.ID] = &registeredWebhook{Webhook: hook, filter: utils.WebhookFilters(hook)}
+}
+
+// remove removes a webhook from the registry.
+func (wr *webhookRegistry) remove(id primitive.ObjectID) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	delete(wr.hooks, id)
+}
+
+// get returns a webhook by its ID.
+func (wr *webhookRegistry) get(id primitive.ObjectID) (*model.Webhook, bool) {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	hook, ok := wr.hooks[id]
+	if !ok {
+		return nil, false
+	}
+	return hook.Webhook, true
+}
+
+// subscribed returns the enabled webhooks subscribed to an event for which
+// match holds.
+func (wr *webhookRegistry) subscribed(event string, match func(*registeredWebhook) bool) []*model.Webhook {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	var hooks []*model.Webhook
+	for _, hook := range wr.hooks {
+		if hook.Enabled && utils.PermittedValue(event, hook.Events...) && match(hook) {
+			hooks = append(hooks, hook.Webhook)
+		}
+	}
+	return hooks
+}
+
+// signal wakes up a worker waiting for deliveries.
+func (wr *webhookRegistry) signal() {
+	select {
+	case wr.due <- struct{}{}:
+	default:
+	}
+}
+
+// loadWebhooks loads the webhooks of every service into the registry.
+func (svc *service) loadWebhooks(ctx context.Context) error {
+	hooks, err := svc.webhooks.AllWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		svc.registry.set(hook)
+	}
+	return nil
+}
+
+// notifyLog enqueues deliveries of a stored log to the webhooks whose
+// owner may read it and whose filter it matches.
+func (svc *service) notifyLog(log *model.Log) {
+	hooks := svc.registry.subscribed(webhook.EventLogStored, func(hook *registeredWebhook) bool {
+		return hook.filter.Match(log)
+	})
+	svc.enqueueDeliveries(hooks, webhook.EventLogStored, utils.Envelope{"log": log})
+}
+
+// notifyAlert enqueues deliveries of a raised alert to the webhooks of the
+// owner of its rule.
+func (svc *service) notifyAlert(alert *model.Alert) {
+	hooks := svc.registry.subscribed(webhook.EventAlertRaised, func(hook *registeredWebhook) bool {
+		return hook.Owner == alert.Owner
+	})
+	svc.enqueueDeliveries(hooks, webhook.EventAlertRaised, utils.Envelope{"alert": alert})
+}
+
+// notifyAnomaly enqueues deliveries of an anomaly to the webhooks whose
+// owner may read the log it was flagged in.
+func (svc *service) notifyAnomaly(a *model.Anomaly) {
+	hooks := svc.registry.subscribed(webhook.EventAnomalyDetected, func(hook *registeredWebhook) bool {
+		return a.ServiceID == "" || hook.Owner == a.ServiceID
+	})
+	svc.enqueueDeliveries(hooks, webhook.EventAnomalyDetected, utils.Envelope{"anomaly": a})
+}
+
+// enqueueDeliveries saves a pending delivery of an event to each webhook,
+// for the workers to attempt.
+func (svc *service) enqueueDeliveries(hooks []*model.Webhook, event string, data utils.Envelope) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	for _, hook := range hooks {
+		id := primitive.NewObjectID()
+		payload, err := json.Marshal(utils.Envelope{
+			"id":         id.Hex(),
+			"event":      event,
+			"created_at": now,
+			"data":       data,
+		})
+		if err != nil {
+			svc.logger.PrintError(err, map[string]string{
+				"type":       "failed to encode webhook payload",
+				"webhook_id": hook.ID.Hex(),
+			})
+			continue
+		}
+
+		err = svc.webhooks.AddDelivery(ctx, &model.Delivery{
+			ID:          id,
+			WebhookID:   hook.ID,
+			Owner:       hook.Owner,
+			Event:       event,
+			Payload:     string(payload),
+			Status:      model.DeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil {
+			svc.logger.PrintError(err, map[string]string{
+				"type":       "failed to write webhook delivery",
+				"webhook_id": hook.ID.Hex(),
+			})
+		}
+	}
+
+	svc.registry.signal()
+}
+
+// startWebhookWorkers starts the workers attempting the deliveries due.
+func (svc *service) startWebhookWorkers() {
+	client := &http.Client{
+		Timeout: webhookTimeout,
+		// Receivers are only connected to on the public internet,
+		// whatever their host name resolves to.
+		Transport: webhook.NewTransport(),
+		// Receivers acknowledge notifications themselves; a redirect
+		// counts as a failed attempt.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for i := 0; i < webhookWorkers; i++ {
+		svc.background(func() { svc.deliverWebhooks(client) })
+	}
+}
+
+// deliverWebhooks attempts the deliveries due until the service shuts
+// down, waiting for more whenever none is.
+func (svc *service) deliverWebhooks(client *http.Client) {
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+
+	for {
+		d, err := svc.webhooks.ClaimDelivery(svc.ctx, webhookLease)
+		switch {
+		case err == nil:
+			svc.attemptDelivery(client, d)
+			continue
+		case errors.Is(err, model.ErrRecordNotFound):
+		case svc.ctx.Err() == nil:
+			svc.logger.PrintError(err, map[string]string{
+				"type": "failed to claim webhook delivery",
+			})
+		}
+
+		select {
+		case <-svc.ctx.Done():
+			return
+		case <-svc.registry.due:
+		case <-ticker.C:
+		}
+	}
+}
+
+// attemptDelivery posts a delivery to its webhook and records the attempt.
+// Failed deliveries are retried with an exponential backoff until they run
+// out of attempts.
+func (svc *service) attemptDelivery(client *http.Client, d *model.Delivery) {
+	start := time.Now()
+	attempt := model.DeliveryAttempt{At: start.UTC()}
+
+	hook, ok := svc.registry.get(d.WebhookID)
+	switch {
+	case !ok:
+		attempt.Error = "webhook deleted"
+	case !hook.Enabled:
+		attempt.Error = "webhook disabled"
+	default:
+		code, err := webhook.Send(svc.ctx, client, webhook.Notification{
+			ID:      d.ID.Hex(),
+			Event:   d.Event,
+			URL:     hook.URL,
+			Secret:  hook.Secret,
+			Payload: []byte(d.Payload),
+		})
+		if err != nil && svc.ctx.Err() != nil {
+			// Shutting down: the lease runs out and the delivery is
+			// claimed again once the service restarts.
+			return
+		}
+		attempt.StatusCode = code
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+	}
+	attempt.Duration = time.Since(start).Milliseconds()
+
+	status, next := model.DeliverySucceeded, time.Time{}
+	switch tries := d.Tries + 1; {
+	case attempt.Error == "":
+	case ok && hook.Enabled && tries < webhook.MaxAttempts:
+		status, next = model.DeliveryPending, time.Now().UTC().Add(webhook.Backoff(tries))
+	default:
+		status = model.DeliveryFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := svc.webhooks.RecordAttempt(ctx, d.ID, attempt, status, next)
+	if err != nil {
+		svc.logger.PrintError(err, map[string]string{
+			"type":        "failed to record webhook delivery attempt",
+			"delivery_id": d.ID.Hex(),
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package alert evaluates alert rules against the logs the service stores.
+package alert
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sweepEvery is the number of evaluations between two sweeps of the
+// states whose window has passed.
+const sweepEvery = 1000
+
+// An Engine evaluates logs against a set of alert rules, keeping the
+// counts of windowed rules in memory. The states it reports as changed
+// are meant to be persisted and restored, so counts survive restarts.
+type Engine struct {
+	mu          sync.Mutex
+	rules       map[primitive.ObjectID]*rule
+	states      map[stateKey]*model.AlertState
+	evaluations int
+}
+
+type rule struct {
+	*model.AlertRule
+	expr query.Expr
+}
+
+type stateKey struct {
+	rule  primitive.ObjectID
+	group string
+}
+
+// NewEngine returns an engine without rules.
+func NewEngine() *Engine {
+	return &Engine{
+		rules:  make(map[primitive.ObjectID]*rule),
+		states: make(map[stateKey]*model.AlertState),
+	}
+}
+
+// SetRule adds a rule to the engine, or replaces the rule with the same
+// ID, in which case the counts of the rule start over.
+func (e *Engine) SetRule(r *model.AlertRule) error {
+	expr, err := query.Parse(r.Query)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.dropStates(r.ID)
+	e.rules[r.ID] = &rule{AlertRule: r, expr: expr}
+	return nil
+}
+
+// RemoveRule removes a rule from the engine.
+func (e *Engine) RemoveRule(id primitive.ObjectID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.dropStates(id)
+	delete(e.rules, id)
+}
+
+func (e *Engine) dropStates(id primitive.ObjectID) {
+	for key := range e.states {
+		if key.rule == id {
+			delete(e.states, key)
+		}
+	}
+}
+
+// Restore loads persisted states. States of unknown rules are ignored.
+func (e *Engine) Restore(states []*model.AlertState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range states {
+		if _, ok := e.rules[s.RuleID]; ok {
+			e.states[stateKey{s.RuleID, s.Group}] = s
+		}
+	}
+}
+
+// Evaluate runs a log through the rules. It returns the alerts raised,
+// and copies of the states it changed.
+func (e *Engine) Evaluate(log *model.Log) ([]*model.Alert, []*model.AlertState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.evaluations++; e.evaluations%sweepEvery == 0 {
+		e.sweep(time.Now())
+	}
+
+	var (
+		alerts  []*model.Alert
+		changed []*model.AlertState
+	)
+	for _, r := range e.rules {
+		if !r.Enabled || !visible(r.AlertRule, log) || !query.Match(r.expr, log) {
+			continue
+		}
+
+		group, values, ok := groupOf(r.AlertRule, log)
+		if !ok {
+			continue
+		}
+
+		if r.Threshold <= 1 {
+			alerts = append(alerts, newAlert(r.AlertRule, values, log, log.Timestamp, 1))
+			continue
+		}
+
+		key := stateKey{r.ID, group}
+		s, ok := e.states[key]
+		if !ok {
+			s = &model.AlertState{RuleID: r.ID, Group: group}
+			e.states[key] = s
+		}
+
+		if count, first, fired := record(s, log.Timestamp, time.Duration(r.Window), r.Threshold); fired {
+			alerts = append(alerts, newAlert(r.AlertRule, values, log, first, count))
+		}
+		changed = append(changed, copyState(s))
+	}
+
+	return alerts, changed
+}
+
+// sweep drops the states whose window has passed.
+func (e *Engine) sweep(now time.Time) {
+	for key, s := range e.states {
+		if s.ExpiresAt.Before(now) {
+			delete(e.states, key)
+		}
+	}
+}
+
+// visible reports whether the owner of a rule may read a log.
+func visible(r *model.AlertRule, log *model.Log) bool {
+	return log.ServiceID == model.AnonymousService || log.ServiceID == r.Owner
+}
+
+// groupOf returns the values of the group-by fields of a rule in a log,
+// keyed by field and as a JSON array. Logs missing one of the fields are
+// not counted.
+func groupOf(r *model.AlertRule, log *model.Log) (string, map[string]interface{}, bool) {
+	if len(r.GroupBy) == 0 {
+		return "[]", nil, true
+	}
+
+	values := make(map[string]interface{}, len(r.GroupBy))
+	list := make([]interface{}, 0, len(r.GroupBy))
+	for _, name := range r.GroupBy {
+		field, ok := query.LookupField(name)
+		if !ok {
+			return "", nil, false
+		}
+		value, ok := field.Value(log)
+		if !ok {
+			return "", nil, false
+		}
+		values[name] = value
+		list = append(list, value)
+	}
+
+	group, err := json.Marshal(list)
+	if err != nil {
+		return "", nil, false
+	}
+	return string(group), values, true
+}
+
+// record counts a log happening at t in a state. Only the logs within the
+// window ending at the latest one count, and only the latest threshold of
+// them need keeping. Reaching the threshold fires, and counting starts
+// over; record then returns the count and the time of the first log.
+func record(s *model.AlertState, t time.Time, window time.Duration, threshold int) (int, time.Time, bool) {
+	i := sort.Search(len(s.Events), func(i int) bool { return s.Events[i].After(t) })
+	s.Events = append(s.Events, time.Time{})
+	copy(s.Events[i+1:], s.Events[i:])
+	s.Events[i] = t
+
+	latest := s.Events[len(s.Events)-1]
+	start := latest.Add(-window)
+	i = sort.Search(len(s.Events), func(i int) bool { return !s.Events[i].Before(start) })
+	s.Events = s.Events[i:]
+	if len(s.Events) > threshold {
+		s.Events = s.Events[len(s.Events)-threshold:]
+	}
+	s.ExpiresAt = latest.Add(window)
+
+	if len(s.Events) < threshold {
+		return 0, time.Time{}, false
+	}
+
+	count, first := len(s.Events), s.Events[0]
+	s.Events = []time.Time{}
+	return count, first, true
+}
+
+func copyState(s *model.AlertState) *model.AlertState {
+	c := *s
+	c.Events = append([]time.Time{}, s.Events...)
+	return &c
+}
+
+func newAlert(r *model.AlertRule, group map[string]interface{}, log *model.Log, first time.Time, count int) *model.Alert {
+	return &model.Alert{
+		ID:         primitive.NewObjectID(),
+		RuleID:     r.ID,
+		Owner:      r.Owner,
+		RuleName:   r.Name,
+		Severity:   r.Severity,
+		Group:      group,
+		Count:      count,
+		FirstEvent: first,
+		LastEvent:  log.Timestamp,
+		LogID:      log.ID,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
@@ -0,0 +1,121 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func failedLogin(ip string, t time.Time) *model.Log {
+	return &model.Log{
+		ID:        primitive.NewObjectID(),
+		Timestamp: t,
+		Action:    "login_failed",
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Context:   model.Context{IPAddr: ip},
+		ServiceID: "auth",
+	}
+}
+
+func TestEvaluateEvent(t *testing.T) {
+	engine := NewEngine()
+	rule := &model.AlertRule{
+		ID:        primitive.NewObjectID(),
+		Owner:     "accounts",
+		Name:      "deactivation-by-non-admin",
+		Query:     "action = deactivated AND actor.extension.role != admin",
+		Threshold: 1,
+		Enabled:   true,
+	}
+	if err := engine.SetRule(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test with a matching log
+	log := &model.Log{
+		Action:    "deactivated",
+		Actor:     model.Actor{Type: "user", ID: "12300", Extension: map[string]interface{}{"role": "support"}},
+		ServiceID: "accounts",
+	}
+	alerts, states := engine.Evaluate(log)
+	if len(alerts) != 1 || alerts[0].RuleID != rule.ID || alerts[0].Count != 1 || len(states) != 0 {
+		t.Errorf("Expected one alert and no state, got %v and %v", alerts, states)
+	}
+
+	// Test with logs not matching, or not visible to the owner
+	log.Actor.Extension["role"] = "admin"
+	if alerts, _ := engine.Evaluate(log); len(alerts) != 0 {
+		t.Errorf("Expected no alert for an admin, got %v", alerts)
+	}
+	log.Actor.Extension["role"] = "support"
+	log.ServiceID = "billing"
+	if alerts, _ := engine.Evaluate(log); len(alerts) != 0 {
+		t.Errorf("Expected no alert for another service's log, got %v", alerts)
+	}
+
+	// Test with the rule disabled
+	rule.Enabled = false
+	log.ServiceID = "accounts"
+	if alerts, _ := engine.Evaluate(log); len(alerts) != 0 {
+		t.Errorf("Expected no alert from a disabled rule, got %v", alerts)
+	}
+}
+
+func TestEvaluateThreshold(t *testing.T) {
+	engine := NewEngine()
+	rule := &model.AlertRule{
+		ID:        primitive.NewObjectID(),
+		Owner:     "auth",
+		Name:      "failed-logins",
+		Query:     "action = login_failed",
+		GroupBy:   []string{"context.ip_address"},
+		Threshold: 3,
+		Window:    model.Duration(5 * time.Minute),
+		Enabled:   true,
+	}
+	if err := engine.SetRule(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	// Test that logs outside the window or from other groups do not count
+	for _, log := range []*model.Log{
+		failedLogin("10.0.0.1", start),
+		failedLogin("10.0.0.2", start.Add(5*time.Minute)),
+		failedLogin("10.0.0.1", start.Add(6*time.Minute)),
+		failedLogin("10.0.0.1", start.Add(7*time.Minute)),
+	} {
+		if alerts, _ := engine.Evaluate(log); len(alerts) != 0 {
+			t.Errorf("Expected no alert, got %v", alerts)
+		}
+	}
+
+	// Test that reaching the threshold fires, with the counted logs
+	alerts, states := engine.Evaluate(failedLogin("10.0.0.1", start.Add(8*time.Minute)))
+	if len(alerts) != 1 {
+		t.Fatalf("Expected one alert, got %v", alerts)
+	}
+	if a := alerts[0]; a.Count != 3 || !a.FirstEvent.Equal(start.Add(6*time.Minute)) || a.Group["context.ip_address"] != "10.0.0.1" {
+		t.Errorf("Unexpected alert %+v", a)
+	}
+	if len(states) != 1 || len(states[0].Events) != 0 || states[0].Group != `["10.0.0.1"]` {
+		t.Errorf("Expected the counts to start over, got %+v", states)
+	}
+
+	// Test that restored states carry on counting
+	restored := NewEngine()
+	if err := restored.SetRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	restored.Restore([]*model.AlertState{{
+		RuleID: rule.ID,
+		Group:  `["10.0.0.2"]`,
+		Events: []time.Time{start.Add(9 * time.Minute), start.Add(10 * time.Minute)},
+	}})
+	if alerts, _ := restored.Evaluate(failedLogin("10.0.0.2", start.Add(11*time.Minute))); len(alerts) != 1 {
+		t.Errorf("Expected one alert, got %v", alerts)
+	}
+}
@@ -0,0 +1,318 @@
+// Package anomaly maintains baselines of the usual activity of actors and
+// scores the logs they produce against them.
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of deviations from a baseline.
+const (
+	KindAction     = "action"
+	KindEntityType = "entity_type"
+	KindLocation   = "location"
+	KindIPRange    = "ip_range"
+	KindRate       = "rate"
+)
+
+// weights bound the score each kind of deviation contributes.
+var weights = map[string]float64{
+	KindAction:     0.6,
+	KindEntityType: 0.4,
+	KindLocation:   0.5,
+	KindIPRange:    0.5,
+	KindRate:       0.7,
+}
+
+var labels = map[string]string{
+	KindAction:     "action",
+	KindEntityType: "entity type",
+	KindLocation:   "location",
+	KindIPRange:    "IP range",
+}
+
+const (
+	// maxValues bounds the values kept per dimension of a baseline.
+	maxValues = 64
+	// minWeight is the weight under which a value is forgotten.
+	minWeight = 0.05
+	// rareShare is the share of the activity of an actor under which a
+	// value is unusual.
+	rareShare = 0.02
+
+	// rateAlpha is the smoothing factor of the hourly rate averages.
+	rateAlpha = 0.1
+	// minRate is the fewest logs in an hour that can be unusual.
+	minRate = 10
+	// minHours is the number of active hours needed before rates are
+	// scored.
+	minHours = 5
+	// rateDeviations is how many standard deviations above the average a
+	// rate has to be to be unusual.
+	rateDeviations = 3
+)
+
+// A Detector scores logs against the baselines of their actors, and
+// updates the baselines with them.
+type Detector struct {
+	// Threshold is the score from which logs are anomalies.
+	Threshold float64
+	// MinEvents is the number of logs of an actor needed before its
+	// baseline is trusted.
+	MinEvents int64
+	// HalfLife is the time it takes for the weight of a value to halve.
+	HalfLife time.Duration
+}
+
+// NewDetector returns a detector flagging logs scoring at least threshold.
+func NewDetector(threshold float64) *Detector {
+	return &Detector{
+		Threshold: threshold,
+		MinEvents: 50,
+		HalfLife:  14 * 24 * time.Hour,
+	}
+}
+
+// NewBaseline returns an empty baseline for the actor of a log.
+func NewBaseline(log *model.Log) *model.Baseline {
+	return &model.Baseline{
+		ServiceID:   log.ServiceID,
+		ActorType:   log.Actor.Type,
+		ActorID:     log.Actor.ID,
+		Actions:     []model.Frequency{},
+		EntityTypes: []model.Frequency{},
+		Locations:   []model.Frequency{},
+		IPRanges:    []model.Frequency{},
+	}
+}
+
+// Observe scores a log against the baseline of its actor, then adds it to
+// the baseline. It returns an anomaly if the log scores at least the
+// threshold, and nil otherwise.
+func (d *Detector) Observe(b *model.Baseline, log *model.Log) *model.Anomaly {
+	t := log.Timestamp
+	d.decay(b, t)
+
+	trusted := b.Events >= d.MinEvents
+	var reasons []model.AnomalyReason
+
+	for _, dim := range []struct {
+		kind   string
+		values *[]model.Frequency
+		value  string
+	}{
+		{KindAction, &b.Actions, log.Action},
+		{KindEntityType, &b.EntityTypes, log.Entity.Type},
+		{KindLocation, &b.Locations, log.Context.Location},
+		{KindIPRange, &b.IPRanges, ipRange(log.Context.IPAddr)},
+	} {
+		if dim.value == "" {
+			continue
+		}
+		if trusted {
+			if r, ok := novelty(dim.kind, *dim.values, dim.value); ok {
+				reasons = append(reasons, r)
+			}
+		}
+		*dim.values = add(*dim.values, dim.value)
+	}
+
+	if r, ok := count(&b.Rate, t); ok {
+		reasons = append(reasons, r)
+	}
+
+	b.Events++
+	if b.FirstSeen.IsZero() || t.Before(b.FirstSeen) {
+		b.FirstSeen = t
+	}
+	if t.After(b.LastSeen) {
+		b.LastSeen = t
+	}
+
+	score := combine(reasons)
+	if len(reasons) == 0 || d.Threshold <= 0 || score < d.Threshold {
+		return nil
+	}
+
+	details := make([]string, len(reasons))
+	for i, r := range reasons {
+		details[i] = r.Detail
+	}
+
+	return &model.Anomaly{
+		ID:          primitive.NewObjectID(),
+		ServiceID:   log.ServiceID,
+		ActorType:   log.Actor.Type,
+		ActorID:     log.Actor.ID,
+		LogID:       log.ID,
+		Score:       score,
+		Reasons:     reasons,
+		Explanation: strings.Join(details, "; "),
+		EventTime:   t,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// decay scales the weights of a baseline down by the time passed since
+// its latest log, forgetting the values left too light. Logs older than
+// the latest one leave the weights as they are.
+func (d *Detector) decay(b *model.Baseline, t time.Time) {
+	if b.LastSeen.IsZero() || !t.After(b.LastSeen) || d.HalfLife <= 0 {
+		return
+	}
+
+	f := math.Pow(0.5, float64(t.Sub(b.LastSeen))/float64(d.HalfLife))
+	for _, values := range []*[]model.Frequency{&b.Actions, &b.EntityTypes, &b.Locations, &b.IPRanges} {
+		kept := (*values)[:0]
+		for _, v := range *values {
+			v.Weight *= f
+			if v.Weight >= minWeight {
+				kept = append(kept, v)
+			}
+		}
+		*values = kept
+	}
+}
+
+// novelty scores a value by how rare it is among the values of a
+// dimension, unusual values scoring more the rarer they are.
+func novelty(kind string, values []model.Frequency, value string) (model.AnomalyReason, bool) {
+	var total, weight float64
+	for _, v := range values {
+		total += v.Weight
+		if v.Value == value {
+			weight = v.Weight
+		}
+	}
+	if total == 0 {
+		return model.AnomalyReason{}, false
+	}
+
+	share := weight / total
+	if share >= rareShare {
+		return model.AnomalyReason{}, false
+	}
+
+	detail := fmt.Sprintf("first %s %q seen for this actor", labels[kind], value)
+	if weight > 0 {
+		detail = fmt.Sprintf("%s %q makes up %.1f%% of this actor's recent activity", labels[kind], value, share*100)
+	}
+
+	return model.AnomalyReason{
+		Kind:   kind,
+		Value:  value,
+		Score:  round(weights[kind] * (1 - share/rareShare)),
+		Detail: detail,
+	}, true
+}
+
+// add counts a value in a dimension, keeping the heaviest values only,
+// the value added included.
+func add(values []model.Frequency, value string) []model.Frequency {
+	found := false
+	for i := range values {
+		if values[i].Value == value {
+			values[i].Weight++
+			found = true
+			break
+		}
+	}
+	if !found {
+		values = append(values, model.Frequency{Value: value, Weight: 1})
+	}
+
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Weight > values[j].Weight })
+	if len(values) > maxValues {
+		for i := len(values) - 1; i >= 0; i-- {
+			if values[i].Value != value {
+				values = append(values[:i], values[i+1:]...)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// count adds a log happening at t to the hourly rate of an actor. The
+// first log that takes the count of an hour well above the average of the
+// past active hours is unusual. Logs of hours before the current one are
+// not counted.
+func count(r *model.Rate, t time.Time) (model.AnomalyReason, bool) {
+	hour := t.UTC().Truncate(time.Hour)
+	switch {
+	case r.Hour.IsZero():
+		r.Hour = hour
+	case hour.After(r.Hour):
+		fold(r)
+		r.Hour, r.Count, r.Flagged = hour, 0, false
+	case hour.Before(r.Hour):
+		return model.AnomalyReason{}, false
+	}
+	r.Count++
+
+	if r.Flagged || r.Hours < minHours || r.Count < minRate {
+		return model.AnomalyReason{}, false
+	}
+
+	deviations := (float64(r.Count) - r.Mean) / math.Max(math.Sqrt(r.Variance), 1)
+	if deviations < rateDeviations {
+		return model.AnomalyReason{}, false
+	}
+	r.Flagged = true
+
+	return model.AnomalyReason{
+		Kind:   KindRate,
+		Value:  fmt.Sprint(r.Count),
+		Score:  round(weights[KindRate] * math.Min(deviations/(2*rateDeviations), 1)),
+		Detail: fmt.Sprintf("%d logs this hour against %.1f in an average active hour", r.Count, r.Mean),
+	}, true
+}
+
+// fold adds the count of the current hour to the moving averages.
+func fold(r *model.Rate) {
+	x := float64(r.Count)
+	if r.Hours == 0 {
+		r.Mean, r.Variance = x, 0
+	} else {
+		diff := x - r.Mean
+		incr := rateAlpha * diff
+		r.Mean += incr
+		r.Variance = (1 - rateAlpha) * (r.Variance + diff*incr)
+	}
+	r.Hours++
+}
+
+// combine returns the score of a log deviating in several ways, each
+// making it more likely to be an anomaly.
+func combine(reasons []model.AnomalyReason) float64 {
+	p := 1.0
+	for _, r := range reasons {
+		p *= 1 - r.Score
+	}
+	return round(1 - p)
+}
+
+func round(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// ipRange returns the network an IP address belongs to: its /24 for IPv4
+// and its /48 for IPv6.
+func ipRange(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
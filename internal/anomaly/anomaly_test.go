@@ -0,0 +1,124 @@
+package anomaly
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func activity(action, location, ip string, t time.Time) *model.Log {
+	return &model.Log{
+		ID:        primitive.NewObjectID(),
+		Timestamp: t,
+		Action:    action,
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Entity:    model.Entity{Type: "invoice", ID: "inv_1"},
+		Context:   model.Context{IPAddr: ip, Location: location},
+		ServiceID: "billing",
+	}
+}
+
+func TestObserve(t *testing.T) {
+	detector := NewDetector(0.6)
+	start := time.Date(2023, 7, 1, 9, 0, 0, 0, time.UTC)
+	b := NewBaseline(activity("viewed", "", "", start))
+
+	// Test that logs are not scored until the baseline is trusted
+	for i := 0; i < 60; i++ {
+		action := "viewed"
+		if i%5 == 0 {
+			action = "updated"
+		}
+		log := activity(action, "Lagos", fmt.Sprintf("10.0.0.%d", i%8), start.Add(time.Duration(i)*20*time.Minute))
+		if i == 0 {
+			log.Action = "deleted"
+		}
+		if a := detector.Observe(b, log); a != nil {
+			t.Fatalf("Expected no anomaly while warming up, got %+v", a)
+		}
+	}
+	if b.Events != 60 || len(b.IPRanges) != 1 || b.IPRanges[0].Value != "10.0.0.0/24" {
+		t.Fatalf("Unexpected baseline %+v", b)
+	}
+
+	// Test with usual activity
+	next := start.Add(20 * time.Hour)
+	if a := detector.Observe(b, activity("updated", "Lagos", "10.0.0.200", next)); a != nil {
+		t.Errorf("Expected no anomaly for usual activity, got %+v", a)
+	}
+
+	// Test that a rare action from an unseen place is an anomaly
+	a := detector.Observe(b, activity("deleted", "Minsk", "192.0.2.10", next.Add(time.Minute)))
+	if a == nil {
+		t.Fatal("Expected an anomaly")
+	}
+	kinds := make(map[string]bool)
+	for _, r := range a.Reasons {
+		kinds[r.Kind] = true
+	}
+	if !kinds[KindAction] || !kinds[KindLocation] || !kinds[KindIPRange] || kinds[KindEntityType] {
+		t.Errorf("Unexpected reasons %+v", a.Reasons)
+	}
+	if a.Score < 0.6 || a.Score > 1 || a.ActorID != "12300" || a.ServiceID != "billing" || a.Explanation == "" {
+		t.Errorf("Unexpected anomaly %+v", a)
+	}
+
+	// Test that an unseen IP range alone stays under the threshold
+	if a := detector.Observe(b, activity("viewed", "Lagos", "198.51.100.7", next.Add(2*time.Minute))); a != nil {
+		t.Errorf("Expected no anomaly, got %+v", a)
+	}
+}
+
+func TestObserveRate(t *testing.T) {
+	detector := NewDetector(0.6)
+	start := time.Date(2023, 7, 1, 9, 0, 0, 0, time.UTC)
+	b := NewBaseline(activity("viewed", "", "", start))
+
+	// Test with two logs an hour over a few hours
+	for h := 0; h < 6; h++ {
+		for i := 0; i < 2; i++ {
+			log := activity("viewed", "", "", start.Add(time.Duration(h)*time.Hour+time.Duration(i)*time.Minute))
+			if a := detector.Observe(b, log); a != nil {
+				t.Fatalf("Expected no anomaly, got %+v", a)
+			}
+		}
+	}
+
+	// Test that a burst is flagged once
+	var flagged int
+	burst := start.Add(7 * time.Hour)
+	for i := 0; i < 30; i++ {
+		a := detector.Observe(b, activity("viewed", "", "", burst.Add(time.Duration(i)*time.Second)))
+		if a != nil {
+			flagged++
+			if len(a.Reasons) != 1 || a.Reasons[0].Kind != KindRate {
+				t.Errorf("Unexpected reasons %+v", a.Reasons)
+			}
+		}
+	}
+	if flagged != 1 {
+		t.Errorf("Expected the burst to be flagged once, got %d", flagged)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	detector := NewDetector(0.6)
+	start := time.Date(2023, 7, 1, 9, 0, 0, 0, time.UTC)
+	b := NewBaseline(activity("viewed", "", "", start))
+
+	detector.Observe(b, activity("viewed", "", "", start))
+	detector.Observe(b, activity("exported", "", "", start))
+
+	// Test that weights halve over a half-life, and light ones are dropped
+	detector.Observe(b, activity("viewed", "", "", start.Add(detector.HalfLife)))
+	if len(b.Actions) != 2 || b.Actions[0].Value != "viewed" || b.Actions[0].Weight != 1.5 || b.Actions[1].Weight != 0.5 {
+		t.Errorf("Unexpected actions %+v", b.Actions)
+	}
+	detector.Observe(b, activity("viewed", "", "", start.Add(10*detector.HalfLife)))
+	if len(b.Actions) != 1 || b.Actions[0].Value != "viewed" {
+		t.Errorf("Expected exported to be forgotten, got %+v", b.Actions)
+	}
+}
@@ -0,0 +1,196 @@
+// Package changeset validates, renders and replays the change sets logs
+// carry for the entities they modify.
+package changeset
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxChanges bounds the changes a single log may carry.
+const MaxChanges = 500
+
+// segmentRX matches one segment of a field path.
+var segmentRX = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Validate checks that changes are well formed: every path is a dotted
+// field path given once, no path lies within another, and every change
+// actually changes something.
+func Validate(changes []model.Change) error {
+	if len(changes) > MaxChanges {
+		return fmt.Errorf("must not contain more than %d changes", MaxChanges)
+	}
+
+	paths := make([]string, 0, len(changes))
+	for i, c := range changes {
+		for _, segment := range strings.Split(c.Path, ".") {
+			if !segmentRX.MatchString(segment) {
+				return fmt.Errorf("path of change %d must be a dotted field path", i)
+			}
+		}
+		if c.Before == nil && c.After == nil {
+			return fmt.Errorf("change %d must have a before or an after value", i)
+		}
+		if reflect.DeepEqual(Normalize(c.Before), Normalize(c.After)) {
+			return fmt.Errorf("change %d must have different before and after values", i)
+		}
+		paths = append(paths, c.Path)
+	}
+
+	// Sorted, a path lying within another directly follows it.
+	sort.Strings(paths)
+	for i := 1; i < len(paths); i++ {
+		if paths[i] == paths[i-1] {
+			return fmt.Errorf("path %s must not be given more than once", paths[i])
+		}
+		if strings.HasPrefix(paths[i], paths[i-1]+".") {
+			return fmt.Errorf("path %s must not lie within path %s", paths[i], paths[i-1])
+		}
+	}
+
+	return nil
+}
+
+// Normalize returns a copy of a value in which documents and arrays, as
+// decoded from either JSON or BSON, are plain maps and slices. Fields of
+// documents set to null are set to model.Null, telling them from missing
+// ones.
+func Normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[k] = normalizeField(v)
+		}
+		return m
+	case primitive.M:
+		return Normalize(map[string]interface{}(value))
+	case primitive.D:
+		m := make(map[string]interface{}, len(value))
+		for _, e := range value {
+			m[e.Key] = normalizeField(e.Value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(value))
+		for i, v := range value {
+			s[i] = Normalize(v)
+		}
+		return s
+	case primitive.A:
+		return Normalize([]interface{}(value))
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case int:
+		return float64(value)
+	default:
+		return value
+	}
+}
+
+func normalizeField(value interface{}) interface{} {
+	if value == nil {
+		return model.Null
+	}
+	return Normalize(value)
+}
+
+// A Diff sorts the fields touched by a change set into added, removed and
+// modified ones.
+type Diff struct {
+	Added    []Value        `json:"added"`
+	Removed  []Value        `json:"removed"`
+	Modified []Modification `json:"modified"`
+}
+
+// A Value is the value of a field.
+type Value struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// A Modification is the value of a field before and after a change.
+type Modification struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Compare renders changes as a diff. Changes between two documents are
+// broken down into the changes of their fields, so only the parts that
+// differ are reported.
+func Compare(changes []model.Change) Diff {
+	d := Diff{Added: []Value{}, Removed: []Value{}, Modified: []Modification{}}
+	for _, c := range changes {
+		d.compare(c.Path, Normalize(c.Before), Normalize(c.After))
+	}
+
+	sort.SliceStable(d.Added, func(i, j int) bool { return d.Added[i].Path < d.Added[j].Path })
+	sort.SliceStable(d.Removed, func(i, j int) bool { return d.Removed[i].Path < d.Removed[j].Path })
+	sort.SliceStable(d.Modified, func(i, j int) bool { return d.Modified[i].Path < d.Modified[j].Path })
+	return d
+}
+
+func (d *Diff) compare(path string, before, after interface{}) {
+	beforeDoc, beforeIsDoc := before.(map[string]interface{})
+	afterDoc, afterIsDoc := after.(map[string]interface{})
+	if beforeIsDoc && afterIsDoc {
+		for key := range beforeDoc {
+			d.compare(path+"."+key, beforeDoc[key], afterDoc[key])
+		}
+		for key := range afterDoc {
+			if _, ok := beforeDoc[key]; !ok {
+				d.compare(path+"."+key, nil, afterDoc[key])
+			}
+		}
+		return
+	}
+
+	switch {
+	case reflect.DeepEqual(before, after):
+	case before == nil:
+		d.Added = append(d.Added, Value{Path: path, Value: after})
+	case after == nil:
+		d.Removed = append(d.Removed, Value{Path: path, Value: before})
+	default:
+		d.Modified = append(d.Modified, Modification{Path: path, Before: before, After: after})
+	}
+}
+
+// Apply replays changes onto the state of an entity: fields are set to
+// their after value, null included, or removed when it is missing.
+// Documents along a path are created as needed.
+func Apply(state map[string]interface{}, changes []model.Change) {
+	for _, c := range changes {
+		segments := strings.Split(c.Path, ".")
+		last := len(segments) - 1
+
+		doc := state
+		for _, segment := range segments[:last] {
+			next, ok := doc[segment].(map[string]interface{})
+			if !ok {
+				// Removing a field from a missing document leaves the
+				// state untouched.
+				next = make(map[string]interface{})
+				if c.After != nil {
+					doc[segment] = next
+				}
+			}
+			doc = next
+		}
+
+		if c.After == nil {
+			delete(doc, segments[last])
+			continue
+		}
+		doc[segments[last]] = Normalize(c.After)
+	}
+}
@@ -0,0 +1,111 @@
+package changeset
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidate(t *testing.T) {
+	// Test with valid changes
+	changes := []model.Change{
+		{Path: "status", Before: "draft", After: "sent"},
+		{Path: "address.city", After: "Lagos"},
+		{Path: "address_line", Before: "1 Main St"},
+	}
+	if err := Validate(changes); err != nil {
+		t.Errorf("Expected valid changes, got %v", err)
+	}
+
+	// Test with invalid changes
+	tests := map[string][]model.Change{
+		"empty path":        {{Path: "", After: 1.0}},
+		"invalid path":      {{Path: "address..city", After: "Lagos"}},
+		"no values":         {{Path: "status"}},
+		"unchanged value":   {{Path: "total", Before: 10.0, After: 10.0}},
+		"duplicate path":    {{Path: "status", After: "sent"}, {Path: "status", Before: "draft"}},
+		"overlapping paths": {{Path: "address", After: "x"}, {Path: "address.city", After: "Lagos"}},
+	}
+	for name, changes := range tests {
+		if err := Validate(changes); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	changes := []model.Change{
+		{Path: "status", Before: "draft", After: "sent"},
+		{Path: "notes", After: "rush order"},
+		{Path: "discount", Before: 5.0},
+		{
+			Path:   "address",
+			Before: primitive.D{{Key: "city", Value: "Abuja"}, {Key: "zip", Value: "900001"}},
+			After:  map[string]interface{}{"city": "Lagos", "zip": "900001", "street": "1 Marina"},
+		},
+	}
+
+	expected := Diff{
+		Added:    []Value{{Path: "address.street", Value: "1 Marina"}, {Path: "notes", Value: "rush order"}},
+		Removed:  []Value{{Path: "discount", Value: 5.0}},
+		Modified: []Modification{{Path: "address.city", Before: "Abuja", After: "Lagos"}, {Path: "status", Before: "draft", After: "sent"}},
+	}
+	if d := Compare(changes); !reflect.DeepEqual(d, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, d)
+	}
+}
+
+func TestApply(t *testing.T) {
+	state := map[string]interface{}{}
+
+	// Test replaying change sets in order
+	Apply(state, []model.Change{
+		{Path: "status", After: "draft"},
+		{Path: "address.city", After: "Abuja"},
+		{Path: "discount", After: 5.0},
+	})
+	Apply(state, []model.Change{
+		{Path: "status", Before: "draft", After: "sent"},
+		{Path: "address.city", Before: "Abuja", After: "Lagos"},
+		{Path: "discount", Before: 5.0},
+		{Path: "missing.field", Before: "x"},
+	})
+
+	expected := map[string]interface{}{
+		"status":  "sent",
+		"address": map[string]interface{}{"city": "Lagos"},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("Expected %v, got %v", expected, state)
+	}
+}
+
+// Test that fields set to null are told from removed ones
+func TestNull(t *testing.T) {
+	changes := []model.Change{
+		{Path: "status", Before: "draft", After: model.Null},
+		{Path: "notes", Before: model.Null},
+		{Path: "address", Before: map[string]interface{}{"zip": "900001"}, After: map[string]interface{}{"zip": nil}},
+	}
+	if err := Validate(changes); err != nil {
+		t.Errorf("Expected valid changes, got %v", err)
+	}
+
+	expected := Diff{
+		Added:    []Value{},
+		Removed:  []Value{{Path: "notes", Value: model.Null}},
+		Modified: []Modification{{Path: "address.zip", Before: "900001", After: model.Null}, {Path: "status", Before: "draft", After: model.Null}},
+	}
+	if d := Compare(changes); !reflect.DeepEqual(d, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, d)
+	}
+
+	state := map[string]interface{}{"status": "draft", "notes": nil}
+	Apply(state, changes)
+	expectedState := map[string]interface{}{"status": model.Null, "address": map[string]interface{}{"zip": model.Null}}
+	if !reflect.DeepEqual(state, expectedState) {
+		t.Errorf("Expected %v, got %v", expectedState, state)
+	}
+}
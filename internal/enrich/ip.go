@@ -0,0 +1,145 @@
+// Package enrich adds to logs, as they are stored, what can be derived from
+// the values their producers sent, which are left as they were.
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/geo"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// A Tagger tags IP addresses with the names of the operator-defined
+// networks they are in, e.g. "corporate-vpn" or "tor-exit".
+type Tagger struct {
+	nets []taggedNet
+}
+
+type taggedNet struct {
+	tag string
+	net *net.IPNet
+}
+
+// NewTagger returns a tagger without networks.
+func NewTagger() *Tagger {
+	return &Tagger{}
+}
+
+// LoadTagger returns a tagger with the networks listed in the files mapped
+// to by tag. See Load for the format of the files.
+func LoadTagger(files map[string]string) (*Tagger, error) {
+	t := NewTagger()
+	for tag, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = t.Load(tag, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return t, nil
+}
+
+// Add tags the addresses of a network, given in CIDR notation or as a
+// single address.
+func (t *Tagger) Add(tag, cidr string) error {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return fmt.Errorf("invalid address %q", cidr)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		t.nets = append(t.nets, taggedNet{tag, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+		return nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid network %q", cidr)
+	}
+	t.nets = append(t.nets, taggedNet{tag, network})
+	return nil
+}
+
+// Load tags the networks listed in r, one per line. Blank lines and text
+// after a '#' are ignored.
+func (t *Tagger) Load(tag string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if err := t.Add(tag, text); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Tags returns the sorted tags of the networks an IP address is in.
+func (t *Tagger) Tags(ip net.IP) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, n := range t.nets {
+		if !seen[n.tag] && n.net.Contains(ip) {
+			seen[n.tag] = true
+			tags = append(tags, n.tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// An IP describes the IP address of logs: where it is and the autonomous
+// system it belongs to, looked up in a geo database, and the tags of the
+// networks it is in.
+type IP struct {
+	db     *geo.DB
+	tagger *Tagger
+}
+
+// NewIP returns an enricher looking IP addresses up in db and tagging them
+// with tagger. Either may be nil.
+func NewIP(db *geo.DB, tagger *Tagger) *IP {
+	return &IP{db: db, tagger: tagger}
+}
+
+// Enrich sets the description of the IP address of a log, if anything is
+// known about it, replacing any the producer sent. Context.IPAddr and
+// Context.Location are left as the producer sent them.
+func (e *IP) Enrich(log *model.Log) {
+	log.IP = nil
+
+	addr := strings.TrimSpace(log.Context.IPAddr)
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return
+	}
+
+	var info model.IPInfo
+	found := false
+	if e.db != nil {
+		info, found = e.db.Lookup(addr)
+	}
+	if e.tagger != nil {
+		info.Tags = e.tagger.Tags(ip)
+	}
+
+	if found || len(info.Tags) > 0 {
+		log.IP = &info
+	}
+}
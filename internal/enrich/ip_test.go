@@ -0,0 +1,69 @@
+package enrich
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestTagger(t *testing.T) {
+	tagger := NewTagger()
+	err := tagger.Load("corporate-vpn", strings.NewReader(`
+# VPN concentrators
+10.8.0.0/16
+2001:db8:100::/48 # v6 pool
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.Load("tor-exit", strings.NewReader("185.220.101.4\n10.8.3.0/24\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"10.8.3.4":          {"corporate-vpn", "tor-exit"},
+		"10.8.200.1":        {"corporate-vpn"},
+		"2001:db8:100:1::5": {"corporate-vpn"},
+		"185.220.101.4":     {"tor-exit"},
+		"185.220.101.5":     nil,
+	}
+	for ip, expected := range tests {
+		if tags := tagger.Tags(net.ParseIP(ip)); !reflect.DeepEqual(tags, expected) {
+			t.Errorf("Expected tags %v for %s, got %v", expected, ip, tags)
+		}
+	}
+
+	// Test with an invalid network
+	if err := tagger.Load("office", strings.NewReader("10.0.0.0/8\n10.0.0/33\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected error on line 2, got %v", err)
+	}
+}
+
+func TestEnrichIP(t *testing.T) {
+	tagger := NewTagger()
+	if err := tagger.Add("corporate-vpn", "10.8.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	enricher := NewIP(nil, tagger)
+
+	// Test that tags are added, and the producer's values kept
+	log := &model.Log{Context: model.Context{IPAddr: " 10.8.1.1", Location: "NYC"}}
+	enricher.Enrich(log)
+	if log.IP == nil || !reflect.DeepEqual(log.IP.Tags, []string{"corporate-vpn"}) {
+		t.Errorf("Unexpected IP description %+v", log.IP)
+	}
+	if log.Context.IPAddr != " 10.8.1.1" || log.Context.Location != "NYC" {
+		t.Errorf("Expected the context to be kept, got %+v", log.Context)
+	}
+
+	// Test with addresses nothing is known about
+	for _, addr := range []string{"192.0.2.1", "unknown", ""} {
+		log := &model.Log{Context: model.Context{IPAddr: addr}, IP: &model.IPInfo{Country: "US"}}
+		if enricher.Enrich(log); log.IP != nil {
+			t.Errorf("Expected no IP description for %q, got %+v", addr, log.IP)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package enrich
+
+import (
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// bots lists the tokens of well-known crawlers and HTTP clients, checked
+// in order. Bots with none of them are recognized by botMarkers.
+var bots = []struct {
+	token string
+	name  string
+}{
+	{"Googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"DuckDuckBot", "DuckDuckBot"},
+	{"YandexBot", "YandexBot"},
+	{"Baiduspider", "Baiduspider"},
+	{"facebookexternalhit", "Facebook"},
+	{"Twitterbot", "Twitterbot"},
+	{"Slackbot", "Slackbot"},
+	{"HeadlessChrome", "Headless Chrome"},
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+	{"python-requests/", "Python Requests"},
+	{"Go-http-client/", "Go HTTP client"},
+	{"okhttp/", "OkHttp"},
+	{"PostmanRuntime/", "Postman"},
+}
+
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "scraper"}
+
+// browsers lists the tokens of browsers, checked in order as most browsers
+// also claim to be the ones they derive from.
+var browsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "Internet Explorer"},
+}
+
+// windowsVersions maps the Windows NT versions to the releases they are
+// known as.
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+// ParseUserAgent describes the software a User-Agent header value was
+// sent by. Unrecognized user agents are of the "other" device class.
+func ParseUserAgent(s string) model.UserAgent {
+	var ua model.UserAgent
+
+	ua.Browser, ua.BrowserVersion = parseBrowser(s)
+	ua.OS, ua.OSVersion = parseOS(s)
+
+	if name, ok := parseBot(s); ok {
+		ua.Bot = true
+		ua.Device = model.DeviceBot
+		if name != "" {
+			ua.Browser, ua.BrowserVersion = name, ""
+		}
+		return ua
+	}
+
+	switch {
+	case strings.Contains(s, "iPad") || strings.Contains(s, "Tablet") ||
+		(ua.OS == "Android" && !strings.Contains(s, "Mobile")):
+		ua.Device = model.DeviceTablet
+	case strings.Contains(s, "iPhone") || strings.Contains(s, "iPod") || strings.Contains(s, "Mobile"):
+		ua.Device = model.DeviceMobile
+	case ua.OS == "Windows" || ua.OS == "macOS" || ua.OS == "Linux" || ua.OS == "ChromeOS":
+		ua.Device = model.DeviceDesktop
+	default:
+		ua.Device = model.DeviceOther
+	}
+	return ua
+}
+
+// parseBot reports whether a user agent is a bot, and its name if it is a
+// well-known one.
+func parseBot(s string) (string, bool) {
+	for _, b := range bots {
+		if strings.Contains(s, b.token) {
+			return b.name, true
+		}
+	}
+	lower := strings.ToLower(s)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+func parseBrowser(s string) (string, string) {
+	for _, b := range browsers {
+		if v, ok := version(s, b.token); ok {
+			return b.name, v
+		}
+	}
+	if strings.Contains(s, "Trident/") {
+		v, _ := version(s, "rv:")
+		return "Internet Explorer", v
+	}
+	if strings.Contains(s, "Safari/") {
+		v, _ := version(s, "Version/")
+		return "Safari", v
+	}
+	return "", ""
+}
+
+func parseOS(s string) (string, string) {
+	switch {
+	case strings.Contains(s, "Windows"):
+		v, _ := version(s, "Windows NT ")
+		if release, ok := windowsVersions[v]; ok {
+			v = release
+		}
+		return "Windows", v
+	case strings.Contains(s, "iPhone") || strings.Contains(s, "iPad") || strings.Contains(s, "iPod"):
+		v, ok := version(s, "iPhone OS ")
+		if !ok {
+			v, _ = version(s, "CPU OS ")
+		}
+		return "iOS", v
+	case strings.Contains(s, "Android"):
+		v, _ := version(s, "Android ")
+		return "Android", v
+	case strings.Contains(s, "CrOS"):
+		return "ChromeOS", ""
+	case strings.Contains(s, "Mac OS X"):
+		v, _ := version(s, "Mac OS X ")
+		return "macOS", v
+	case strings.Contains(s, "Linux"):
+		return "Linux", ""
+	}
+	return "", ""
+}
+
+// version returns the version following a token in a user agent, with
+// underscores, as some platforms write them, read as dots.
+func version(s, token string) (string, bool) {
+	i := strings.Index(s, token)
+	if i < 0 {
+		return "", false
+	}
+	s = s[i+len(token):]
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || s[end] == '_') {
+		end++
+	}
+	return strings.Trim(strings.ReplaceAll(s[:end], "_", "."), "."), true
+}
+
+// A UserAgent describes the user agents logs were recorded from, read
+// from a field of the logs.
+type UserAgent struct {
+	field query.Field
+}
+
+// NewUserAgent returns an enricher parsing the user agents held by field.
+func NewUserAgent(field query.Field) *UserAgent {
+	return &UserAgent{field: field}
+}
+
+// Enrich sets the description of the user agent of a log, replacing any
+// the producer sent. Logs without a user agent are left without one.
+func (e *UserAgent) Enrich(log *model.Log) {
+	log.UserAgent = nil
+
+	value, ok := e.field.Value(log)
+	if !ok {
+		return
+	}
+	s, ok := value.(string)
+	if s = strings.TrimSpace(s); !ok || s == "" {
+		return
+	}
+
+	ua := ParseUserAgent(s)
+	log.UserAgent = &ua
+}
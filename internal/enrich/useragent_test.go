@@ -0,0 +1,96 @@
+package enrich
+
+import (
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected model.UserAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.116 Safari/537.36",
+			model.UserAgent{Browser: "Chrome", BrowserVersion: "83.0.4103.116", OS: "Windows", OSVersion: "10", Device: model.DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 Edg/114.0.1823.51",
+			model.UserAgent{Browser: "Edge", BrowserVersion: "114.0.1823.51", OS: "Windows", OSVersion: "10", Device: model.DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.5 Safari/605.1.15",
+			model.UserAgent{Browser: "Safari", BrowserVersion: "16.5", OS: "macOS", OSVersion: "10.15.7", Device: model.DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0",
+			model.UserAgent{Browser: "Firefox", BrowserVersion: "115.0", OS: "Linux", Device: model.DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/114.0.5735.124 Mobile/15E148 Safari/604.1",
+			model.UserAgent{Browser: "Chrome", BrowserVersion: "114.0.5735.124", OS: "iOS", OSVersion: "16.5", Device: model.DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 15_7 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.6 Mobile/15E148 Safari/604.1",
+			model.UserAgent{Browser: "Safari", BrowserVersion: "15.6", OS: "iOS", OSVersion: "15.7", Device: model.DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/21.0 Chrome/110.0.5481.154 Mobile Safari/537.36",
+			model.UserAgent{Browser: "Samsung Internet", BrowserVersion: "21.0", OS: "Android", OSVersion: "13", Device: model.DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 12; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.5735.130 Safari/537.36",
+			model.UserAgent{Browser: "Chrome", BrowserVersion: "114.0.5735.130", OS: "Android", OSVersion: "12", Device: model.DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			model.UserAgent{Browser: "Googlebot", Device: model.DeviceBot, Bot: true},
+		},
+		{
+			"curl/8.1.2",
+			model.UserAgent{Browser: "curl", Device: model.DeviceBot, Bot: true},
+		},
+		{
+			"Mozilla/5.0 (compatible; SomeCrawler/1.0)",
+			model.UserAgent{Device: model.DeviceBot, Bot: true},
+		},
+		{
+			"acme-sync/3.2",
+			model.UserAgent{Device: model.DeviceOther},
+		},
+	}
+
+	for _, tt := range tests {
+		if ua := ParseUserAgent(tt.input); ua != tt.expected {
+			t.Errorf("Expected %+v for %q, got %+v", tt.expected, tt.input, ua)
+		}
+	}
+}
+
+func TestEnrichUserAgent(t *testing.T) {
+	field, ok := query.LookupField("actor.extension.userAgent")
+	if !ok {
+		t.Fatal("Expected an extension field")
+	}
+	enricher := NewUserAgent(field)
+
+	// Test that the user agent held by the field is parsed
+	log := &model.Log{Actor: model.Actor{Extension: map[string]interface{}{"userAgent": "curl/8.1.2"}}}
+	enricher.Enrich(log)
+	if log.UserAgent == nil || !log.UserAgent.Bot || log.UserAgent.Browser != "curl" {
+		t.Errorf("Unexpected user agent %+v", log.UserAgent)
+	}
+
+	// Test with missing, empty and non-string values
+	for _, value := range []interface{}{nil, " ", float64(5)} {
+		log := &model.Log{UserAgent: &model.UserAgent{Device: model.DeviceBot}}
+		if value != nil {
+			log.Actor.Extension = map[string]interface{}{"userAgent": value}
+		}
+		if enricher.Enrich(log); log.UserAgent != nil {
+			t.Errorf("Expected no user agent for %v, got %+v", value, log.UserAgent)
+		}
+	}
+}
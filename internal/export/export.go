@@ -0,0 +1,332 @@
+// Package export writes logs out as NDJSON, CSV or Parquet, one record at
+// a time, so that exports of any size run in constant memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// A Format is an export file format.
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	Compress    bool // whether gzip is worth applying on top of the format
+}
+
+var (
+	NDJSON  = Format{"ndjson", "application/x-ndjson", "ndjson", true}
+	CSV     = Format{"csv", "text/csv; charset=utf-8", "csv", true}
+	Parquet = Format{"parquet", "application/vnd.apache.parquet", "parquet", false}
+)
+
+// mediaTypes maps the media types accepted in the Accept header to the
+// format they select.
+var mediaTypes = map[string]Format{
+	"application/x-ndjson":           NDJSON,
+	"application/ndjson":             NDJSON,
+	"application/jsonl":              NDJSON,
+	"text/csv":                       CSV,
+	"application/vnd.apache.parquet": Parquet,
+	"application/x-parquet":          Parquet,
+}
+
+// LookupFormat returns the format known by name.
+func LookupFormat(name string) (Format, bool) {
+	for _, f := range []Format{NDJSON, CSV, Parquet} {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// FormatForMediaType returns the format selected by a media type, ignoring
+// any parameters.
+func FormatForMediaType(mediaType string) (Format, bool) {
+	f, ok := mediaTypes[mediaType]
+	return f, ok
+}
+
+// A Column is one column of a flattened export.
+type Column struct {
+	Name  string
+	value func(*model.Log) (interface{}, bool)
+}
+
+// DefaultColumns lists the fixed columns of flattened exports.
+var DefaultColumns = []string{
+	"id", "timestamp", "action", "actor.type", "actor.id", "entity.type",
+	"entity.id", "context.ip_address", "context.location",
+}
+
+// Columns resolves column names into columns. Besides "id", every name
+// must be a queryable field, extension paths included.
+func Columns(names []string) ([]Column, error) {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		if name == "id" {
+			columns[i] = Column{Name: name, value: func(l *model.Log) (interface{}, bool) {
+				return l.ID.Hex(), true
+			}}
+			continue
+		}
+
+		field, ok := query.LookupField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		columns[i] = Column{Name: name, value: field.Value}
+	}
+	return columns, nil
+}
+
+// ExtensionColumns returns the paths of the extension keys used by logs,
+// e.g. "entity.extension.item_id", sorted. At most limit paths are
+// returned.
+func ExtensionColumns(logs []*model.Log, limit int) []string {
+	seen := make(map[string]bool)
+	for _, log := range logs {
+		for prefix, ext := range map[string]map[string]interface{}{
+			"extension.":         log.Extension,
+			"actor.extension.":   log.Actor.Extension,
+			"entity.extension.":  log.Entity.Extension,
+			"context.extension.": log.Context.Extension,
+		} {
+			for key := range ext {
+				if _, ok := query.LookupField(prefix + key); ok {
+					seen[prefix+key] = true
+				}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
+
+// A Writer writes logs in an export format.
+type Writer interface {
+	Write(log *model.Log) error
+	// Close flushes buffered records and writes any trailing data. It
+	// does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter returns a writer for the given format. The columns are only
+// used by the flattened formats, CSV and Parquet.
+func NewWriter(f Format, w io.Writer, columns []Column) (Writer, error) {
+	switch f {
+	case NDJSON:
+		buf := bufio.NewWriter(w)
+		return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}, nil
+	case CSV:
+		return newCSVWriter(w, columns)
+	case Parquet:
+		return newParquetWriter(w, columns)
+	default:
+		return nil, errors.New("unsupported export format")
+	}
+}
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(log *model.Log) error {
+	return w.enc.Encode(log)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.buf.Flush()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+
+	for i, c := range columns {
+		cw.record[i] = c.Name
+	}
+	return cw, cw.w.Write(cw.record)
+}
+
+func (w *csvWriter) Write(log *model.Log) error {
+	for i, c := range w.columns {
+		w.record[i] = ""
+		if value, ok := c.value(log); ok {
+			w.record[i] = formatValue(value)
+		}
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// parquetRowGroupSize bounds the memory buffered before a row group is
+// written out.
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []Column
+	record  []*string
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	schema := make([]string, len(columns))
+	for i, c := range columns {
+		if c.Name == "timestamp" {
+			schema[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL", c.Name)
+			continue
+		}
+		schema[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", c.Name)
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+
+	return &parquetWriter{w: pw, columns: columns, record: make([]*string, len(columns))}, nil
+}
+
+func (w *parquetWriter) Write(log *model.Log) error {
+	for i, c := range w.columns {
+		w.record[i] = nil
+
+		value, ok := c.value(log)
+		if !ok {
+			continue
+		}
+
+		var s string
+		if t, isTime := value.(time.Time); isTime && c.Name == "timestamp" {
+			s = strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+		} else {
+			s = formatValue(value)
+		}
+		w.record[i] = &s
+	}
+	return w.w.WriteString(w.record)
+}
+
+func (w *parquetWriter) Close() error {
+	return w.w.WriteStop()
+}
+
+// formatValue renders a value as a cell. Documents and arrays, which only
+// occur in extension maps, are rendered as JSON.
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(data)
+	}
+}
+
+// Negotiate selects the format from the value of an Accept header,
+// honouring quality values. An empty header or a wildcard selects NDJSON.
+// It reports false when none of the acceptable media types is supported.
+func Negotiate(accept string) (Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return NDJSON, true
+	}
+
+	var (
+		best    Format
+		bestQ   float64
+		matched bool
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 || (matched && q <= bestQ) {
+			continue
+		}
+
+		f, ok := mediaTypes[mediaType]
+		if !ok && (mediaType == "*/*" || mediaType == "application/*") {
+			f, ok = NDJSON, true
+		}
+		if ok {
+			best, bestQ, matched = f, q, true
+		}
+	}
+
+	return best, matched
+}
+
+// AcceptsGzip reports whether the value of an Accept-Encoding header
+// allows a gzip-compressed response.
+func AcceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		params = strings.ReplaceAll(params, " ", "")
+		if strings.HasPrefix(params, "q=") {
+			q, err := strconv.ParseFloat(params[2:], 64)
+			if err != nil || q <= 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testLogs() []*model.Log {
+	id, _ := primitive.ObjectIDFromHex("63ec8a3c5e4a1f0d2c9b7a10")
+	return []*model.Log{
+		{
+			ID:        id,
+			Timestamp: time.Date(2023, 7, 14, 9, 30, 0, 0, time.UTC),
+			Action:    "billed",
+			Actor:     model.Actor{Type: "customer", ID: "c-1"},
+			Entity:    model.Entity{Type: "invoice", Extension: map[string]interface{}{"item_id": "f66020564728"}},
+			Extension: map[string]interface{}{"amount": 12.5, "lines": []interface{}{"a", "b"}},
+		},
+		{
+			ID:        id,
+			Timestamp: time.Date(2023, 7, 15, 9, 30, 0, 0, time.UTC),
+			Action:    "refunded, partially",
+			Actor:     model.Actor{Type: "customer", ID: "c-2"},
+		},
+	}
+}
+
+func TestColumns(t *testing.T) {
+	// Test with known columns
+	columns, err := Columns(append(DefaultColumns, "extension.amount"))
+	if err != nil {
+		t.Fatalf("Expected known columns, got %v", err)
+	}
+	if len(columns) != len(DefaultColumns)+1 {
+		t.Errorf("Expected %d columns, got %d", len(DefaultColumns)+1, len(columns))
+	}
+
+	// Test with an unknown column
+	_, err = Columns([]string{"id", "actor.name"})
+	if err == nil {
+		t.Error("Expected error for unknown column")
+	}
+}
+
+func TestExtensionColumns(t *testing.T) {
+	logs := testLogs()
+	logs[1].Context.Extension = map[string]interface{}{"region": "eu", "bad key": 1}
+
+	// Test that the keys of every log are listed once, sorted, and only
+	// if they can be queried
+	expected := []string{"context.extension.region", "entity.extension.item_id", "extension.amount", "extension.lines"}
+	paths := ExtensionColumns(logs, 10)
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+
+	// Test with a limit
+	if paths := ExtensionColumns(logs, 2); len(paths) != 2 {
+		t.Errorf("Expected 2 paths, got %v", paths)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(NDJSON, &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range testLogs() {
+		if err := w.Write(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	var log model.Log
+	if err := json.Unmarshal([]byte(lines[1]), &log); err != nil || log.Action != "refunded, partially" {
+		t.Errorf("Unexpected record %q: %v", lines[1], err)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	columns, _ := Columns([]string{"id", "timestamp", "action", "extension.amount", "extension.lines", "entity.extension.item_id"})
+
+	var buf bytes.Buffer
+	w, err := NewWriter(CSV, &buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range testLogs() {
+		if err := w.Write(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id,timestamp,action,extension.amount,extension.lines,entity.extension.item_id\n" +
+		`63ec8a3c5e4a1f0d2c9b7a10,2023-07-14T09:30:00Z,billed,12.5,"[""a"",""b""]",f66020564728` + "\n" +
+		`63ec8a3c5e4a1f0d2c9b7a10,2023-07-15T09:30:00Z,"refunded, partially",,,` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns, _ := Columns([]string{"id", "timestamp", "action", "extension.amount"})
+
+	var buf bytes.Buffer
+	w, err := NewWriter(Parquet, &buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range testLogs() {
+		if err := w.Write(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	if n := pr.GetNumRows(); n != 2 {
+		t.Fatalf("Expected 2 rows, got %d", n)
+	}
+
+	values, _, _, err := pr.ReadColumnByIndex(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != int64(1689327000000) {
+		t.Errorf("Expected timestamp in milliseconds, got %v", values[0])
+	}
+
+	values, _, _, err = pr.ReadColumnByIndex(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "12.5" || values[1] != nil {
+		t.Errorf("Unexpected extension.amount values %v", values)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		format Format
+		ok     bool
+	}{
+		{"", NDJSON, true},
+		{"*/*", NDJSON, true},
+		{"text/csv", CSV, true},
+		{"text/csv;q=0.5, application/vnd.apache.parquet", Parquet, true},
+		{"application/x-ndjson;q=0.1, text/csv;q=0.9", CSV, true},
+		{"text/html", Format{}, false},
+		{"text/csv;q=0", Format{}, false},
+	}
+
+	for _, tt := range tests {
+		format, ok := Negotiate(tt.accept)
+		if format != tt.format || ok != tt.ok {
+			t.Errorf("Negotiate(%q) = %v, %v; expected %v, %v", tt.accept, format.Name, ok, tt.format.Name, tt.ok)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	// Test with gzip accepted
+	for _, header := range []string{"gzip", "deflate, gzip;q=0.8", "*"} {
+		if !AcceptsGzip(header) {
+			t.Errorf("Expected gzip to be accepted for %q", header)
+		}
+	}
+
+	// Test with gzip not accepted
+	for _, header := range []string{"", "deflate", "gzip;q=0"} {
+		if AcceptsGzip(header) {
+			t.Errorf("Expected gzip not to be accepted for %q", header)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package geo looks up where IP addresses are and which networks they
+// belong to, from local databases in the MaxMind DB format, such as
+// GeoLite2-City and GeoLite2-ASN.
+package geo
+
+import (
+	"net"
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Name returns the city, region and country of an IP address, as far as
+// they are known, e.g. "New York, New York, US".
+func Name(info model.IPInfo) string {
+	var parts []string
+	for _, part := range []string{info.City, info.Region, info.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// A DB looks up IP addresses in a city and an ASN database, either of
+// which may be missing.
+type DB struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens the databases at the given paths. An empty path leaves the
+// corresponding fields of lookups empty.
+func Open(cityPath, asnPath string) (*DB, error) {
+	db := &DB{}
+
+	var err error
+	if cityPath != "" {
+		db.city, err = maxminddb.Open(cityPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		db.asn, err = maxminddb.Open(asnPath)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// Close closes the databases.
+func (db *DB) Close() error {
+	var err error
+	for _, r := range []*maxminddb.Reader{db.city, db.asn} {
+		if r == nil {
+			continue
+		}
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Lookup returns where an IP address is and the autonomous system it
+// belongs to. It reports false when the address is invalid or in neither
+// database.
+func (db *DB) Lookup(addr string) (model.IPInfo, bool) {
+	var p model.IPInfo
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return p, false
+	}
+
+	found := false
+	if db.city != nil {
+		var rec cityRecord
+		if _, ok, err := db.city.LookupNetwork(ip, &rec); err == nil && ok {
+			found = true
+			p.Country = rec.Country.ISOCode
+			if len(rec.Subdivisions) > 0 {
+				p.Region = rec.Subdivisions[0].Names["en"]
+			}
+			p.City = rec.City.Names["en"]
+			if rec.Location.Latitude != nil && rec.Location.Longitude != nil {
+				p.Latitude, p.Longitude = rec.Location.Latitude, rec.Location.Longitude
+			}
+		}
+	}
+	if db.asn != nil {
+		var rec asnRecord
+		if _, ok, err := db.asn.LookupNetwork(ip, &rec); err == nil && ok {
+			found = true
+			p.ASN, p.ASOrg = rec.Number, rec.Organization
+		}
+	}
+
+	return p, found
+}
@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeDB writes a database of the given type holding records by network.
+func writeDB(t *testing.T, dbType string, records map[string]mmdbtype.Map) string {
+	t.Helper()
+
+	w, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, RecordSize: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Insert(network, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	city := writeDB(t, "GeoLite2-City", map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"country":      mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("England")}}},
+			"city":         mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("London")}},
+			"location":     mmdbtype.Map{"latitude": mmdbtype.Float64(51.5142), "longitude": mmdbtype.Float64(-0.0931)},
+		},
+		"2.125.160.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+		},
+	})
+	asn := writeDB(t, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"autonomous_system_number":       mmdbtype.Uint32(20712),
+			"autonomous_system_organization": mmdbtype.String("Andrews & Arnold Ltd"),
+		},
+	})
+
+	db, err := Open(city, asn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Test with an address in both databases
+	p, ok := db.Lookup("81.2.69.160")
+	if !ok || Name(p) != "London, England, GB" || p.Latitude == nil || *p.Latitude != 51.5142 || p.ASN != 20712 || p.ASOrg != "Andrews & Arnold Ltd" {
+		t.Errorf("Unexpected place %+v", p)
+	}
+
+	// Test with an address without coordinates
+	p, ok = db.Lookup("2.125.160.216")
+	if !ok || Name(p) != "GB" || p.Latitude != nil || p.ASN != 0 {
+		t.Errorf("Unexpected place %+v", p)
+	}
+
+	// Test with unknown and invalid addresses
+	if p, ok := db.Lookup("8.8.8.8"); ok {
+		t.Errorf("Expected no place, got %+v", p)
+	}
+	if p, ok := db.Lookup("not-an-ip"); ok {
+		t.Errorf("Expected no place, got %+v", p)
+	}
+
+	// Test with a missing database file
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"), ""); err == nil {
+		t.Errorf("Expected error for a missing database")
+	}
+}
@@ -0,0 +1,175 @@
+// Package pipeline runs the logs the service ingests through an ordered
+// chain of processors before they are stored.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// ErrDropped is returned by processors discarding a log. Dropped logs are
+// not stored, nor reported to their producer.
+var ErrDropped = errors.New("log dropped")
+
+// A Rejection refuses a log, for a reason its producer is told about.
+type Rejection struct {
+	Processor string // the processor that rejected the log, if any
+	Reason    string
+}
+
+func (r *Rejection) Error() string {
+	if r.Processor == "" {
+		return fmt.Sprintf("log rejected: %s", r.Reason)
+	}
+	return fmt.Sprintf("log rejected by %s: %s", r.Processor, r.Reason)
+}
+
+// Reject returns the error processors refuse a log with.
+func Reject(reason string) error {
+	return &Rejection{Reason: reason}
+}
+
+// A Delivery describes how a log reached the service.
+type Delivery struct {
+	Source     string // the ingestion source, e.g. "amqp"
+	ServiceID  model.ServiceID
+	MessageID  string
+	Headers    map[string]interface{}
+	Body       []byte // the message the log was decoded from
+	ReceivedAt time.Time
+}
+
+// A Processor inspects and may modify the logs going through a pipeline.
+// Process returns ErrDropped to discard a log, a Rejection to refuse it,
+// or any other error when it failed.
+type Processor interface {
+	Name() string
+	Process(ctx context.Context, log *model.Log, d *Delivery) error
+}
+
+type funcProcessor struct {
+	name string
+	fn   func(context.Context, *model.Log, *Delivery) error
+}
+
+func (p funcProcessor) Name() string { return p.name }
+
+func (p funcProcessor) Process(ctx context.Context, log *model.Log, d *Delivery) error {
+	return p.fn(ctx, log, d)
+}
+
+// Func returns a processor calling fn.
+func Func(name string, fn func(context.Context, *model.Log, *Delivery) error) Processor {
+	return funcProcessor{name: name, fn: fn}
+}
+
+// A Stage is a processor of a pipeline. The failure of a required stage
+// rejects the log; other stages are skipped when they fail, keeping what
+// they changed of the log until then.
+type Stage struct {
+	Processor Processor
+	Required  bool
+}
+
+// Stats counts the logs a processor went through.
+type Stats struct {
+	Name      string  `json:"name"`
+	Processed uint64  `json:"processed"`
+	Dropped   uint64  `json:"dropped"`
+	Rejected  uint64  `json:"rejected"`
+	Failed    uint64  `json:"failed"`
+	Seconds   float64 `json:"seconds"` // total time spent processing
+}
+
+type stage struct {
+	Stage
+	processed, dropped, rejected, failed atomic.Uint64
+	nanos                                atomic.Int64
+}
+
+// A Pipeline runs logs through its stages in order. It is safe for
+// concurrent use.
+type Pipeline struct {
+	stages    []*stage
+	onFailure func(processor string, err error)
+}
+
+// New returns a pipeline running the given stages, which reports the
+// failures of processors to onFailure.
+func New(stages []Stage, onFailure func(processor string, err error)) *Pipeline {
+	p := &Pipeline{onFailure: onFailure}
+	for _, s := range stages {
+		p.stages = append(p.stages, &stage{Stage: s})
+	}
+	return p
+}
+
+// Run runs a log through the pipeline. It returns an error wrapping
+// ErrDropped when a processor discarded the log, and a Rejection when one
+// refused it or a required one failed.
+func (p *Pipeline) Run(ctx context.Context, log *model.Log, d *Delivery) error {
+	for _, s := range p.stages {
+		name := s.Processor.Name()
+
+		start := time.Now()
+		err := s.process(ctx, log, d)
+		s.nanos.Add(int64(time.Since(start)))
+		s.processed.Add(1)
+
+		var rejection *Rejection
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrDropped):
+			s.dropped.Add(1)
+			return fmt.Errorf("%s: %w", name, ErrDropped)
+		case errors.As(err, &rejection):
+			s.rejected.Add(1)
+			r := *rejection
+			if r.Processor == "" {
+				r.Processor = name
+			}
+			return &r
+		default:
+			s.failed.Add(1)
+			if p.onFailure != nil {
+				p.onFailure(name, err)
+			}
+			if s.Required {
+				return &Rejection{Processor: name, Reason: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// process runs the processor of a stage, turning its panics into errors
+// so that they only fail the log at hand.
+func (s *stage) process(ctx context.Context, log *model.Log, d *Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.Processor.Process(ctx, log, d)
+}
+
+// Stats returns the counts of each stage, in order.
+func (p *Pipeline) Stats() []Stats {
+	stats := make([]Stats, len(p.stages))
+	for i, s := range p.stages {
+		stats[i] = Stats{
+			Name:      s.Processor.Name(),
+			Processed: s.processed.Load(),
+			Dropped:   s.dropped.Load(),
+			Rejected:  s.rejected.Load(),
+			Failed:    s.failed.Load(),
+			Seconds:   time.Duration(s.nanos.Load()).Seconds(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func tag(name, value string) Processor {
+	return Func(name, func(_ context.Context, log *model.Log, _ *Delivery) error {
+		log.Entity.Type += value
+		return nil
+	})
+}
+
+func TestRun(t *testing.T) {
+	var failures []string
+	onFailure := func(processor string, err error) {
+		failures = append(failures, processor+": "+err.Error())
+	}
+
+	p := New([]Stage{
+		{Processor: tag("a", "a")},
+		{Processor: Func("flaky", func(_ context.Context, log *model.Log, _ *Delivery) error {
+			if log.Action == "panic" {
+				panic("boom")
+			}
+			return errors.New("unavailable")
+		})},
+		{Processor: Func("policy", func(_ context.Context, log *model.Log, d *Delivery) error {
+			switch {
+			case log.Action == "healthcheck":
+				return ErrDropped
+			case d.ServiceID != "billing":
+				return Reject("not allowed")
+			}
+			return nil
+		})},
+		{Processor: tag("b", "b")},
+	}, onFailure)
+	delivery := &Delivery{Source: "amqp", ServiceID: "billing"}
+
+	// Test that logs go through every stage, past those failing
+	log := &model.Log{Action: "created"}
+	if err := p.Run(context.Background(), log, delivery); err != nil || log.Entity.Type != "ab" {
+		t.Errorf("Expected the log to be processed, got %q and %v", log.Entity.Type, err)
+	}
+	if err := p.Run(context.Background(), &model.Log{Action: "panic"}, delivery); err != nil {
+		t.Errorf("Expected a panic to be isolated, got %v", err)
+	}
+	if len(failures) != 2 || failures[0] != "flaky: unavailable" || failures[1] != "flaky: panic: boom" {
+		t.Errorf("Unexpected failures %q", failures)
+	}
+
+	// Test that dropped and rejected logs stop there
+	log = &model.Log{Action: "healthcheck"}
+	if err := p.Run(context.Background(), log, delivery); !errors.Is(err, ErrDropped) || log.Entity.Type != "a" {
+		t.Errorf("Expected the log to be dropped, got %q and %v", log.Entity.Type, err)
+	}
+	var rejection *Rejection
+	err := p.Run(context.Background(), &model.Log{Action: "created"}, &Delivery{ServiceID: "ops"})
+	if !errors.As(err, &rejection) || rejection.Processor != "policy" || rejection.Reason != "not allowed" {
+		t.Errorf("Expected a rejection, got %v", err)
+	}
+
+	stats := p.Stats()
+	expected := []Stats{
+		{Name: "a", Processed: 4},
+		{Name: "flaky", Processed: 4, Failed: 4},
+		{Name: "policy", Processed: 4, Dropped: 1, Rejected: 1},
+		{Name: "b", Processed: 2},
+	}
+	for i, s := range stats {
+		s.Seconds = 0
+		if s != expected[i] {
+			t.Errorf("Expected stats %+v, got %+v", expected[i], s)
+		}
+	}
+
+	// Test that the failure of a required stage rejects the log
+	p = New([]Stage{{Processor: Func("sign", func(context.Context, *model.Log, *Delivery) error {
+		return errors.New("no key")
+	}), Required: true}}, nil)
+	if err := p.Run(context.Background(), &model.Log{}, delivery); !errors.As(err, &rejection) || rejection.Error() != "log rejected by sign: no key" {
+		t.Errorf("Expected a rejection, got %v", err)
+	}
+}
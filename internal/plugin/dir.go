@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// A Dir runs logs through the plugins of a directory, in the order of
+// their file names. Each "<name>.wasm" file is a plugin, whose limits can
+// be set apart from the defaults in a "<name>.json" file:
+//
+//	{"timeout": "250ms", "memory": 128, "required": true}
+//
+// Logs are rejected when a required plugin fails on them; other plugins
+// are skipped. A Dir is safe for concurrent use.
+type Dir struct {
+	path      string
+	defaults  Limits
+	onFailure func(plugin string, err error)
+
+	mu      sync.Mutex // serializes reloads
+	loaded  map[string]*loaded
+	current atomic.Pointer[pipeline.Pipeline]
+}
+
+type loaded struct {
+	plugin   *Plugin
+	required bool
+	version  string
+}
+
+// settings are the limits of a plugin set apart from the defaults.
+type settings struct {
+	Timeout  string `json:"timeout"`
+	Memory   uint32 `json:"memory"`
+	Required bool   `json:"required"`
+}
+
+// OpenDir returns the plugins of a directory, which are loaded by Reload.
+// The failures of plugins are reported to onFailure.
+func OpenDir(path string, defaults Limits, onFailure func(plugin string, err error)) *Dir {
+	d := &Dir{
+		path:      path,
+		defaults:  defaults,
+		onFailure: onFailure,
+		loaded:    make(map[string]*loaded),
+	}
+	d.current.Store(pipeline.New(nil, onFailure))
+	return d
+}
+
+// Name returns the name of the plugins as a processor.
+func (d *Dir) Name() string {
+	return "plugins"
+}
+
+// Process runs a log through the plugins.
+func (d *Dir) Process(ctx context.Context, log *model.Log, del *pipeline.Delivery) error {
+	return d.current.Load().Run(ctx, log, del)
+}
+
+// Stats returns the counts of each plugin since the plugins last changed.
+func (d *Dir) Stats() []pipeline.Stats {
+	return d.current.Load().Stats()
+}
+
+// Reload loads the plugins added to the directory or changed since last
+// loaded, and unloads those removed. A plugin failing to load is reported
+// in the error, and keeps running its previous version, if any.
+func (d *Dir) Reload(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(d.path, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	var failed []string
+	changed := false
+	found := make(map[string]bool)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+		found[name] = true
+
+		version, err := fileVersion(path)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if old, ok := d.loaded[name]; ok && old.version == version {
+			continue
+		}
+
+		l, err := d.load(ctx, name, path, version)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if old, ok := d.loaded[name]; ok {
+			// Replaced plugins are closed once out of the pipeline.
+			defer old.plugin.Close(ctx)
+		}
+		d.loaded[name] = l
+		changed = true
+	}
+
+	for name, old := range d.loaded {
+		if !found[name] {
+			defer old.plugin.Close(ctx)
+			delete(d.loaded, name)
+			changed = true
+		}
+	}
+
+	if changed {
+		names := make([]string, 0, len(d.loaded))
+		for name := range d.loaded {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		stages := make([]pipeline.Stage, len(names))
+		for i, name := range names {
+			stages[i] = pipeline.Stage{Processor: d.loaded[name].plugin, Required: d.loaded[name].required}
+		}
+		d.current.Store(pipeline.New(stages, d.onFailure))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to load plugins: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// fileVersion identifies the version of a plugin by the size and time of
+// modification of its files.
+func fileVersion(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	version := fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+
+	info, err = os.Stat(strings.TrimSuffix(path, ".wasm") + ".json")
+	switch {
+	case err == nil:
+		version += fmt.Sprintf("/%d-%d", info.Size(), info.ModTime().UnixNano())
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	return version, nil
+}
+
+func (d *Dir) load(ctx context.Context, name, path, version string) (*loaded, error) {
+	limits := d.defaults
+	var s settings
+	body, err := os.ReadFile(strings.TrimSuffix(path, ".wasm") + ".json")
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(body, &s); err != nil {
+			return nil, fmt.Errorf("invalid settings: %w", err)
+		}
+		if s.Timeout != "" {
+			limits.Timeout, err = time.ParseDuration(s.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout %q", s.Timeout)
+			}
+		}
+		if s.Memory != 0 {
+			limits.Memory = s.Memory
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Load(ctx, name, wasm, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &loaded{plugin: p, required: s.Required, version: version}, nil
+}
+
+// Close unloads the plugins.
+func (d *Dir) Close(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.current.Store(pipeline.New(nil, d.onFailure))
+	for name, l := range d.loaded {
+		l.plugin.Close(ctx)
+		delete(d.loaded, name)
+	}
+	return nil
+}
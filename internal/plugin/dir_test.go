@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	wasm := exampleWasm(t)
+	path := t.TempDir()
+
+	var failures []string
+	d := OpenDir(path, Limits{Timeout: time.Second, Memory: 64}, func(plugin string, err error) {
+		failures = append(failures, plugin)
+	})
+	defer d.Close(ctx)
+
+	write := func(name string, body []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(path, name), body, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		var names []string
+		for _, s := range d.Stats() {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+
+	// Test that plugins are loaded in the order of their names
+	write("20-redact.wasm", wasm)
+	write("10-redact.wasm", wasm)
+	write("README.md", []byte("not a plugin"))
+	if err := d.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); len(n) != 2 || n[0] != "10-redact" || n[1] != "20-redact" {
+		t.Errorf("Unexpected plugins %q", n)
+	}
+	log := &model.Log{Action: "login", Extension: map[string]interface{}{"token": "abc"}}
+	if err := d.Process(ctx, log, amqpDelivery); err != nil || log.Extension["token"] != "[REDACTED]" {
+		t.Errorf("Expected the token to be redacted, got %v and %v", log.Extension, err)
+	}
+
+	// Test that a plugin failing to load keeps its previous version
+	write("10-redact.json", []byte(`{"timeout": "soon"}`))
+	if err := d.Reload(ctx); err == nil {
+		t.Errorf("Expected error for invalid settings")
+	}
+	if n := names(); len(n) != 2 {
+		t.Errorf("Expected the plugins to be kept, got %q", n)
+	}
+
+	// Test that changed plugins are reloaded, and required ones reject
+	// the logs they fail on
+	write("10-redact.json", []byte(`{"timeout": "1ns", "required": true}`))
+	if err := d.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var rejection *pipeline.Rejection
+	err := d.Process(ctx, &model.Log{Action: "login"}, amqpDelivery)
+	if !errors.As(err, &rejection) || rejection.Processor != "10-redact" {
+		t.Errorf("Expected a rejection by 10-redact, got %v", err)
+	}
+	if len(failures) != 1 || failures[0] != "10-redact" {
+		t.Errorf("Unexpected failures %q", failures)
+	}
+
+	// Test that removed plugins are unloaded
+	os.Remove(filepath.Join(path, "10-redact.wasm"))
+	if err := d.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); len(n) != 1 || n[0] != "20-redact" {
+		t.Errorf("Unexpected plugins %q", n)
+	}
+}
@@ -0,0 +1,305 @@
+// Package plugin runs WebAssembly modules as log processors, each in a
+// sandbox of its own with limits on the time and memory it may use.
+//
+// A plugin is a WASI reactor module exporting its memory and two
+// functions:
+//
+//	logaudit_alloc(size i32) -> i32
+//	logaudit_process(ptr i32, size i32) -> i64
+//
+// For each log, the service calls logaudit_alloc for a buffer of size
+// bytes, writes a JSON request to it, and calls logaudit_process with the
+// address and size of the request. The request holds the log, as served
+// by the API, and how it was delivered:
+//
+//	{"log": {...}, "delivery": {"source": "amqp", "service_id": "...", "message_id": "...", "received_at": "..."}}
+//
+// logaudit_process returns the address of the JSON result in its upper 32
+// bits and its size in the lower ones. The result is one of:
+//
+//	{}                      the log is kept as it is
+//	{"log": {...}}          the log is replaced
+//	{"drop": true}          the log is dropped
+//	{"reject": "<reason>"}  the log is rejected
+//
+// The buffer and the result only need to stay valid until the next call.
+// Plugins are called for one log at a time, and may keep state between
+// logs; they are started afresh after failing. They have no access to the
+// file system or the network, and their clocks are not the wall clock.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	allocFunc   = "logaudit_alloc"
+	processFunc = "logaudit_process"
+
+	// startTimeout bounds the initialization of plugins.
+	startTimeout = 5 * time.Second
+	// pageSize is the size of a page of WebAssembly memory.
+	pageSize = 64 << 10
+)
+
+// ErrClosed is returned by plugins called once closed.
+var ErrClosed = errors.New("plugin closed")
+
+// Limits bound the resources of a plugin.
+type Limits struct {
+	Timeout time.Duration // the longest a plugin may take over a log
+	Memory  uint32        // the most memory a plugin may use, in MiB
+}
+
+// A Plugin is a log processor running a WebAssembly module.
+type Plugin struct {
+	name    string
+	timeout time.Duration
+	runtime wazero.Runtime
+	code    wazero.CompiledModule
+
+	mu      sync.Mutex
+	mod     api.Module // nil until started, and after failing
+	closed  bool
+	alloc   api.Function
+	process api.Function
+}
+
+// Load compiles and starts the plugin of a WebAssembly module.
+func Load(ctx context.Context, name string, wasm []byte, limits Limits) (*Plugin, error) {
+	pages := uint64(limits.Memory) << 20 / pageSize
+	if pages == 0 || pages > 65536 {
+		return nil, fmt.Errorf("invalid memory limit of %d MiB", limits.Memory)
+	}
+	if limits.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid timeout %s", limits.Timeout)
+	}
+
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(pages)).
+		WithCloseOnContextDone(true)
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+
+	p := &Plugin{name: name, timeout: limits.Timeout, runtime: r}
+	err := p.compile(ctx, wasm)
+	if err == nil {
+		p.mu.Lock()
+		err = p.start()
+		p.mu.Unlock()
+	}
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Plugin) compile(ctx context.Context, wasm []byte) error {
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime)
+	if err != nil {
+		return err
+	}
+
+	p.code, err = p.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return err
+	}
+
+	exports := p.code.ExportedFunctions()
+	for _, fn := range []struct {
+		name    string
+		params  []api.ValueType
+		results []api.ValueType
+	}{
+		{allocFunc, []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}},
+		{processFunc, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}},
+	} {
+		def, ok := exports[fn.name]
+		if !ok {
+			return fmt.Errorf("missing export %s", fn.name)
+		}
+		if !sameTypes(def.ParamTypes(), fn.params) || !sameTypes(def.ResultTypes(), fn.results) {
+			return fmt.Errorf("invalid signature of export %s", fn.name)
+		}
+	}
+	if len(p.code.ExportedMemories()) == 0 {
+		return errors.New("missing exported memory")
+	}
+	return nil
+}
+
+func sameTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// start instantiates the module of the plugin. p.mu must be held.
+func (p *Plugin) start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize")
+	mod, err := p.runtime.InstantiateModule(ctx, p.code, config)
+	if err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	p.mod = mod
+	p.alloc = mod.ExportedFunction(allocFunc)
+	p.process = mod.ExportedFunction(processFunc)
+	return nil
+}
+
+// Name returns the name of the plugin.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+type request struct {
+	Log      *model.Log `json:"log"`
+	Delivery delivery   `json:"delivery"`
+}
+
+type delivery struct {
+	Source     string          `json:"source"`
+	ServiceID  model.ServiceID `json:"service_id,omitempty"`
+	MessageID  string          `json:"message_id,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+type result struct {
+	Log    json.RawMessage `json:"log"`
+	Drop   bool            `json:"drop"`
+	Reject string          `json:"reject"`
+}
+
+// Process runs a log through the plugin. It fails when the plugin traps,
+// runs out of time or memory, or returns an invalid result.
+func (p *Plugin) Process(ctx context.Context, log *model.Log, d *pipeline.Delivery) error {
+	body, err := json.Marshal(request{
+		Log: log,
+		Delivery: delivery{
+			Source:     d.Source,
+			ServiceID:  d.ServiceID,
+			MessageID:  d.MessageID,
+			ReceivedAt: d.ReceivedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := p.call(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	var res result
+	if err := json.Unmarshal(out, &res); err != nil {
+		return fmt.Errorf("invalid result: %w", err)
+	}
+	switch {
+	case res.Reject != "":
+		return pipeline.Reject(res.Reject)
+	case res.Drop:
+		return pipeline.ErrDropped
+	case len(res.Log) == 0 || string(res.Log) == "null":
+		return nil
+	}
+
+	var processed model.Log
+	if err := json.Unmarshal(res.Log, &processed); err != nil {
+		return fmt.Errorf("invalid log: %w", err)
+	}
+	// What is not served by the API is not the plugin's to change.
+	processed.ID = log.ID
+	processed.ServiceID = log.ServiceID
+	processed.Integrity = log.Integrity
+	processed.SearchTerms = log.SearchTerms
+	*log = processed
+	return nil
+}
+
+// call passes a request to the plugin and returns a copy of its result.
+func (p *Plugin) call(ctx context.Context, body []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if p.mod == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	out, err := p.exchange(ctx, body)
+	if err != nil {
+		// The state of the plugin is unknown: start it afresh next time.
+		p.mod.Close(context.Background())
+		p.mod = nil
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", p.timeout)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *Plugin) exchange(ctx context.Context, body []byte) ([]byte, error) {
+	ret, err := p.alloc.Call(ctx, uint64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(ret[0])
+	if !p.mod.Memory().Write(ptr, body) {
+		return nil, fmt.Errorf("%s returned an invalid address", allocFunc)
+	}
+
+	ret, err = p.process.Call(ctx, uint64(ptr), uint64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	out, ok := p.mod.Memory().Read(uint32(ret[0]>>32), uint32(ret[0]))
+	if !ok {
+		return nil, fmt.Errorf("%s returned an invalid result", processFunc)
+	}
+	return append([]byte(nil), out...), nil
+}
+
+// Close stops the plugin, waiting for the log it is processing, if any.
+func (p *Plugin) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.mod = nil
+	return p.runtime.Close(ctx)
+}
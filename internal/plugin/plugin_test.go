@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/pipeline"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var example struct {
+	once sync.Once
+	wasm []byte
+	err  error
+}
+
+// exampleWasm builds the example plugin, which takes a Go toolchain able
+// to target wasip1.
+func exampleWasm(t *testing.T) []byte {
+	t.Helper()
+
+	example.once.Do(func() {
+		dir, err := os.MkdirTemp("", "plugin")
+		if err != nil {
+			example.err = err
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		out := filepath.Join(dir, "redact.wasm")
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", out, ".")
+		cmd.Dir = filepath.Join("..", "..", "plugins", "redact")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			example.err = fmt.Errorf("%v: %s", err, output)
+			return
+		}
+		example.wasm, example.err = os.ReadFile(out)
+	})
+	if example.err != nil {
+		t.Skipf("Cannot build the example plugin: %v", example.err)
+	}
+	return example.wasm
+}
+
+var amqpDelivery = &pipeline.Delivery{Source: "amqp", ServiceID: "billing", ReceivedAt: time.Now()}
+
+func TestProcess(t *testing.T) {
+	ctx := context.Background()
+	p, err := Load(ctx, "redact", exampleWasm(t), Limits{Timeout: time.Second, Memory: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(ctx)
+
+	// Test that the log is replaced by the one returned
+	log := &model.Log{
+		ID:        primitive.NewObjectID(),
+		Action:    "login",
+		Actor:     model.Actor{Type: "user", ID: "12300", Extension: map[string]interface{}{"password": "hunter2"}},
+		ServiceID: "billing",
+		Integrity: &model.Integrity{Algorithm: "sha256"},
+	}
+	id := log.ID
+	if err := p.Process(ctx, log, amqpDelivery); err != nil {
+		t.Fatal(err)
+	}
+	if log.Actor.Extension["password"] != "[REDACTED]" || log.Actor.ID != "12300" {
+		t.Errorf("Expected the password to be redacted, got %+v", log.Actor)
+	}
+	if log.ID != id || log.ServiceID != "billing" || log.Integrity == nil {
+		t.Errorf("Expected the fields not served to be kept, got %+v", log)
+	}
+
+	// Test that logs can be dropped and rejected
+	if err := p.Process(ctx, &model.Log{Action: "healthcheck"}, amqpDelivery); !errors.Is(err, pipeline.ErrDropped) {
+		t.Errorf("Expected the log to be dropped, got %v", err)
+	}
+	var rejection *pipeline.Rejection
+	err = p.Process(ctx, &model.Log{Action: "viewed", Actor: model.Actor{Type: "anonymous"}}, amqpDelivery)
+	if !errors.As(err, &rejection) || rejection.Reason != "anonymous actors are not audited" {
+		t.Errorf("Expected the log to be rejected, got %v", err)
+	}
+
+	// Test that a closed plugin fails
+	p.Close(ctx)
+	if err := p.Process(ctx, &model.Log{Action: "login"}, amqpDelivery); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	wasm := exampleWasm(t)
+
+	// Test that a plugin running out of time fails, and is started afresh
+	p, err := Load(ctx, "redact", wasm, Limits{Timeout: time.Nanosecond, Memory: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(ctx)
+	for i := 0; i < 2; i++ {
+		if err := p.Process(ctx, &model.Log{Action: "login"}, amqpDelivery); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected a timeout, got %v", err)
+		}
+	}
+
+	// Test that a plugin needing more memory than allowed does not load
+	if _, err := Load(ctx, "redact", wasm, Limits{Timeout: time.Second, Memory: 1}); err == nil {
+		t.Errorf("Expected error for a plugin over its memory limit")
+	}
+
+	// Test with a module not implementing the ABI
+	empty := []byte("\x00asm\x01\x00\x00\x00")
+	if _, err := Load(ctx, "empty", empty, Limits{Timeout: time.Second, Memory: 64}); err == nil || !strings.Contains(err.Error(), "missing export") {
+		t.Errorf("Expected a missing export, got %v", err)
+	}
+}
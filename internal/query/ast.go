@@ -0,0 +1,146 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Expr is a node of a parsed filter expression.
+type Expr interface {
+	fmt.Stringer
+	expr()
+}
+
+// An Op is a comparison operator.
+type Op string
+
+const (
+	OpEq  Op = "="
+	OpNe  Op = "!="
+	OpLt  Op = "<"
+	OpLte Op = "<="
+	OpGt  Op = ">"
+	OpGte Op = ">="
+)
+
+// And matches when every one of its operands matches.
+type And struct {
+	Exprs []Expr
+}
+
+// Or matches when at least one of its operands matches.
+type Or struct {
+	Exprs []Expr
+}
+
+// Not negates its operand.
+type Not struct {
+	Expr Expr
+}
+
+// Compare compares a field against a literal value.
+type Compare struct {
+	Field Field
+	Op    Op
+	Value interface{}
+}
+
+// In matches when a field equals any of the listed values.
+type In struct {
+	Field  Field
+	Values []interface{}
+}
+
+// Prefix matches string fields starting with the given prefix.
+type Prefix struct {
+	Field  Field
+	Prefix string
+}
+
+// Exists matches when a field is present on the log.
+type Exists struct {
+	Field Field
+}
+
+func (And) expr()     {}
+func (Or) expr()      {}
+func (Not) expr()     {}
+func (Compare) expr() {}
+func (In) expr()      {}
+func (Prefix) expr()  {}
+func (Exists) expr()  {}
+
+func (e And) String() string { return join(e.Exprs, " AND ") }
+func (e Or) String() string  { return join(e.Exprs, " OR ") }
+func (e Not) String() string { return "NOT " + group(e.Expr) }
+
+func (e Compare) String() string {
+	return fmt.Sprintf("%s %s %s", e.Field.Name, e.Op, literal(e.Value))
+}
+
+func (e In) String() string {
+	values := make([]string, len(e.Values))
+	for i, value := range e.Values {
+		values[i] = literal(value)
+	}
+	return fmt.Sprintf("%s IN (%s)", e.Field.Name, strings.Join(values, ", "))
+}
+
+func (e Prefix) String() string {
+	return fmt.Sprintf("%s PREFIX %s", e.Field.Name, literal(e.Prefix))
+}
+
+func (e Exists) String() string { return e.Field.Name + " EXISTS" }
+
+// AndOf combines expressions, skipping nil ones. It returns nil when no
+// expression is left.
+func AndOf(exprs ...Expr) Expr {
+	var operands []Expr
+	for _, e := range exprs {
+		if e != nil {
+			operands = append(operands, e)
+		}
+	}
+
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	default:
+		return And{Exprs: operands}
+	}
+}
+
+func join(exprs []Expr, sep string) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = group(e)
+	}
+	return strings.Join(parts, sep)
+}
+
+// group wraps compound expressions in parentheses.
+func group(e Expr) string {
+	switch e.(type) {
+	case And, Or:
+		return "(" + e.String() + ")"
+	default:
+		return e.String()
+	}
+}
+
+func literal(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,174 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// Match evaluates an expression against a log in memory. It follows the
+// semantics of the database compilation: comparisons between values of
+// different types never match, and negations match absent fields.
+func Match(e Expr, log *model.Log) bool {
+	switch e := e.(type) {
+	case nil:
+		return true
+	case And:
+		for _, operand := range e.Exprs {
+			if !Match(operand, log) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, operand := range e.Exprs {
+			if Match(operand, log) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !Match(e.Expr, log)
+	case Compare:
+		value, ok := e.Field.Value(log)
+		if !ok {
+			return e.Op == OpNe
+		}
+		if e.Op == OpNe {
+			// No element of a list may be equal, as in the database.
+			return allOf(value, func(v interface{}) bool { return !equal(v, e.Value) })
+		}
+		return anyOf(value, func(v interface{}) bool { return compareOp(e.Op, v, e.Value) })
+	case In:
+		value, ok := e.Field.Value(log)
+		if !ok {
+			return false
+		}
+		return anyOf(value, func(v interface{}) bool {
+			for _, candidate := range e.Values {
+				if equal(v, candidate) {
+					return true
+				}
+			}
+			return false
+		})
+	case Prefix:
+		value, ok := e.Field.Value(log)
+		return ok && anyOf(value, func(v interface{}) bool {
+			s, isString := v.(string)
+			return isString && strings.HasPrefix(s, e.Prefix)
+		})
+	case Exists:
+		_, ok := e.Field.Value(log)
+		return ok
+	default:
+		return false
+	}
+}
+
+// anyOf reports whether f holds for a value or, for a list, for one of its
+// elements, as conditions on lists are evaluated in the database.
+func anyOf(value interface{}, f func(interface{}) bool) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		return f(value)
+	}
+	for _, v := range list {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// allOf reports whether f holds for a value or, for a list, for all of its
+// elements.
+func allOf(value interface{}, f func(interface{}) bool) bool {
+	return !anyOf(value, func(v interface{}) bool { return !f(v) })
+}
+
+// compareOp evaluates an ordering or equality comparison.
+func compareOp(op Op, a, b interface{}) bool {
+	if op == OpEq {
+		return equal(a, b)
+	}
+	c, ok := compare(a, b)
+	if !ok {
+		return false
+	}
+	switch op {
+	case OpLt:
+		return c < 0
+	case OpLte:
+		return c <= 0
+	case OpGt:
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func equal(a, b interface{}) bool {
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// compare orders two values of the same type. The second result is false
+// when the values are not comparable. Dates held as strings, as they are
+// in extension maps, are compared as dates.
+func compare(a, b interface{}) (int, bool) {
+	if s, ok := a.(string); ok {
+		if _, ok := b.(time.Time); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return 0, false
+			}
+			a = t
+		}
+	}
+
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		b, ok := b.(bool)
+		if !ok || a == b {
+			return 0, ok
+		}
+		if !a {
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a.Before(b):
+			return -1, true
+		case a.After(b):
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
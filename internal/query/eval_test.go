@@ -0,0 +1,74 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestMatch(t *testing.T) {
+	log := &model.Log{
+		Timestamp: time.Date(2022, 8, 16, 12, 0, 0, 0, time.UTC),
+		Action:    "deleted",
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Entity:    model.Entity{Type: "inventory"},
+		Context:   model.Context{IPAddr: "10.0.0.1", Location: "NG"},
+		IP:        &model.IPInfo{Country: "NG", ASN: 37148, Tags: []string{"corporate-vpn", "office"}},
+		UserAgent: &model.UserAgent{Browser: "Chrome", BrowserVersion: "83.0.4103.116", OS: "Windows", Device: "desktop"},
+		Extension: map[string]interface{}{
+			"amount": float64(150),
+			"billing": map[string]interface{}{
+				"due":  "2022-09-01T00:00:00Z",
+				"paid": false,
+			},
+		},
+	}
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"actor.type = user AND action IN (deleted, deactivated) AND context.location != 'US'", true},
+		{"actor.type = service OR action = deleted", true},
+		{"NOT action = deleted", false},
+		{"action NOT IN (created, updated)", true},
+		{"context.ip_address PREFIX '10.'", true},
+		{"context.ip_address PREFIX '192.'", false},
+		{"timestamp >= 2022-08-16 AND timestamp < 2022-08-17", true},
+		{"timestamp > 2022-08-16T12:00:00Z", false},
+		{"actor.id <= 2", true},
+		{"entity.type EXISTS", true},
+		{"extension.amount > 100", true},
+		{"extension.amount:string = 150", false},
+		{"extension.billing.due < 2022-10-01", true},
+		{"extension.billing.paid = false", true},
+		{"extension.missing EXISTS", false},
+		{"extension.missing != x", true},
+		{"ip.country = NG AND ip.asn = 37148", true},
+		{"ip.city EXISTS", false},
+		{"ip.tags = office", true},
+		{"ip.tags IN (tor-exit, corporate-vpn)", true},
+		{"ip.tags != office", false},
+		{"ip.tags != tor-exit", true},
+		{"ip.tags PREFIX corp", true},
+		{"user_agent.browser = Chrome AND user_agent.device = desktop AND user_agent.bot = false", true},
+		{"user_agent.os_version EXISTS", false},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got := Match(expr, log); got != tt.expected {
+			t.Errorf("Match(%q): expected %v, got %v", tt.input, tt.expected, got)
+		}
+	}
+
+	// Test with no expression
+	if !Match(nil, log) {
+		t.Errorf("Expected nil expression to match")
+	}
+}
@@ -0,0 +1,328 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A Type is the data type of a queryable field.
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeNumber
+	TypeBool
+	TypeTime
+	TypeAny // inferred from each literal the field is compared with
+)
+
+// String returns the name of the type as used in error messages.
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeNumber:
+		return "number"
+	case TypeBool:
+		return "boolean"
+	case TypeTime:
+		return "date"
+	case TypeAny:
+		return "any"
+	default:
+		return ""
+	}
+}
+
+// A Field describes a queryable log field.
+type Field struct {
+	Name      string // name used in query expressions
+	Path      string // dotted path of the field in storage
+	Type      Type
+	Extension bool // whether the field lives in one of the extension maps
+	List      bool // whether the field holds a list of values
+	get       func(*model.Log) (interface{}, bool)
+}
+
+// Value returns the value of the field on a log and whether it is present.
+func (f Field) Value(log *model.Log) (interface{}, bool) {
+	return f.get(log)
+}
+
+var fields = []Field{
+	{Name: "timestamp", Path: "timestamp", Type: TypeTime, get: func(l *model.Log) (interface{}, bool) {
+		return l.Timestamp, true
+	}},
+	{Name: "action", Path: "action", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Action, true
+	}},
+	{Name: "actor.type", Path: "actor.type", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Actor.Type, true
+	}},
+	{Name: "actor.id", Path: "actor.id", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Actor.ID, true
+	}},
+	{Name: "entity.type", Path: "entity.type", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Entity.Type, true
+	}},
+	{Name: "entity.id", Path: "entity.id", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Entity.ID, l.Entity.ID != ""
+	}},
+	{Name: "context.ip_address", Path: "context.ipaddr", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Context.IPAddr, true
+	}},
+	{Name: "context.location", Path: "context.location", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return l.Context.Location, true
+	}},
+	{Name: "ip.country", Path: "ip.country", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return ipString(l, func(ip *model.IPInfo) string { return ip.Country })
+	}},
+	{Name: "ip.region", Path: "ip.region", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return ipString(l, func(ip *model.IPInfo) string { return ip.Region })
+	}},
+	{Name: "ip.city", Path: "ip.city", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return ipString(l, func(ip *model.IPInfo) string { return ip.City })
+	}},
+	{Name: "ip.asn", Path: "ip.asn", Type: TypeNumber, get: func(l *model.Log) (interface{}, bool) {
+		if l.IP == nil || l.IP.ASN == 0 {
+			return nil, false
+		}
+		return float64(l.IP.ASN), true
+	}},
+	{Name: "ip.as_org", Path: "ip.as_org", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return ipString(l, func(ip *model.IPInfo) string { return ip.ASOrg })
+	}},
+	{Name: "ip.tags", Path: "ip.tags", Type: TypeString, List: true, get: func(l *model.Log) (interface{}, bool) {
+		if l.IP == nil || len(l.IP.Tags) == 0 {
+			return nil, false
+		}
+		tags := make([]interface{}, len(l.IP.Tags))
+		for i, tag := range l.IP.Tags {
+			tags[i] = tag
+		}
+		return tags, true
+	}},
+	{Name: "user_agent.browser", Path: "user_agent.browser", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return userAgentString(l, func(ua *model.UserAgent) string { return ua.Browser })
+	}},
+	{Name: "user_agent.browser_version", Path: "user_agent.browser_version", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return userAgentString(l, func(ua *model.UserAgent) string { return ua.BrowserVersion })
+	}},
+	{Name: "user_agent.os", Path: "user_agent.os", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return userAgentString(l, func(ua *model.UserAgent) string { return ua.OS })
+	}},
+	{Name: "user_agent.os_version", Path: "user_agent.os_version", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return userAgentString(l, func(ua *model.UserAgent) string { return ua.OSVersion })
+	}},
+	{Name: "user_agent.device", Path: "user_agent.device", Type: TypeString, get: func(l *model.Log) (interface{}, bool) {
+		return userAgentString(l, func(ua *model.UserAgent) string { return ua.Device })
+	}},
+	{Name: "user_agent.bot", Path: "user_agent.bot", Type: TypeBool, get: func(l *model.Log) (interface{}, bool) {
+		if l.UserAgent == nil {
+			return nil, false
+		}
+		return l.UserAgent.Bot, true
+	}},
+}
+
+// ipString returns a field of the description of the IP address of a log,
+// which is absent when empty.
+func ipString(l *model.Log, get func(*model.IPInfo) string) (interface{}, bool) {
+	if l.IP == nil {
+		return nil, false
+	}
+	s := get(l.IP)
+	return s, s != ""
+}
+
+// userAgentString returns a field of the description of the user agent of
+// a log, which is absent when empty.
+func userAgentString(l *model.Log, get func(*model.UserAgent) string) (interface{}, bool) {
+	if l.UserAgent == nil {
+		return nil, false
+	}
+	s := get(l.UserAgent)
+	return s, s != ""
+}
+
+// aliases maps alternative field names to their canonical name.
+var aliases = map[string]string{
+	"created_at": "timestamp",
+}
+
+// extensions maps the prefix of each extension map to its accessor.
+var extensions = []struct {
+	prefix string
+	get    func(*model.Log) map[string]interface{}
+}{
+	{"extension.", func(l *model.Log) map[string]interface{} { return l.Extension }},
+	{"actor.extension.", func(l *model.Log) map[string]interface{} { return l.Actor.Extension }},
+	{"entity.extension.", func(l *model.Log) map[string]interface{} { return l.Entity.Extension }},
+	{"context.extension.", func(l *model.Log) map[string]interface{} { return l.Context.Extension }},
+}
+
+// typeNames maps the names accepted in type declarations to their type.
+var typeNames = map[string]Type{
+	"string":  TypeString,
+	"number":  TypeNumber,
+	"bool":    TypeBool,
+	"boolean": TypeBool,
+	"date":    TypeTime,
+}
+
+// LookupField returns the field known by the given name. Besides the
+// fixed fields, any path into an extension map such as
+// "entity.extension.item_id" is accepted.
+func LookupField(name string) (Field, bool) {
+	if canonical, ok := aliases[name]; ok {
+		name = canonical
+	}
+
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	for _, ext := range extensions {
+		if !strings.HasPrefix(name, ext.prefix) {
+			continue
+		}
+
+		keys := strings.Split(strings.TrimPrefix(name, ext.prefix), ".")
+		for _, key := range keys {
+			if !validKey(key) {
+				return Field{}, false
+			}
+		}
+
+		get := ext.get
+		return Field{
+			Name:      name,
+			Path:      name,
+			Type:      TypeAny,
+			Extension: true,
+			get: func(l *model.Log) (interface{}, bool) {
+				return lookup(get(l), keys)
+			},
+		}, true
+	}
+
+	return Field{}, false
+}
+
+// IsExtensionPath reports whether s starts with the prefix of one of the
+// extension maps.
+func IsExtensionPath(s string) bool {
+	for _, ext := range extensions {
+		if strings.HasPrefix(s, ext.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseField resolves a field reference as written in an expression. An
+// extension field may declare the type its values are compared as with a
+// suffix such as "extension.amount:number".
+func ParseField(ref string) (Field, error) {
+	name, typeName, declared := strings.Cut(ref, ":")
+
+	field, ok := LookupField(name)
+	if !ok {
+		return Field{}, fmt.Errorf("unknown field %s", name)
+	}
+
+	if declared {
+		t, ok := typeNames[strings.ToLower(typeName)]
+		switch {
+		case !field.Extension:
+			return Field{}, fmt.Errorf("a type can only be declared on extension fields")
+		case !ok:
+			return Field{}, fmt.Errorf("unknown type %s, must be one of string, number, bool or date", typeName)
+		}
+		field.Type = t
+	}
+
+	return field, nil
+}
+
+// validKey reports whether an extension key is safe to use in a path.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// lookup walks nested extension maps, normalizing numbers to float64.
+func lookup(m map[string]interface{}, keys []string) (interface{}, bool) {
+	var value interface{} = m
+
+	for _, key := range keys {
+		var ok bool
+		switch doc := value.(type) {
+		case map[string]interface{}:
+			value, ok = doc[key]
+		case primitive.M:
+			value, ok = doc[key]
+		case primitive.D:
+			value, ok = doc.Map()[key]
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case primitive.DateTime:
+		return n.Time(), true
+	}
+
+	return value, value != nil
+}
+
+// infer converts an unquoted literal compared with a field of unknown
+// type to the most specific type it can be read as.
+func infer(text string) interface{} {
+	if b, err := strconv.ParseBool(text); err == nil && strings.EqualFold(text, strconv.FormatBool(b)) {
+		return b
+	}
+	if n, err := strconv.ParseFloat(text, 64); err == nil {
+		return n
+	}
+	if t, err := parseTime(text); err == nil {
+		return t
+	}
+	return text
+}
+
+// parseTime parses date literals, either full RFC 3339 timestamps or
+// plain dates.
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An Error reports a malformed or invalid expression, along with the
+// 1-based position in the input where the problem was found.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 1-based offset of the token in the input
+}
+
+// keyword reports whether the token is the given case-insensitive keyword.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return fmt.Sprintf("string '%s'", t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+// lex splits the input into tokens.
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		c := input[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i + 1})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i + 1})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{tokOp, "=", i + 1})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(input) && input[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &Error{i + 1, "expected '!='"}
+			}
+			tokens = append(tokens, token{tokOp, op, i + 1})
+			i += len(op)
+		case c == '\'' || c == '"':
+			text, n, err := lexString(input[i:])
+			if err != nil {
+				return nil, &Error{i + 1, err.Error()}
+			}
+			tokens = append(tokens, token{tokString, text, i + 1})
+			i += n
+		default:
+			start := i
+			for i < len(input) && !strings.ContainsRune(" \t\n\r(),=!<>'\"", rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, input[start:i], start + 1})
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", len(input) + 1}), nil
+}
+
+// lexString reads a quoted string, returning its unescaped content and
+// the number of input bytes consumed.
+func lexString(input string) (string, int, error) {
+	quote := input[0]
+
+	var b strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 == len(input) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			b.WriteByte(input[i])
+		case quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(input[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
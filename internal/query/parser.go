@@ -0,0 +1,362 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxLength = 4096 // maximum length of an expression
+	maxDepth  = 32   // maximum nesting of parentheses and NOT
+)
+
+// Parse parses a filter expression such as
+//
+//	actor.type = user AND action IN (deleted, deactivated) AND context.location != 'US'
+//
+// into an Expr. Field names are checked against the known fields and
+// literal values are converted to the type of the field they are compared
+// with. Any problem is reported as an *Error carrying its position.
+func Parse(input string) (Expr, error) {
+	if len(input) > maxLength {
+		return nil, &Error{maxLength + 1, fmt.Sprintf("expression must not be longer than %d bytes", maxLength)}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok.describe())
+	}
+
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &Error{tok.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) enter(tok token) error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf(tok, "expression is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// parseOr parses: and { OR and }
+func (p *parser) parseOr() (Expr, error) {
+	expr, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{expr}
+	for p.peek().keyword("OR") {
+		p.next()
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return Or{Exprs: exprs}, nil
+}
+
+// parseAnd parses: unary { AND unary }
+func (p *parser) parseAnd() (Expr, error) {
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{expr}
+	for p.peek().keyword("AND") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return And{Exprs: exprs}, nil
+}
+
+// parseUnary parses: NOT unary | "(" or ")" | condition
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+
+	switch {
+	case tok.keyword("NOT"):
+		p.next()
+		if err := p.enter(tok); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+
+	case tok.kind == tokLParen:
+		p.next()
+		if err := p.enter(tok); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, p.errorf(closing, "expected ')', found %s", closing.describe())
+		}
+		return expr, nil
+
+	default:
+		return p.parseCondition()
+	}
+}
+
+// parseCondition parses a condition on a single field:
+//
+//	field op value
+//	field [NOT] IN (value, ...)
+//	field PREFIX value
+//	field [NOT] EXISTS
+func (p *parser) parseCondition() (Expr, error) {
+	tok := p.next()
+	if tok.kind != tokWord || isKeyword(tok.text) {
+		return nil, p.errorf(tok, "expected a field name, found %s", tok.describe())
+	}
+
+	field, err := p.resolveField(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	negate := false
+	if p.peek().keyword("NOT") {
+		p.next()
+		negate = true
+		if next := p.peek(); !next.keyword("IN") && !next.keyword("EXISTS") {
+			return nil, p.errorf(next, "expected IN or EXISTS after NOT, found %s", next.describe())
+		}
+	}
+
+	var expr Expr
+
+	op := p.next()
+	switch {
+	case op.kind == tokOp:
+		expr, err = p.parseCompare(field, op)
+	case op.keyword("IN"):
+		expr, err = p.parseIn(field)
+	case op.keyword("PREFIX"):
+		expr, err = p.parsePrefix(field, op)
+	case op.keyword("EXISTS"):
+		expr = Exists{Field: field}
+	default:
+		return nil, p.errorf(op, "expected an operator, found %s", op.describe())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if negate {
+		return Not{Expr: expr}, nil
+	}
+	return expr, nil
+}
+
+func (p *parser) parseCompare(field Field, op token) (Expr, error) {
+	value, err := p.parseValue(field)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := value.(bool); ok && op.text != string(OpEq) && op.text != string(OpNe) {
+		return nil, p.errorf(op, "operator %s is not supported on boolean values", op.text)
+	}
+
+	return Compare{Field: field, Op: Op(op.text), Value: value}, nil
+}
+
+func (p *parser) parseIn(field Field) (Expr, error) {
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, p.errorf(tok, "expected '(' after IN, found %s", tok.describe())
+	}
+
+	var values []interface{}
+	for {
+		value, err := p.parseValue(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			break
+		}
+		if tok.kind != tokComma {
+			return nil, p.errorf(tok, "expected ',' or ')', found %s", tok.describe())
+		}
+	}
+
+	return In{Field: field, Values: values}, nil
+}
+
+func (p *parser) parsePrefix(field Field, op token) (Expr, error) {
+	if field.Type != TypeString && field.Type != TypeAny {
+		return nil, p.errorf(op, "PREFIX is only supported on string fields, %s is a %s", field.Name, field.Type)
+	}
+
+	tok := p.next()
+	if tok.kind != tokWord && tok.kind != tokString {
+		return nil, p.errorf(tok, "expected a value, found %s", tok.describe())
+	}
+
+	return Prefix{Field: field, Prefix: tok.text}, nil
+}
+
+// parseValue reads a literal and converts it to the type of the field.
+func (p *parser) parseValue(field Field) (interface{}, error) {
+	tok := p.next()
+	if tok.kind != tokWord && tok.kind != tokString {
+		return nil, p.errorf(tok, "expected a value, found %s", tok.describe())
+	}
+
+	value, err := convert(field, tok.text, tok.kind == tokString)
+	if err != nil {
+		return nil, p.errorf(tok, "%s", err)
+	}
+	return value, nil
+}
+
+// convert converts a literal to the type of a field. The type of quoted
+// literals compared with untyped extension fields is string, while that of
+// others is inferred from their text.
+func convert(field Field, text string, quoted bool) (interface{}, error) {
+	switch field.Type {
+	case TypeAny:
+		if quoted {
+			return text, nil
+		}
+		return infer(text), nil
+	case TypeNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", field.Name)
+		}
+		return n, nil
+	case TypeBool:
+		b, err := strconv.ParseBool(strings.ToLower(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", field.Name)
+		}
+		return b, nil
+	case TypeTime:
+		t, err := parseTime(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a date", field.Name)
+		}
+		return t, nil
+	default:
+		return text, nil
+	}
+}
+
+// Condition compares a field, referred to as in an expression, with a
+// value given apart from any expression, such as in a query parameter.
+// The value is never parsed as an expression: it is a string when quoted
+// as a whole, and otherwise converted as an unquoted literal would be.
+func Condition(ref string, op Op, value string) (Expr, error) {
+	field, err := ParseField(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := false
+	if value != "" && (value[0] == '\'' || value[0] == '"') {
+		if text, n, err := lexString(value); err == nil && n == len(value) {
+			value, quoted = text, true
+		}
+	}
+
+	v, err := convert(field, value, quoted)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case OpEq, OpNe:
+	case OpLt, OpLte, OpGt, OpGte:
+		if _, ok := v.(bool); ok {
+			return nil, fmt.Errorf("operator %s is not supported on boolean values", op)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operator %s", op)
+	}
+
+	return Compare{Field: field, Op: op, Value: v}, nil
+}
+
+func (p *parser) resolveField(tok token) (Field, error) {
+	field, err := ParseField(tok.text)
+	if err != nil {
+		return Field{}, p.errorf(tok, "%s", err)
+	}
+	return field, nil
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT", "IN", "PREFIX", "EXISTS":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"action = created", "action = 'created'"},
+		{
+			"actor.type = user AND action IN (deleted, deactivated) AND context.location != 'US'",
+			"actor.type = 'user' AND action IN ('deleted', 'deactivated') AND context.location != 'US'",
+		},
+		{"action = a OR action = b AND actor.id = 1", "action = 'a' OR (action = 'b' AND actor.id = '1')"},
+		{"(action = a OR action = b) AND actor.id = 1", "(action = 'a' OR action = 'b') AND actor.id = '1'"},
+		{"NOT action = created", "NOT action = 'created'"},
+		{"action not in (a, b)", "NOT action IN ('a', 'b')"},
+		{"context.ip_address PREFIX '10.0.'", "context.ip_address PREFIX '10.0.'"},
+		{"entity.type EXISTS", "entity.type EXISTS"},
+		{"created_at >= 2022-08-16", "timestamp >= 2022-08-16T00:00:00Z"},
+		{`action = "it's"`, `action = 'it\'s'`},
+		{"entity.extension.item_id=f66020564728", "entity.extension.item_id = 'f66020564728'"},
+		{"extension.amount>100", "extension.amount > 100"},
+		{"extension.amount:string = 100", "extension.amount = '100'"},
+		{"extension.amount = '100'", "extension.amount = '100'"},
+		{"actor.extension.admin = true", "actor.extension.admin = true"},
+		{"context.extension.due:date < 2023-01-01", "context.extension.due < 2023-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if expr.String() != tt.expected {
+			t.Errorf("Parse(%q): expected %q, got %q", tt.input, tt.expected, expr.String())
+		}
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	expr, err := Parse("timestamp < 2022-08-16T12:34:56Z")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := time.Date(2022, 8, 16, 12, 34, 56, 0, time.UTC)
+	if value, ok := expr.(Compare).Value.(time.Time); !ok || !value.Equal(expected) {
+		t.Errorf("Expected '%v', got '%v'", expected, expr.(Compare).Value)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{"", 1},
+		{"action", 7},
+		{"action =", 9},
+		{"unknown = x", 1},
+		{"action = a AND", 15},
+		{"action = a b", 12},
+		{"(action = a", 12},
+		{"action IN a", 11},
+		{"action IN (a b)", 14},
+		{"action = 'open", 10},
+		{"action ! a", 8},
+		{"timestamp > yesterday", 13},
+		{"timestamp PREFIX 2022", 11},
+		{"action NOT = a", 12},
+		{"extension.$where = 1", 1},
+		{"action:string = a", 1},
+		{"extension.amount:money = 1", 1},
+		{"extension.amount:number = ten", 27},
+		{"extension.admin > true", 17},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+
+		var qerr *Error
+		if !errors.As(err, &qerr) {
+			t.Errorf("Parse(%q): expected *Error, got %v", tt.input, err)
+			continue
+		}
+		if qerr.Pos != tt.pos {
+			t.Errorf("Parse(%q): expected position %d, got %d (%v)", tt.input, tt.pos, qerr.Pos, qerr)
+		}
+	}
+}
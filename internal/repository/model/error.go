@@ -5,4 +5,7 @@ import "errors"
 var (
 	ErrRecordNotFound   = errors.New("record not found") // requested record is not found
 	ErrDuplicateService = errors.New("duplicate service")
+	ErrJobFinished      = errors.New("job finished") // the job can no longer be updated
+	ErrDuplicateView    = errors.New("duplicate view")
+	ErrDuplicateRule    = errors.New("duplicate alert rule")
 )
@@ -1,8 +1,14 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -16,6 +22,164 @@ type Log struct {
 	Entity    Entity                 `json:"entity"`
 	Context   Context                `json:"context"`
 	Extension map[string]interface{} `json:"extension,omitempty"`
+	// Changes lists the fields of the entity modified by the action.
+	Changes []Change `bson:"changes,omitempty" json:"changes,omitempty"`
+
+	// SearchTerms holds the string extension values, indexed for full-text
+	// search alongside Action and Entity.Type.
+	SearchTerms []string `bson:"search_terms,omitempty" json:"-"`
+	// ServiceID is the service that published the log. Logs recorded
+	// before it was tracked have none, and are visible to every service.
+	ServiceID ServiceID `bson:"service_id,omitempty" json:"-"`
+	// Integrity describes the message the log was recorded from.
+	Integrity *Integrity `bson:"integrity,omitempty" json:"-"`
+	// IP describes Context.IPAddr, as looked up when the log was stored.
+	IP *IPInfo `bson:"ip,omitempty" json:"ip,omitempty"`
+	// UserAgent describes the user agent the log was recorded from, as
+	// parsed when the log was stored.
+	UserAgent *UserAgent `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	// Findings lists what detectors noticed about the log once stored.
+	Findings []Finding `bson:"findings,omitempty" json:"findings,omitempty"`
+
+	// Score and Highlights are only set on full-text search results.
+	Score      float64  `bson:"score,omitempty" json:"score,omitempty"`
+	Highlights []string `bson:"-" json:"highlights,omitempty"`
+}
+
+// An IPInfo describes an IP address: where it is, the autonomous system
+// it belongs to, and the tags of the networks it is in. Fields that could
+// not be looked up are left empty.
+type IPInfo struct {
+	Country   string   `bson:"country,omitempty" json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	Region    string   `bson:"region,omitempty" json:"region,omitempty"`
+	City      string   `bson:"city,omitempty" json:"city,omitempty"`
+	Latitude  *float64 `bson:"latitude,omitempty" json:"latitude,omitempty"`
+	Longitude *float64 `bson:"longitude,omitempty" json:"longitude,omitempty"`
+	ASN       uint     `bson:"asn,omitempty" json:"asn,omitempty"`
+	ASOrg     string   `bson:"as_org,omitempty" json:"as_org,omitempty"`
+	Tags      []string `bson:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// Device classes of user agents.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceOther   = "other"
+)
+
+// A UserAgent describes the software an action was performed with.
+// Browser and OS are left empty when they could not be recognized.
+type UserAgent struct {
+	Browser        string `bson:"browser,omitempty" json:"browser,omitempty"`
+	BrowserVersion string `bson:"browser_version,omitempty" json:"browser_version,omitempty"`
+	OS             string `bson:"os,omitempty" json:"os,omitempty"`
+	OSVersion      string `bson:"os_version,omitempty" json:"os_version,omitempty"`
+	Device         string `bson:"device" json:"device"`
+	Bot            bool   `bson:"bot" json:"bot"`
+}
+
+// An Integrity holds the digest of the message a log was recorded from,
+// so publishers can check the log matches what they sent.
+type Integrity struct {
+	Algorithm  string    `bson:"algorithm" json:"algorithm"`
+	Digest     string    `bson:"digest" json:"digest"`
+	ReceivedAt time.Time `bson:"received_at" json:"received_at"`
+}
+
+// A Change describes the value of an entity field before and after an
+// action. Path is the dotted path of the field, e.g. "address.city". A
+// missing Before means the field was added, a missing After that it was
+// removed. A field that was, or became, null has the value Null instead.
+type Change struct {
+	Path   string      `bson:"path" json:"path"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// null is the type of Null.
+type null struct{}
+
+// Null is the value of a field set to null, as opposed to a missing one.
+var Null = null{}
+
+func (null) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func (null) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.Null, nil, nil
+}
+
+// IsZero keeps Null from being omitted as an empty value.
+func (null) IsZero() bool {
+	return false
+}
+
+// UnmarshalJSON decodes a change, setting the values given as null to
+// Null. Unknown fields are rejected, as they are in request bodies.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var doc map[string]json.RawMessage
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	*c = Change{}
+	for key, raw := range doc {
+		switch key {
+		case "path":
+			err = json.Unmarshal(raw, &c.Path)
+		case "before":
+			c.Before, err = unmarshalValue(raw)
+		case "after":
+			c.After, err = unmarshalValue(raw)
+		default:
+			err = fmt.Errorf("json: unknown field %q", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(raw json.RawMessage) (interface{}, error) {
+	if string(raw) == "null" {
+		return Null, nil
+	}
+	var value interface{}
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// UnmarshalBSON decodes a change through a map, so documents among its
+// values decode as maps rather than as ordered documents, as they would
+// within a struct. Values stored as null are set to Null.
+func (c *Change) UnmarshalBSON(data []byte) error {
+	var doc map[string]interface{}
+	err := bson.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	*c = Change{}
+	c.Path, _ = doc["path"].(string)
+	if value, ok := doc["before"]; ok {
+		c.Before = nullable(value)
+	}
+	if value, ok := doc["after"]; ok {
+		c.After = nullable(value)
+	}
+	return nil
+}
+
+func nullable(value interface{}) interface{} {
+	if value == nil {
+		return Null
+	}
+	return value
 }
 
 // An Actor defines the user or service responsible for
@@ -29,6 +193,7 @@ type Actor struct {
 // An Entity defines the resource that was impacted.
 type Entity struct {
 	Type      string                 `json:"type"`
+	ID        string                 `bson:"id,omitempty" json:"id,omitempty"`
 	Extension map[string]interface{} `json:"extension,omitempty"`
 }
 
@@ -40,6 +205,14 @@ type Context struct {
 	Extension map[string]interface{} `json:"extension,omitempty"`
 }
 
+// A HistoryEntry is one event in the history of an entity.
+type HistoryEntry struct {
+	ID        primitive.ObjectID `json:"id"`
+	Timestamp time.Time          `json:"timestamp"`
+	Action    string             `json:"action"`
+	Actor     Actor              `json:"actor"`
+}
+
 // A ServiceID defines the service name type.
 type ServiceID string
 
@@ -58,3 +231,373 @@ type Token struct {
 	Hash      []byte    `json:"-"`
 	ServiceID ServiceID `json:"-"`
 }
+
+// A JobStatus describes the stage a query job is at.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Finished reports whether a job with the status will make no further
+// progress.
+func (s JobStatus) Finished() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCancelled
+}
+
+// A QueryJob describes a query run in the background, whose results are
+// materialized for later retrieval.
+type QueryJob struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	ServiceID ServiceID          `bson:"service_id" json:"-"`
+	Filter    Params             `bson:"filter" json:"filter"`
+	Status    JobStatus          `bson:"status" json:"status"`
+	Matched   int                `bson:"matched" json:"matched"`
+	Processed int                `bson:"processed" json:"processed"`
+	Error     string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+
+	// Cursor holds the ID of the last log materialized, which is where
+	// the job resumes from after a restart.
+	Cursor primitive.ObjectID `bson:"cursor,omitempty" json:"-"`
+}
+
+// Progress returns the share of the matched logs processed so far, from
+// 0 to 1.
+func (j *QueryJob) Progress() float64 {
+	switch {
+	case j.Status == JobCompleted:
+		return 1
+	case j.Matched == 0:
+		return 0
+	case j.Processed >= j.Matched:
+		// Logs stored after the job started may match it too.
+		return 1
+	}
+	return float64(j.Processed) / float64(j.Matched)
+}
+
+// A View is a named query saved by a service. Its parameters are those of
+// 'GET /v1/logs?<query_string>'.
+type View struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Owner       ServiceID          `bson:"owner" json:"owner"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Params      Params             `bson:"params" json:"params"`
+	// Grants lists the services the view is shared with.
+	Grants    []ServiceID `bson:"grants" json:"grants"`
+	CreatedAt time.Time   `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time   `bson:"updated_at" json:"updated_at"`
+}
+
+// Params are query string parameters, keyed by name. Names such as
+// "extension.amount>" cannot be field names in storage, so params are
+// stored as a list of name and value pairs.
+type Params map[string]string
+
+type param struct {
+	Name  string `bson:"name"`
+	Value string `bson:"value"`
+}
+
+// MarshalBSONValue stores params as a list of pairs, sorted by name.
+func (p Params) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if p == nil {
+		return bson.MarshalValue(nil)
+	}
+
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]param, len(names))
+	for i, name := range names {
+		pairs[i] = param{Name: name, Value: p[name]}
+	}
+	return bson.MarshalValue(pairs)
+}
+
+// UnmarshalBSONValue reads params from a list of pairs.
+func (p *Params) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null {
+		*p = nil
+		return nil
+	}
+
+	var pairs []param
+	err := bson.RawValue{Type: t, Value: data}.Unmarshal(&pairs)
+	if err != nil {
+		return err
+	}
+
+	*p = make(Params, len(pairs))
+	for _, pair := range pairs {
+		(*p)[pair.Name] = pair.Value
+	}
+	return nil
+}
+
+// A Duration is a time.Duration written in JSON as a string such as "5m".
+type Duration time.Duration
+
+// MarshalJSON writes a duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return errors.New(`must be a duration such as "5m"`)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// An AlertRule raises alerts on the logs visible to its owner that match
+// its query. A rule with a threshold of 1 fires on every matching log.
+// Otherwise it fires once the given number of matching logs, grouped by
+// the values of its group-by fields, happened within its window.
+type AlertRule struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Owner       ServiceID          `bson:"owner" json:"-"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Severity    string             `bson:"severity" json:"severity"`
+	Query       string             `bson:"query" json:"query"`
+	GroupBy     []string           `bson:"group_by,omitempty" json:"group_by,omitempty"`
+	Threshold   int                `bson:"threshold" json:"threshold"`
+	Window      Duration           `bson:"window,omitempty" json:"window,omitempty"`
+	Enabled     bool               `bson:"enabled" json:"enabled"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// An AlertState holds the matching logs a windowed alert rule counted for
+// a group, so counting carries on after a restart.
+type AlertState struct {
+	RuleID primitive.ObjectID `bson:"rule_id"`
+	// Group holds the group-by values, as a JSON array.
+	Group string `bson:"group"`
+	// Events holds the times of the logs counted, oldest first.
+	Events    []time.Time `bson:"events"`
+	ExpiresAt time.Time   `bson:"expires_at"`
+}
+
+// An Alert is raised when an alert rule fires.
+type Alert struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	RuleID   primitive.ObjectID `bson:"rule_id,omitempty" json:"rule_id"`
+	Owner    ServiceID          `bson:"owner" json:"-"`
+	RuleName string             `bson:"rule_name" json:"rule_name"`
+	Severity string             `bson:"severity" json:"severity"`
+	// Group maps the group-by fields of the rule to their values.
+	Group map[string]interface{} `bson:"group,omitempty" json:"group,omitempty"`
+	Count int                    `bson:"count" json:"count"`
+	// FirstEvent and LastEvent are the times of the first and last logs
+	// counted, and LogID the ID of the log that made the rule fire.
+	FirstEvent time.Time          `bson:"first_event" json:"first_event"`
+	LastEvent  time.Time          `bson:"last_event" json:"last_event"`
+	LogID      primitive.ObjectID `bson:"log_id" json:"log_id"`
+	// Detail explains alerts raised by built-in detectors rather than by
+	// a rule, which have no RuleID.
+	Detail    string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// MarshalJSON encodes an alert, leaving out the ID of its rule when it was
+// raised by a built-in detector.
+func (a Alert) MarshalJSON() ([]byte, error) {
+	type alert Alert
+	var ruleID interface{}
+	if !a.RuleID.IsZero() {
+		ruleID = a.RuleID
+	}
+	return json.Marshal(struct {
+		alert
+		RuleID interface{} `json:"rule_id,omitempty"`
+	}{alert(a), ruleID})
+}
+
+// A Webhook subscribes a URL to events, notified with payloads signed by
+// its secret. Filter holds the parameters of 'GET /v1/logs?<query_string>'
+// the stored logs notified have to match.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Owner     ServiceID          `bson:"owner" json:"-"`
+	URL       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"-"`
+	Events    []string           `bson:"events" json:"events"`
+	Filter    Params             `bson:"filter,omitempty" json:"filter,omitempty"`
+	Enabled   bool               `bson:"enabled" json:"enabled"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// A Schema is a version of the JSON Schema the logs of a service with an
+// action, and optionally an entity type, have to match. The latest
+// version of a schema is the active one.
+type Schema struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Owner      ServiceID          `bson:"owner" json:"-"`
+	Action     string             `bson:"action" json:"action"`
+	EntityType string             `bson:"entity_type" json:"entity_type,omitempty"`
+	Version    int                `bson:"version" json:"version"`
+	// Mode overrides the mode logs are checked in, if set.
+	Mode      string          `bson:"mode,omitempty" json:"mode,omitempty"`
+	Document  json.RawMessage `bson:"document" json:"schema"`
+	Active    bool            `bson:"-" json:"active"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+}
+
+// A DeliveryStatus describes the stage a webhook delivery is at.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// A Delivery is a notification of an event to a webhook, along with the
+// attempts made to deliver it.
+type Delivery struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	WebhookID primitive.ObjectID `bson:"webhook_id" json:"webhook_id"`
+	Owner     ServiceID          `bson:"owner" json:"-"`
+	Event     string             `bson:"event" json:"event"`
+	// Payload holds the JSON document posted.
+	Payload  string            `bson:"payload" json:"payload"`
+	Status   DeliveryStatus    `bson:"status" json:"status"`
+	Attempts []DeliveryAttempt `bson:"attempts" json:"attempts"`
+	// Tries counts the attempts made since the delivery was last
+	// scheduled, which redelivering resets.
+	Tries       int       `bson:"tries" json:"tries"`
+	NextAttempt time.Time `bson:"next_attempt,omitempty" json:"next_attempt,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// A DeliveryAttempt is one attempt at delivering a notification.
+type DeliveryAttempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	// Duration is how long the attempt took, in milliseconds.
+	Duration int64 `bson:"duration" json:"duration_ms"`
+}
+
+// A Baseline describes the usual activity of an actor in the logs of a
+// service. Weights decay over time, so the baseline follows the recent
+// activity of the actor rather than all of it.
+type Baseline struct {
+	ServiceID ServiceID `bson:"service_id,omitempty" json:"-"`
+	ActorType string    `bson:"actor_type" json:"actor_type"`
+	ActorID   string    `bson:"actor_id" json:"actor_id"`
+	// Events counts the logs of the actor, without decay.
+	Events      int64       `bson:"events" json:"events"`
+	Actions     []Frequency `bson:"actions" json:"actions"`
+	EntityTypes []Frequency `bson:"entity_types" json:"entity_types"`
+	Locations   []Frequency `bson:"locations" json:"locations"`
+	IPRanges    []Frequency `bson:"ip_ranges" json:"ip_ranges"`
+	Rate        Rate        `bson:"rate" json:"rate"`
+	FirstSeen   time.Time   `bson:"first_seen" json:"first_seen"`
+	LastSeen    time.Time   `bson:"last_seen" json:"last_seen"`
+}
+
+// A Frequency is the decayed weight of a value among the logs of an actor.
+type Frequency struct {
+	Value  string  `bson:"v" json:"value"`
+	Weight float64 `bson:"w" json:"weight"`
+}
+
+// A Rate tracks the number of logs of an actor per hour, over the hours
+// the actor was active in.
+type Rate struct {
+	Hour  time.Time `bson:"hour" json:"hour"`
+	Count int64     `bson:"count" json:"count"`
+	// Flagged reports whether the count of the hour was already flagged.
+	Flagged bool `bson:"flagged,omitempty" json:"-"`
+	// Hours counts the past active hours Mean and Variance are moving
+	// averages over.
+	Hours    int64   `bson:"hours" json:"hours"`
+	Mean     float64 `bson:"mean" json:"mean"`
+	Variance float64 `bson:"variance" json:"variance"`
+}
+
+// An Anomaly is a log deviating from the baseline of its actor. Score
+// ranges from 0 to 1.
+type Anomaly struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	ServiceID   ServiceID          `bson:"service_id,omitempty" json:"-"`
+	ActorType   string             `bson:"actor_type" json:"actor_type"`
+	ActorID     string             `bson:"actor_id" json:"actor_id"`
+	LogID       primitive.ObjectID `bson:"log_id" json:"log_id"`
+	Score       float64            `bson:"score" json:"score"`
+	Reasons     []AnomalyReason    `bson:"reasons" json:"reasons"`
+	Explanation string             `bson:"explanation" json:"explanation"`
+	EventTime   time.Time          `bson:"event_time" json:"event_time"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// An AnomalyReason is one way a log deviates from the baseline of its
+// actor: an unusual action, entity type, location or IP range, or an
+// unusual rate of logs.
+type AnomalyReason struct {
+	Kind   string  `bson:"kind" json:"kind"`
+	Value  string  `bson:"value" json:"value"`
+	Score  float64 `bson:"score" json:"score"`
+	Detail string  `bson:"detail" json:"detail"`
+}
+
+// A Finding is something a detector noticed about a log, and the alert it
+// raised.
+type Finding struct {
+	Kind     string             `bson:"kind" json:"kind"`
+	Severity string             `bson:"severity" json:"severity"`
+	Detail   string             `bson:"detail" json:"detail"`
+	AlertID  primitive.ObjectID `bson:"alert_id" json:"alert_id"`
+}
+
+// A TravelHistory records where an actor of a service was last seen, and
+// the locations and networks it was seen from.
+type TravelHistory struct {
+	ServiceID ServiceID `bson:"service_id,omitempty" json:"-"`
+	ActorType string    `bson:"actor_type" json:"actor_type"`
+	ActorID   string    `bson:"actor_id" json:"actor_id"`
+	Last      *Sighting `bson:"last,omitempty" json:"last,omitempty"`
+	Locations []Seen    `bson:"locations" json:"locations"`
+	ASNs      []Seen    `bson:"asns" json:"asns"`
+}
+
+// A Sighting is where and when an actor was seen.
+type Sighting struct {
+	Time      time.Time `bson:"time" json:"time"`
+	IPAddr    string    `bson:"ip_address" json:"ip_address"`
+	Location  string    `bson:"location,omitempty" json:"location,omitempty"`
+	Latitude  float64   `bson:"latitude" json:"latitude"`
+	Longitude float64   `bson:"longitude" json:"longitude"`
+}
+
+// A Seen is a value an actor was seen with, and when it last was.
+type Seen struct {
+	Value    string    `bson:"v" json:"value"`
+	LastSeen time.Time `bson:"t" json:"last_seen"`
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParamsBSON(t *testing.T) {
+	job := QueryJob{Filter: Params{"extension.amount>": "100", "action": "paid"}}
+
+	// Test that params are stored as pairs, whatever their names
+	data, err := bson.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Filter []bson.M `bson:"filter"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bson.M{{"name": "action", "value": "paid"}, {"name": "extension.amount>", "value": "100"}}
+	if !reflect.DeepEqual(doc.Filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, doc.Filter)
+	}
+
+	var decoded QueryJob
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Filter, job.Filter) {
+		t.Errorf("Expected %v, got %v", job.Filter, decoded.Filter)
+	}
+}
+
+func TestQueryJobProgress(t *testing.T) {
+	tests := []struct {
+		job      QueryJob
+		expected float64
+	}{
+		{QueryJob{Status: JobRunning, Matched: 0}, 0},
+		{QueryJob{Status: JobRunning, Matched: 200, Processed: 50}, 0.25},
+		{QueryJob{Status: JobRunning, Matched: 200, Processed: 250}, 1},
+		{QueryJob{Status: JobCompleted, Matched: 200, Processed: 0}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.job.Progress(); got != tt.expected {
+			t.Errorf("Expected progress %v for %+v, got %v", tt.expected, tt.job, got)
+		}
+	}
+}
+
+// Test that changes to null are told from removals, in JSON and BSON
+func TestChangeNull(t *testing.T) {
+	var changes []Change
+	err := json.Unmarshal([]byte(`[{"path": "status", "before": "draft", "after": null}, {"path": "notes", "before": "x"}]`), &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{Path: "status", Before: "draft", After: Null}, {Path: "notes", Before: "x"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, changes)
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[{"path":"status","before":"draft","after":null},{"path":"notes","before":"x"}]` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	data, err = bson.Marshal(Log{Changes: changes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Log
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded.Changes)
+	}
+
+	// Test with an unknown field
+	if err := json.Unmarshal([]byte(`{"path": "status", "value": 1}`), &Change{}); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
@@ -0,0 +1,225 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	alertRulesCollection  = "alert_rules"
+	alertStatesCollection = "alert_states"
+	alertsCollection      = "alerts"
+)
+
+// AlertRepository defines a Mongodb-based repository of alert rules, the
+// state of their counts and the alerts they raised.
+type AlertRepository struct {
+	client *mongo.Client
+}
+
+// NewAlertRepository instantiates a new Mongodb-based alert repository.
+func NewAlertRepository(client *mongo.Client) *AlertRepository {
+	return &AlertRepository{client}
+}
+
+// EnsureIndexes creates the indexes the lookups of alert rules, states
+// and alerts rely on. Rule names are unique per owner, and states are
+// removed once their window has passed.
+func (r *AlertRepository) EnsureIndexes(ctx context.Context) error {
+	database := r.client.Database(db)
+
+	_, err := database.Collection(alertRulesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(alertStatesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "rule_id", Value: 1}, {Key: "group", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(alertsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}, {Key: "rule_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return err
+}
+
+// AddRule adds an alert rule to the alert rules collection.
+func (r *AlertRepository) AddRule(ctx context.Context, rule *model.AlertRule) error {
+	collection := r.client.Database(db).Collection(alertRulesCollection)
+
+	if rule.ID.IsZero() {
+		rule.ID = primitive.NewObjectID()
+	}
+
+	_, err := collection.InsertOne(ctx, rule)
+	if mongo.IsDuplicateKeyError(err) {
+		return model.ErrDuplicateRule
+	}
+	return err
+}
+
+// GetRule retrieves an alert rule of an owner by its ID.
+func (r *AlertRepository) GetRule(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) (*model.AlertRule, error) {
+	collection := r.client.Database(db).Collection(alertRulesCollection)
+
+	var rule model.AlertRule
+	err := collection.FindOne(ctx, bson.M{"_id": id, "owner": owner}).Decode(&rule)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rule, nil
+}
+
+// ListRules returns the alert rules of an owner, sorted by name.
+func (r *AlertRepository) ListRules(ctx context.Context, owner model.ServiceID) ([]*model.AlertRule, error) {
+	return r.findRules(ctx, bson.M{"owner": owner})
+}
+
+// AllRules returns the alert rules of every service.
+func (r *AlertRepository) AllRules(ctx context.Context) ([]*model.AlertRule, error) {
+	return r.findRules(ctx, bson.M{})
+}
+
+func (r *AlertRepository) findRules(ctx context.Context, filter bson.M) ([]*model.AlertRule, error) {
+	collection := r.client.Database(db).Collection(alertRulesCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*model.AlertRule{}
+	err = cursor.All(ctx, &rules)
+	return rules, err
+}
+
+// UpdateRule saves an alert rule, and discards the state of its counts,
+// which start over.
+func (r *AlertRepository) UpdateRule(ctx context.Context, rule *model.AlertRule) error {
+	collection := r.client.Database(db).Collection(alertRulesCollection)
+
+	rule.UpdatedAt = time.Now().UTC()
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": rule.ID, "owner": rule.Owner}, rule)
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		return model.ErrDuplicateRule
+	case err != nil:
+		return err
+	case result.MatchedCount == 0:
+		return model.ErrRecordNotFound
+	}
+
+	return r.deleteStates(ctx, rule.ID)
+}
+
+// DeleteRule deletes an alert rule of an owner and the state of its
+// counts. The alerts it raised are kept.
+func (r *AlertRepository) DeleteRule(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) error {
+	collection := r.client.Database(db).Collection(alertRulesCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "owner": owner})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return r.deleteStates(ctx, id)
+}
+
+func (r *AlertRepository) deleteStates(ctx context.Context, ruleID primitive.ObjectID) error {
+	collection := r.client.Database(db).Collection(alertStatesCollection)
+
+	_, err := collection.DeleteMany(ctx, bson.M{"rule_id": ruleID})
+	return err
+}
+
+// SaveState saves the state of the counts of a rule for a group.
+func (r *AlertRepository) SaveState(ctx context.Context, state *model.AlertState) error {
+	collection := r.client.Database(db).Collection(alertStatesCollection)
+
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"rule_id": state.RuleID, "group": state.Group},
+		bson.M{"$set": bson.M{"events": state.Events, "expires_at": state.ExpiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// States returns the saved states whose window has not passed.
+func (r *AlertRepository) States(ctx context.Context) ([]*model.AlertState, error) {
+	collection := r.client.Database(db).Collection(alertStatesCollection)
+
+	cursor, err := collection.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now().UTC()}})
+	if err != nil {
+		return nil, err
+	}
+
+	states := []*model.AlertState{}
+	err = cursor.All(ctx, &states)
+	return states, err
+}
+
+// AddAlert adds an alert to the alerts collection.
+func (r *AlertRepository) AddAlert(ctx context.Context, alert *model.Alert) error {
+	collection := r.client.Database(db).Collection(alertsCollection)
+
+	if alert.ID.IsZero() {
+		alert.ID = primitive.NewObjectID()
+	}
+
+	_, err := collection.InsertOne(ctx, alert)
+	return err
+}
+
+// ListAlerts returns the latest alerts raised for an owner, newest first,
+// optionally only those of a rule and those older than an alert ID.
+func (r *AlertRepository) ListAlerts(ctx context.Context, owner model.ServiceID, ruleID, before primitive.ObjectID, limit int) ([]*model.Alert, error) {
+	collection := r.client.Database(db).Collection(alertsCollection)
+
+	filter := bson.M{"owner": owner}
+	if !ruleID.IsZero() {
+		filter["rule_id"] = ruleID
+	}
+	if !before.IsZero() {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []*model.Alert{}
+	err = cursor.All(ctx, &alerts)
+	return alerts, err
+}
@@ -0,0 +1,143 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	baselinesCollection = "actor_baselines"
+	anomaliesCollection = "anomalies"
+)
+
+// AnomalyRepository defines a Mongodb-based repository of the baselines
+// of actors and the anomalies flagged against them.
+type AnomalyRepository struct {
+	client *mongo.Client
+}
+
+// NewAnomalyRepository instantiates a new Mongodb-based anomaly repository.
+func NewAnomalyRepository(client *mongo.Client) *AnomalyRepository {
+	return &AnomalyRepository{client}
+}
+
+// EnsureIndexes creates the indexes the lookups of baselines and anomalies
+// rely on. There is one baseline per actor of a service.
+func (r *AnomalyRepository) EnsureIndexes(ctx context.Context) error {
+	database := r.client.Database(db)
+
+	_, err := database.Collection(baselinesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "service_id", Value: 1}, {Key: "actor_type", Value: 1}, {Key: "actor_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(anomaliesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "service_id", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "actor_type", Value: 1}, {Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}},
+		},
+	})
+	return err
+}
+
+// actorDoc selects the document kept about an actor in the logs of a
+// service. Actors of logs without a service have documents of their own.
+func actorDoc(serviceID model.ServiceID, actorType, actorID string) bson.M {
+	key := bson.M{"service_id": serviceID, "actor_type": actorType, "actor_id": actorID}
+	if serviceID == "" {
+		key["service_id"] = nil
+	}
+	return key
+}
+
+// GetBaseline retrieves the baseline of an actor in the logs of a service.
+func (r *AnomalyRepository) GetBaseline(ctx context.Context, serviceID model.ServiceID, actorType, actorID string) (*model.Baseline, error) {
+	collection := r.client.Database(db).Collection(baselinesCollection)
+
+	var b model.Baseline
+	err := collection.FindOne(ctx, actorDoc(serviceID, actorType, actorID)).Decode(&b)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &b, nil
+}
+
+// SaveBaseline saves the baseline of an actor.
+func (r *AnomalyRepository) SaveBaseline(ctx context.Context, b *model.Baseline) error {
+	collection := r.client.Database(db).Collection(baselinesCollection)
+
+	_, err := collection.ReplaceOne(ctx,
+		actorDoc(b.ServiceID, b.ActorType, b.ActorID),
+		b,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// AddAnomaly adds an anomaly to the anomalies collection.
+func (r *AnomalyRepository) AddAnomaly(ctx context.Context, a *model.Anomaly) error {
+	collection := r.client.Database(db).Collection(anomaliesCollection)
+
+	if a.ID.IsZero() {
+		a.ID = primitive.NewObjectID()
+	}
+
+	_, err := collection.InsertOne(ctx, a)
+	return err
+}
+
+// An AnomalyFilter narrows down the anomalies listed.
+type AnomalyFilter struct {
+	ActorType string
+	ActorID   string
+	MinScore  float64
+	Before    primitive.ObjectID
+	Limit     int
+}
+
+// ListAnomalies returns the latest anomalies flagged in the logs visible
+// to a service, newest first.
+func (r *AnomalyRepository) ListAnomalies(ctx context.Context, serviceID model.ServiceID, f AnomalyFilter) ([]*model.Anomaly, error) {
+	collection := r.client.Database(db).Collection(anomaliesCollection)
+
+	filter := visibleTo(serviceID)
+	if f.ActorType != "" {
+		filter["actor_type"] = f.ActorType
+	}
+	if f.ActorID != "" {
+		filter["actor_id"] = f.ActorID
+	}
+	if f.MinScore > 0 {
+		filter["score"] = bson.M{"$gte": f.MinScore}
+	}
+	if !f.Before.IsZero() {
+		filter["_id"] = bson.M{"$lt": f.Before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(f.Limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies := []*model.Anomaly{}
+	err = cursor.All(ctx, &anomalies)
+	return anomalies, err
+}
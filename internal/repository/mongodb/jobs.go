@@ -0,0 +1,243 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobsCollection       = "query_jobs"
+	jobResultsCollection = "query_results"
+)
+
+// JobRepository defines a Mongodb-based query job repository.
+type JobRepository struct {
+	client *mongo.Client
+}
+
+// NewJobRepository instantiates a new Mongodb-based query job repository.
+func NewJobRepository(client *mongo.Client) *JobRepository {
+	return &JobRepository{client}
+}
+
+// A jobResult is a log materialized by a query job.
+type jobResult struct {
+	JobID     primitive.ObjectID `bson:"job_id"`
+	Log       *model.Log         `bson:"log"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// EnsureIndexes creates the indexes expiring jobs and their results, and
+// those the lookups rely on.
+func (r *JobRepository) EnsureIndexes(ctx context.Context) error {
+	database := r.client.Database(db)
+
+	_, err := database.Collection(jobsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The unique index makes storing a batch twice harmless, which
+	// happens when a job resumes after a restart.
+	_, err = database.Collection(jobResultsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "job_id", Value: 1}, {Key: "log._id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+// AddJob adds a query job to the jobs collection.
+func (r *JobRepository) AddJob(ctx context.Context, job *model.QueryJob) error {
+	collection := r.client.Database(db).Collection(jobsCollection)
+
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+
+	_, err := collection.InsertOne(ctx, job)
+	return err
+}
+
+// GetJob retrieves a query job submitted by a service.
+func (r *JobRepository) GetJob(ctx context.Context, id primitive.ObjectID, serviceID model.ServiceID) (*model.QueryJob, error) {
+	collection := r.client.Database(db).Collection(jobsCollection)
+
+	var job model.QueryJob
+	err := collection.FindOne(ctx, bson.M{"_id": id, "service_id": serviceID}).Decode(&job)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// UnfinishedJobs returns the queued and running jobs, oldest first.
+func (r *JobRepository) UnfinishedJobs(ctx context.Context) ([]*model.QueryJob, error) {
+	collection := r.client.Database(db).Collection(jobsCollection)
+
+	filter := bson.M{"status": bson.M{"$in": bson.A{model.JobQueued, model.JobRunning}}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*model.QueryJob
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
+
+// updateUnfinished applies an update to a job that is not finished yet.
+// It returns model.ErrJobFinished otherwise, e.g. once it was cancelled.
+func (r *JobRepository) updateUnfinished(ctx context.Context, id primitive.ObjectID, set bson.M, inc bson.M) error {
+	collection := r.client.Database(db).Collection(jobsCollection)
+
+	set["updated_at"] = time.Now().UTC()
+	update := bson.M{"$set": set}
+	if inc != nil {
+		update["$inc"] = inc
+	}
+
+	filter := bson.M{"_id": id, "status": bson.M{"$in": bson.A{model.JobQueued, model.JobRunning}}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return model.ErrJobFinished
+	}
+
+	return nil
+}
+
+// StartJob marks a job as running, recording the number of logs it
+// matches.
+func (r *JobRepository) StartJob(ctx context.Context, id primitive.ObjectID, matched int) error {
+	return r.updateUnfinished(ctx, id, bson.M{"status": model.JobRunning, "matched": matched}, nil)
+}
+
+// AddResults materializes a batch of logs for a job, and moves the job's
+// cursor past them.
+func (r *JobRepository) AddResults(ctx context.Context, job *model.QueryJob, logs []*model.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	collection := r.client.Database(db).Collection(jobResultsCollection)
+
+	docs := make([]interface{}, len(logs))
+	for i, log := range logs {
+		docs[i] = jobResult{JobID: job.ID, Log: log, ExpiresAt: job.ExpiresAt}
+	}
+
+	// Logs already stored before a restart are not counted again.
+	inserted := len(logs)
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		duplicates, ok := duplicateKeys(err)
+		if !ok {
+			return err
+		}
+		inserted -= duplicates
+	}
+
+	last := logs[len(logs)-1].ID
+	return r.updateUnfinished(ctx, job.ID, bson.M{"cursor": last}, bson.M{"processed": inserted})
+}
+
+// duplicateKeys returns the number of writes of a bulk insert that failed
+// because the document was already stored. It reports whether every write
+// that failed did so for that reason.
+func duplicateKeys(err error) (int, bool) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, false
+	}
+
+	for _, e := range bulkErr.WriteErrors {
+		if e.Code != 11000 {
+			return 0, false
+		}
+	}
+	return len(bulkErr.WriteErrors), true
+}
+
+// FinishJob marks a job as completed, or as failed when given an error.
+func (r *JobRepository) FinishJob(ctx context.Context, id primitive.ObjectID, jobErr error) error {
+	set := bson.M{"status": model.JobCompleted}
+	if jobErr != nil {
+		set = bson.M{"status": model.JobFailed, "error": jobErr.Error()}
+	}
+
+	return r.updateUnfinished(ctx, id, set, nil)
+}
+
+// CancelJob marks a job submitted by a service as cancelled, and returns
+// it. It returns model.ErrJobFinished when the job is already finished.
+func (r *JobRepository) CancelJob(ctx context.Context, id primitive.ObjectID, serviceID model.ServiceID) (*model.QueryJob, error) {
+	_, err := r.GetJob(ctx, id, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.updateUnfinished(ctx, id, bson.M{"status": model.JobCancelled}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetJob(ctx, id, serviceID)
+}
+
+// GetResults returns up to limit logs materialized by a job, starting
+// after the log with the given ID, in the order they were stored.
+func (r *JobRepository) GetResults(ctx context.Context, id primitive.ObjectID, after primitive.ObjectID, limit int) ([]*model.Log, error) {
+	collection := r.client.Database(db).Collection(jobResultsCollection)
+
+	filter := bson.M{"job_id": id}
+	if !after.IsZero() {
+		filter["log._id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().SetSort(bson.M{"log._id": 1}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []jobResult
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*model.Log, len(results))
+	for i, result := range results {
+		logs[i] = result.Log
+	}
+	return logs, nil
+}
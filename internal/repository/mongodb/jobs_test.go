@@ -0,0 +1,28 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDuplicateKeys(t *testing.T) {
+	// Test with writes failing on documents already stored
+	err := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 0, Code: 11000}},
+		{WriteError: mongo.WriteError{Index: 3, Code: 11000}},
+	}}
+	if n, ok := duplicateKeys(err); !ok || n != 2 {
+		t.Errorf("Expected 2 duplicates, got %d (%t)", n, ok)
+	}
+
+	// Test with writes failing for other reasons
+	err.WriteErrors = append(err.WriteErrors, mongo.BulkWriteError{WriteError: mongo.WriteError{Index: 4, Code: 121}})
+	if _, ok := duplicateKeys(err); ok {
+		t.Errorf("Expected failure on a write error other than a duplicate key")
+	}
+	if _, ok := duplicateKeys(errors.New("connection reset")); ok {
+		t.Errorf("Expected failure on an error other than a bulk write exception")
+	}
+}
@@ -2,13 +2,18 @@ package mongodb
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/query"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
 	"github.com/IkehAkinyemi/logaudit/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -26,6 +31,94 @@ func NewLogRepository(client *mongo.Client) *LogRepository {
 	return &LogRepository{client}
 }
 
+// extensionIndexPrefix prefixes the names of the indexes managed for
+// extension paths, so they can be told apart from other indexes.
+const extensionIndexPrefix = "ext_"
+
+// EnsureIndexes creates the indexes the queries rely on, along with an
+// index for each of the given extension paths. Extension indexes that are
+// no longer wanted are dropped.
+func (r *LogRepository) EnsureIndexes(ctx context.Context, extensionPaths []string) error {
+	indexes := r.client.Database(db).Collection(eventLogCollection).Indexes()
+
+	wanted := make(map[string]string, len(extensionPaths))
+	for _, path := range extensionPaths {
+		wanted[extensionIndexPrefix+path] = path
+	}
+
+	cursor, err := indexes.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var existing []struct {
+		Name string `bson:"name"`
+	}
+	err = cursor.All(ctx, &existing)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range existing {
+		if !strings.HasPrefix(index.Name, extensionIndexPrefix) {
+			continue
+		}
+		if _, ok := wanted[index.Name]; ok {
+			delete(wanted, index.Name)
+			continue
+		}
+		_, err := indexes.DropOne(ctx, index.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The text index backing full-text search. A collection can only
+	// have one.
+	models := []mongo.IndexModel{{
+		Keys: bson.D{
+			{Key: "action", Value: "text"},
+			{Key: "entity.type", Value: "text"},
+			{Key: "search_terms", Value: "text"},
+		},
+		Options: options.Index().SetName("log_search").SetWeights(bson.D{
+			{Key: "action", Value: 3},
+			{Key: "entity.type", Value: 2},
+			{Key: "search_terms", Value: 1},
+		}),
+	}, {
+		// The indexes backing the lookup of related logs.
+		Keys: bson.D{{Key: "actor.type", Value: 1}, {Key: "actor.id", Value: 1}, {Key: "timestamp", Value: 1}},
+	}, {
+		Keys: bson.D{{Key: "entity.type", Value: 1}, {Key: "entity.id", Value: 1}, {Key: "timestamp", Value: 1}},
+	}, {
+		// The indexes backing queries on where logs came from.
+		Keys:    bson.D{{Key: "ip.country", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}, {
+		Keys:    bson.D{{Key: "ip.asn", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}, {
+		Keys:    bson.D{{Key: "ip.tags", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}, {
+		// The indexes backing queries on what logs were recorded with.
+		Keys:    bson.D{{Key: "user_agent.device", Value: 1}, {Key: "user_agent.browser", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}, {
+		Keys:    bson.D{{Key: "user_agent.os", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}}
+	for name, path := range wanted {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: path, Value: 1}},
+			Options: options.Index().SetName(name).SetSparse(true),
+		})
+	}
+	_, err = indexes.CreateMany(ctx, models)
+	return err
+}
+
 // AddLog adds a log record to the logs collection.
 func (r *LogRepository) AddLog(log *model.Log) (interface{}, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -36,19 +129,383 @@ func (r *LogRepository) AddLog(log *model.Log) (interface{}, error) {
 	if log.ID == primitive.NilObjectID {
 		log.ID = primitive.NewObjectID()
 	}
+	log.SearchTerms = utils.SearchTerms(log)
 
 	return collection.InsertOne(ctx, log)
 }
 
+// backfillBatch is the number of logs BackfillSearchTerms updates at once.
+const backfillBatch = 500
+
+// BackfillSearchTerms sets the search terms of the logs stored before they
+// were, so that full-text search finds them by their extensions too. It
+// returns the number of logs updated.
+func (r *LogRepository) BackfillSearchTerms(ctx context.Context) (int, error) {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	filter := bson.M{"search_terms": bson.M{"$exists": false}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(backfillBatch)
+
+	updated := 0
+	for {
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			return updated, err
+		}
+		var logs []*model.Log
+		err = cursor.All(ctx, &logs)
+		if err != nil {
+			return updated, err
+		}
+		if len(logs) == 0 {
+			return updated, nil
+		}
+
+		models := make([]mongo.WriteModel, len(logs))
+		for i, log := range logs {
+			models[i] = searchTermsUpdate(log)
+		}
+		_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return updated, err
+		}
+		updated += len(logs)
+	}
+}
+
+// searchTermsUpdate sets the search terms of a stored log, to an empty list
+// when it has none so that it is not backfilled again.
+func searchTermsUpdate(log *model.Log) mongo.WriteModel {
+	terms := utils.SearchTerms(log)
+	if terms == nil {
+		terms = []string{}
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": log.ID}).
+		SetUpdate(bson.M{"$set": bson.M{"search_terms": terms}})
+}
+
+// AttachFindings adds findings to a stored log.
+func (r *LogRepository) AttachFindings(ctx context.Context, id primitive.ObjectID, findings []model.Finding) error {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$push": bson.M{"findings": bson.M{"$each": findings}}},
+	)
+	return err
+}
+
+// visibleTo selects the logs a service may read: its own, and those
+// recorded before logs were tied to a service.
+func visibleTo(serviceID model.ServiceID) bson.M {
+	return bson.M{"service_id": bson.M{"$in": bson.A{serviceID, nil}}}
+}
+
+// GetLog retrieves a log by its ID, provided the service may read it.
+func (r *LogRepository) GetLog(ctx context.Context, id primitive.ObjectID, serviceID model.ServiceID) (*model.Log, error) {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	filter := visibleTo(serviceID)
+	filter["_id"] = id
+
+	var log model.Log
+	err := collection.FindOne(ctx, filter).Decode(&log)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &log, nil
+}
+
+// RelatedLogs returns the logs recorded within window either side of a
+// log, by the same actor and on the same entity respectively. Entities are
+// matched on their type alone when the log does not identify them. At
+// most limit logs closest in time are returned for each, in
+// chronological order.
+func (r *LogRepository) RelatedLogs(ctx context.Context, log *model.Log, serviceID model.ServiceID, window time.Duration, limit int) (actor, entity []*model.Log, err error) {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	find := func(match bson.M) ([]*model.Log, error) {
+		filter := visibleTo(serviceID)
+		for key, value := range match {
+			filter[key] = value
+		}
+		filter["_id"] = bson.M{"$ne": log.ID}
+		filter["timestamp"] = bson.M{
+			"$gte": log.Timestamp.Add(-window),
+			"$lte": log.Timestamp.Add(window),
+		}
+
+		// Rank by distance in time, so a busy neighbourhood yields the
+		// events closest to the log rather than the earliest ones.
+		pipeline := []bson.M{
+			{"$match": filter},
+			{"$addFields": bson.M{"distance": bson.M{"$abs": bson.M{"$subtract": bson.A{"$timestamp", log.Timestamp}}}}},
+			{"$sort": bson.D{{Key: "distance", Value: 1}, {Key: "_id", Value: 1}}},
+			{"$limit": limit},
+			{"$sort": bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}},
+			{"$project": bson.M{"distance": 0}},
+		}
+
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		logs := []*model.Log{}
+		err = cursor.All(ctx, &logs)
+		return logs, err
+	}
+
+	actor, err = find(bson.M{"actor.type": log.Actor.Type, "actor.id": log.Actor.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	match := bson.M{"entity.type": log.Entity.Type}
+	if log.Entity.ID != "" {
+		match["entity.id"] = log.Entity.ID
+	}
+	entity, err = find(match)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return actor, entity, nil
+}
+
 // GetAggregatedLogs returns all the log records that's matched
 // by the query_string.
 func (r *LogRepository) GetAllLogs(ctx context.Context, filter utils.Filters) ([]*model.Log, utils.Metadata, error) {
 	collection := r.client.Database(db).Collection(eventLogCollection)
 
 	// Set up the pipeline to perform the filtering and pagination.
+	pipeline := filterStages(filter)
+
+	// Resume right after the record the cursor points at.
+	if filter.Cursor != nil {
+		pipeline = append(pipeline, bson.M{"$match": keysetMatch(filter.Sort, filter.Cursor)})
+	}
+
+	// Sort the results by the specified fields, breaking ties on _id so
+	// the order is total and pages never overlap.
+	pipeline = append(pipeline, bson.M{"$sort": sortKeys(filter.Sort)})
+
+	// Paginate the results. One extra record is fetched to tell whether
+	// there is a next page.
+	if filter.PageSize > 0 {
+		if filter.Cursor == nil {
+			pipeline = append(pipeline, bson.M{"$skip": filter.PageSize * (filter.Page - 1)})
+		}
+		pipeline = append(pipeline, bson.M{"$limit": filter.PageSize + 1})
+	}
+
+	// Only return the requested fields, along with those the cursor and
+	// the search highlights are made from.
+	if len(filter.Fields) > 0 {
+		pipeline = append(pipeline, bson.M{"$project": projection(filter)})
+	}
+
+	// Execute the pipeline and retrieve the results.
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, utils.Metadata{}, err
+	}
+
+	var logs []*model.Log
+	err = cursor.All(ctx, &logs)
+	if err != nil {
+		return nil, utils.Metadata{}, err
+	}
+
+	var nextCursor string
+	if filter.PageSize > 0 && len(logs) > filter.PageSize {
+		logs = logs[:filter.PageSize]
+		last := logs[len(logs)-1]
+
+		c := utils.Cursor{Sort: filter.Sort, ID: last.ID, Start: filter.StartTimestamp, End: filter.EndTimestamp}
+		for _, key := range filter.Sort {
+			c.Values = append(c.Values, sortValue(last, key.Field))
+		}
+		nextCursor, err = utils.EncodeCursor(c)
+		if err != nil {
+			return nil, utils.Metadata{}, err
+		}
+	}
+
+	// Retrieve the total number of documents that match the filter
+	// criteria, and the value counts asked for. They run apart from the
+	// page, whose sort can then be served by an index, which is not the
+	// case within a $facet stage.
+	count, err := r.CountLogs(ctx, filter)
+	if err != nil {
+		return nil, utils.Metadata{}, err
+	}
+
+	facets, err := r.facetCounts(ctx, filter)
+	if err != nil {
+		return nil, utils.Metadata{}, err
+	}
+
+	// Return the results along with metadata about the pagination.
+	metadata := utils.Metadata{
+		TotalRecords: count,
+		PageSize:     filter.PageSize,
+		NextCursor:   nextCursor,
+		Facets:       facets,
+	}
+	if filter.Cursor == nil {
+		metadata.CurrentPage = filter.Page
+	}
+	return logs, metadata, nil
+}
+
+// maxFacetValues bounds the values counted per facet.
+const maxFacetValues = 20
+
+// facetCounts counts the most frequent values of the requested facet
+// fields among the logs matched by the filter criteria, in a single pass.
+func (r *LogRepository) facetCounts(ctx context.Context, filter utils.Filters) (map[string][]utils.FacetCount, error) {
+	if len(filter.Facets) == 0 {
+		return nil, nil
+	}
+
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	stage, names := facetStage(filter.Facets)
+	pipeline := append(filterStages(filter), stage)
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string][]struct {
+		Value interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}
+	err = cursor.All(ctx, &rows)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	counts := make(map[string][]utils.FacetCount, len(rows[0]))
+	for output, values := range rows[0] {
+		name := names[output]
+		counts[name] = make([]utils.FacetCount, len(values))
+		for i, v := range values {
+			counts[name][i] = utils.FacetCount{Value: v.Value, Count: v.Count}
+		}
+	}
+	return counts, nil
+}
+
+// facetStage returns the $facet stage counting the values of the facet
+// fields, along with the field each of its outputs counts. Outputs are
+// numbered, as their names may not hold the dots of field names.
+func facetStage(fields []string) (bson.M, map[string]string) {
+	facets := bson.M{}
+	names := make(map[string]string, len(fields))
+	for i, name := range fields {
+		field, ok := query.LookupField(name)
+		if !ok {
+			continue
+		}
+		output := fmt.Sprintf("f%d", i)
+		names[output] = name
+		facets[output] = bson.A{
+			bson.M{"$group": bson.M{"_id": "$" + field.Path, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": maxFacetValues},
+		}
+	}
+	return bson.M{"$facet": facets}, names
+}
+
+// CountLogs returns the number of logs matched by the filter criteria.
+func (r *LogRepository) CountLogs(ctx context.Context, filter utils.Filters) (int, error) {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	pipeline := append(filterStages(filter), bson.M{"$count": "count"})
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows []struct {
+		Count int `bson:"count"`
+	}
+	err = cursor.All(ctx, &rows)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	return rows[0].Count, nil
+}
+
+// StreamLogs calls fn with each log matched by the filter criteria, in
+// the order of the sort fields or else in insertion order, decoding one
+// record at a time so the whole result set never has to fit in memory.
+// Streaming stops at the first error returned by fn. A cursor resumes
+// right after the record it points at.
+func (r *LogRepository) StreamLogs(ctx context.Context, filter utils.Filters, fn func(*model.Log) error) error {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	pipeline := filterStages(filter)
+	if filter.Cursor != nil {
+		pipeline = append(pipeline, bson.M{"$match": keysetMatch(filter.Sort, filter.Cursor)})
+	}
+	pipeline = append(pipeline, bson.M{"$sort": sortKeys(filter.Sort)})
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var log model.Log
+		err := cursor.Decode(&log)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&log)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// filterStages returns the pipeline stages selecting the logs matched by
+// the filter criteria. It is shared by every query over the logs.
+func filterStages(filter utils.Filters) []bson.M {
 	pipeline := []bson.M{}
 
+	// A text search has to come first, and is what makes the relevance
+	// score available to later stages.
+	if filter.Search != "" {
+		pipeline = append(pipeline,
+			bson.M{"$match": bson.M{"$text": bson.M{"$search": filter.Search}}},
+			bson.M{"$addFields": bson.M{"score": bson.M{"$meta": "textScore"}}},
+		)
+	}
+
 	// Filter the results by the specified criteria.
+	if filter.ServiceID != "" {
+		pipeline = append(pipeline, bson.M{"$match": visibleTo(filter.ServiceID)})
+	}
 	if filter.Action != "" {
 		pipeline = append(pipeline, bson.M{"$match": bson.M{"action": filter.Action}})
 	}
@@ -61,51 +518,135 @@ func (r *LogRepository) GetAllLogs(ctx context.Context, filter utils.Filters) ([
 	if filter.EntityType != "" {
 		pipeline = append(pipeline, bson.M{"$match": bson.M{"entity.type": filter.EntityType}})
 	}
+	if filter.EntityID != "" {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"entity.id": filter.EntityID}})
+	}
 	if !filter.StartTimestamp.IsZero() {
 		pipeline = append(pipeline, bson.M{"$match": bson.M{"timestamp": bson.M{"$gte": filter.StartTimestamp}}})
 	}
 	if !filter.EndTimestamp.IsZero() {
 		pipeline = append(pipeline, bson.M{"$match": bson.M{"timestamp": bson.M{"$lte": filter.EndTimestamp}}})
 	}
+	if filter.Query != nil {
+		pipeline = append(pipeline, bson.M{"$match": compileQuery(filter.Query)})
+	}
+
+	return pipeline
+}
+
+// sortKeys returns the sort specification of a sort, which breaks ties on
+// _id in the direction of the first key.
+func sortKeys(keys []utils.SortKey) bson.D {
+	sort := bson.D{}
+	for _, key := range keys {
+		sort = append(sort, bson.E{Key: key.Field, Value: direction(key)})
+	}
+
+	idDirection := 1
+	if len(keys) > 0 {
+		idDirection = direction(keys[0])
+	}
+	return append(sort, bson.E{Key: "_id", Value: idDirection})
+}
+
+func direction(key utils.SortKey) int {
+	if key.Descending {
+		return -1
+	}
+	return 1
+}
+
+// keysetMatch selects the records that sort after the cursor position:
+// those after it on the first sort key, or equal on it and after it on
+// the next one, and so on, down to the _id tiebreak.
+func keysetMatch(keys []utils.SortKey, c *utils.Cursor) bson.M {
+	op := "$gt"
+	if len(keys) > 0 && keys[0].Descending {
+		op = "$lt"
+	}
+
+	if len(keys) == 0 {
+		return bson.M{"_id": bson.M{op: c.ID}}
+	}
 
-	// Sort the results by the specified field.
-	if filter.SortField != "" {
-		sortDirection := 1
-		if filter.SortDescending {
-			sortDirection = -1
+	var (
+		branches bson.A
+		equal    bson.A
+	)
+	for i, key := range keys {
+		if after := afterValue(key, c.Values[i]); after != nil {
+			branches = append(branches, bson.M{"$and": append(equal[:len(equal):len(equal)], after)})
 		}
-		pipeline = append(pipeline, bson.M{"$sort": bson.M{filter.SortField: sortDirection}})
+		equal = append(equal, bson.M{key.Field: c.Values[i]})
 	}
+	branches = append(branches, bson.M{"$and": append(equal, bson.M{"_id": bson.M{op: c.ID}})})
 
-	// Paginate the results.
-	if filter.PageSize > 0 {
-		pipeline = append(pipeline, bson.M{"$skip": filter.PageSize * (filter.Page - 1)})
-		pipeline = append(pipeline, bson.M{"$limit": filter.PageSize})
+	return bson.M{"$or": branches}
+}
+
+// afterValue selects the records that sort after a value on a sort key,
+// or returns nil when none can. Records missing the sort field sort
+// before every other value, so they need explicit handling as comparison
+// operators never match them.
+func afterValue(key utils.SortKey, value interface{}) bson.M {
+	switch {
+	case value == nil && !key.Descending:
+		return bson.M{key.Field: bson.M{"$ne": nil}}
+	case value == nil:
+		return nil
+	case !key.Descending:
+		return bson.M{key.Field: bson.M{"$gt": value}}
+	default:
+		return bson.M{"$or": bson.A{bson.M{key.Field: bson.M{"$lt": value}}, bson.M{key.Field: nil}}}
 	}
+}
 
-	// Execute the pipeline and retrieve the results.
-	cursor, err := collection.Aggregate(ctx, pipeline)
-	if err != nil {
-		return nil, utils.Metadata{}, err
+// projection returns the fields of logs to return for the filter
+// criteria: the requested ones, the sort fields, and, with a search, the
+// relevance score and the fields highlights are made from. Paths lying
+// within another are dropped, as MongoDB rejects them.
+func projection(filter utils.Filters) bson.M {
+	paths := utils.ProjectionPaths(filter.Fields)
+	for _, key := range filter.Sort {
+		paths = append(paths, key.Field)
+	}
+	if filter.Search != "" {
+		paths = append(paths, "score", "action", "entity.type", "extension", "actor.extension", "entity.extension", "context.extension")
 	}
 
-	var logs []*model.Log
-	err = cursor.All(ctx, &logs)
+	project := bson.M{}
+	for _, path := range paths {
+		project[path] = 1
+	}
+	for path := range project {
+		for other := range project {
+			if strings.HasPrefix(path, other+".") {
+				delete(project, path)
+				break
+			}
+		}
+	}
+
+	return project
+}
+
+// sortValue extracts the value of a dotted field path from a log as it
+// is stored, so it compares the same way inside the database.
+func sortValue(log *model.Log, field string) interface{} {
+	doc, err := bson.Marshal(log)
 	if err != nil {
-		return nil, utils.Metadata{}, err
+		return nil
 	}
 
-	// Retrieve the total number of documents that match the filter criteria.
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	raw, err := bson.Raw(doc).LookupErr(strings.Split(field, ".")...)
 	if err != nil {
-		return nil, utils.Metadata{}, err
+		return nil
 	}
 
-	// Return the results along with metadata about the pagination.
-	metadata := utils.Metadata{
-		TotalRecords: int(count),
-		CurrentPage:  filter.Page,
-		PageSize:     filter.PageSize,
+	var value interface{}
+	if err := raw.Unmarshal(&value); err != nil {
+		return nil
 	}
-	return logs, metadata, nil
+
+	return value
 }
@@ -0,0 +1,126 @@
+package mongodb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFacetStage(t *testing.T) {
+	fields := []string{"action", "actor.type", "ip.country", "user_agent.device"}
+	stage, names := facetStage(fields)
+
+	facets, ok := stage["$facet"].(bson.M)
+	if !ok {
+		t.Fatalf("Expected a $facet stage, got %v", stage)
+	}
+	if len(facets) != len(fields) {
+		t.Fatalf("Expected %d outputs, got %d", len(fields), len(facets))
+	}
+
+	// Test that outputs are named without dots, and map back to fields
+	counted := make(map[string]bool)
+	for output, pipeline := range facets {
+		if strings.ContainsAny(output, ".$") {
+			t.Errorf("Expected output name without '.' or '$', got %q", output)
+		}
+		name, ok := names[output]
+		if !ok {
+			t.Errorf("Expected output %q to map to a field", output)
+			continue
+		}
+		counted[name] = true
+
+		group := pipeline.(bson.A)[0].(bson.M)["$group"].(bson.M)
+		if group["_id"] != "$"+name {
+			t.Errorf("Expected %s to be grouped by $%s, got %v", output, name, group["_id"])
+		}
+	}
+	for _, name := range fields {
+		if !counted[name] {
+			t.Errorf("Expected %s to be counted", name)
+		}
+	}
+
+	// Test that unknown fields are left out
+	stage, names = facetStage([]string{"nope"})
+	if len(stage["$facet"].(bson.M)) != 0 || len(names) != 0 {
+		t.Errorf("Expected no outputs, got %v", stage)
+	}
+}
+
+func TestFilterStages(t *testing.T) {
+	// Test that the logs are restricted to those visible to the service
+	stages := filterStages(utils.Filters{ServiceID: "billing", Action: "paid"})
+	var scoped bool
+	for _, stage := range stages {
+		match, ok := stage["$match"].(bson.M)
+		if !ok {
+			continue
+		}
+		if in, ok := match["service_id"].(bson.M); ok {
+			scoped = reflect.DeepEqual(in["$in"], bson.A{model.ServiceID("billing"), nil})
+		}
+	}
+	if !scoped {
+		t.Errorf("Expected a match on the visible service IDs, got %v", stages)
+	}
+
+	// Test that a search comes first
+	stages = filterStages(utils.Filters{ServiceID: "billing", Search: "refund"})
+	if _, ok := stages[0]["$match"].(bson.M)["$text"]; !ok {
+		t.Errorf("Expected the text search first, got %v", stages)
+	}
+}
+
+// Test that backfilled logs get their search terms, or an empty list
+func TestSearchTermsUpdate(t *testing.T) {
+	tests := []struct {
+		log      *model.Log
+		expected []string
+	}{
+		{&model.Log{ID: primitive.NewObjectID(), Extension: map[string]interface{}{"note": "refund", "count": 2.0}}, []string{"refund"}},
+		{&model.Log{ID: primitive.NewObjectID(), Action: "created"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		update, ok := searchTermsUpdate(tt.log).(*mongo.UpdateOneModel)
+		if !ok {
+			t.Fatalf("Expected an update of one log, got %T", update)
+		}
+		if filter := update.Filter.(bson.M); filter["_id"] != tt.log.ID {
+			t.Errorf("Expected update of log %s, got filter %v", tt.log.ID.Hex(), filter)
+		}
+		terms := update.Update.(bson.M)["$set"].(bson.M)["search_terms"]
+		if !reflect.DeepEqual(terms, tt.expected) {
+			t.Errorf("Expected search terms %#v, got %#v", tt.expected, terms)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	// Test that the relevance score is kept with a search
+	filter := utils.Filters{Fields: []string{"actor.id"}, Search: "refund"}
+	project := projection(filter)
+	for _, path := range []string{"score", "actor.id", "action", "extension"} {
+		if project[path] != 1 {
+			t.Errorf("Expected %s to be projected, got %v", path, project)
+		}
+	}
+
+	// Test that nested paths are dropped, and no score without a search
+	filter = utils.Filters{Fields: []string{"actor", "actor.id"}}
+	project = projection(filter)
+	if _, ok := project["actor.id"]; ok {
+		t.Errorf("Expected nested path to be dropped, got %v", project)
+	}
+	if _, ok := project["score"]; ok {
+		t.Errorf("Expected no score without a search, got %v", project)
+	}
+}
@@ -0,0 +1,97 @@
+package mongodb
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongoOps maps comparison operators to their query operator.
+var mongoOps = map[query.Op]string{
+	query.OpEq:  "$eq",
+	query.OpNe:  "$ne",
+	query.OpLt:  "$lt",
+	query.OpLte: "$lte",
+	query.OpGt:  "$gt",
+	query.OpGte: "$gte",
+}
+
+// compileQuery translates a filter expression into a $match document.
+func compileQuery(e query.Expr) bson.M {
+	switch e := e.(type) {
+	case query.And:
+		return bson.M{"$and": compileQueries(e.Exprs)}
+	case query.Or:
+		return bson.M{"$or": compileQueries(e.Exprs)}
+	case query.Not:
+		return bson.M{"$nor": bson.A{compileQuery(e.Expr)}}
+	case query.Compare:
+		if t, ok := e.Value.(time.Time); ok && e.Field.Extension {
+			return compileDateCompare(e.Field.Path, mongoOps[e.Op], t)
+		}
+		return bson.M{e.Field.Path: bson.M{mongoOps[e.Op]: e.Value}}
+	case query.In:
+		if e.Field.Extension {
+			return compileExtensionIn(e)
+		}
+		return bson.M{e.Field.Path: bson.M{"$in": e.Values}}
+	case query.Prefix:
+		return bson.M{e.Field.Path: bson.M{"$regex": "^" + regexp.QuoteMeta(e.Prefix)}}
+	case query.Exists:
+		return bson.M{e.Field.Path: bson.M{"$exists": true}}
+	default:
+		return bson.M{}
+	}
+}
+
+func compileQueries(exprs []query.Expr) bson.A {
+	compiled := make(bson.A, len(exprs))
+	for i, e := range exprs {
+		compiled[i] = compileQuery(e)
+	}
+	return compiled
+}
+
+// compileDateCompare compares an extension value against a date. The
+// extension maps are decoded from JSON, so dates are stored as strings
+// and have to be converted before they can be compared.
+func compileDateCompare(path, op string, t time.Time) bson.M {
+	converted := bson.M{"$convert": bson.M{
+		"input":   "$" + path,
+		"to":      "date",
+		"onError": nil,
+		"onNull":  nil,
+	}}
+
+	cond := bson.M{op: bson.A{converted, t}}
+	if op != "$ne" {
+		// null sorts before any date, so unconvertible values have to be
+		// excluded explicitly.
+		cond = bson.M{"$and": bson.A{bson.M{"$ne": bson.A{converted, nil}}, cond}}
+	}
+
+	return bson.M{"$expr": cond}
+}
+
+// compileExtensionIn splits the values of an IN on an extension field so
+// that dates among them are compared as dates.
+func compileExtensionIn(e query.In) bson.M {
+	var values bson.A
+	var alternatives bson.A
+
+	for _, value := range e.Values {
+		if t, ok := value.(time.Time); ok {
+			alternatives = append(alternatives, compileDateCompare(e.Field.Path, "$eq", t))
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) > 0 {
+		alternatives = append(alternatives, bson.M{e.Field.Path: bson.M{"$in": values}})
+	}
+
+	return bson.M{"$or": alternatives}
+}
@@ -0,0 +1,221 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	schemasCollection        = "schemas"
+	schemaVersionsCollection = "schema_versions"
+)
+
+// SchemaRepository defines a Mongodb-based repository of the versions of
+// the schemas of logs.
+type SchemaRepository struct {
+	client *mongo.Client
+}
+
+// NewSchemaRepository instantiates a new Mongodb-based schema repository.
+func NewSchemaRepository(client *mongo.Client) *SchemaRepository {
+	return &SchemaRepository{client}
+}
+
+// EnsureIndexes creates the index numbering the versions of each schema.
+func (r *SchemaRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "owner", Value: 1},
+			{Key: "action", Value: 1},
+			{Key: "entity_type", Value: 1},
+			{Key: "version", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// AddSchema adds a schema as the next version of the schema of its owner,
+// action and entity type. Versions are numbered by a counter per schema,
+// so the number of a deleted version is never given again.
+func (r *SchemaRepository) AddSchema(ctx context.Context, schema *model.Schema) error {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	if schema.ID.IsZero() {
+		schema.ID = primitive.NewObjectID()
+	}
+
+	// Schemas added before versions were counted may be ahead of their
+	// counter, which is then moved past them.
+	var err error
+	for i := 0; i < 3; i++ {
+		schema.Version, err = r.nextVersion(ctx, schema)
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.InsertOne(ctx, schema)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		var latest *model.Schema
+		latest, err = r.LatestSchema(ctx, schema.Owner, schema.Action, schema.EntityType)
+		if err != nil {
+			return err
+		}
+		err = r.raiseVersion(ctx, schema, latest.Version)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// versionCounter identifies the version counter of a schema.
+func versionCounter(schema *model.Schema) bson.M {
+	return bson.M{"_id": bson.D{
+		{Key: "owner", Value: schema.Owner},
+		{Key: "action", Value: schema.Action},
+		{Key: "entity_type", Value: schema.EntityType},
+	}}
+}
+
+// nextVersion increments the version counter of a schema and returns it.
+func (r *SchemaRepository) nextVersion(ctx context.Context, schema *model.Schema) (int, error) {
+	collection := r.client.Database(db).Collection(schemaVersionsCollection)
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Version int `bson:"version"`
+	}
+	err := collection.FindOneAndUpdate(ctx, versionCounter(schema), bson.M{"$inc": bson.M{"version": 1}}, opts).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another version was counted first, creating the counter.
+		err = collection.FindOneAndUpdate(ctx, versionCounter(schema), bson.M{"$inc": bson.M{"version": 1}}, opts).Decode(&counter)
+	}
+	return counter.Version, err
+}
+
+// raiseVersion moves the version counter of a schema up to a version, if
+// it is behind.
+func (r *SchemaRepository) raiseVersion(ctx context.Context, schema *model.Schema, version int) error {
+	collection := r.client.Database(db).Collection(schemaVersionsCollection)
+
+	_, err := collection.UpdateOne(ctx, versionCounter(schema), bson.M{"$max": bson.M{"version": version}})
+	return err
+}
+
+// LatestSchema retrieves the latest version of the schema of an owner,
+// action and entity type.
+func (r *SchemaRepository) LatestSchema(ctx context.Context, owner model.ServiceID, action, entityType string) (*model.Schema, error) {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	filter := bson.M{"owner": owner, "action": action, "entity_type": entityType}
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	var schema model.Schema
+	err := collection.FindOne(ctx, filter, opts).Decode(&schema)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schema, nil
+}
+
+// GetSchema retrieves a version of a schema of an owner by its ID.
+func (r *SchemaRepository) GetSchema(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) (*model.Schema, error) {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	var schema model.Schema
+	err := collection.FindOne(ctx, bson.M{"_id": id, "owner": owner}).Decode(&schema)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schema, nil
+}
+
+// ListSchemas returns the versions of the schemas of an owner, for an
+// action if given, by action and entity type and newest first.
+func (r *SchemaRepository) ListSchemas(ctx context.Context, owner model.ServiceID, action string) ([]*model.Schema, error) {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	filter := bson.M{"owner": owner}
+	if action != "" {
+		filter["action"] = action
+	}
+	opts := options.Find().SetSort(bson.D{
+		{Key: "action", Value: 1},
+		{Key: "entity_type", Value: 1},
+		{Key: "version", Value: -1},
+	})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := []*model.Schema{}
+	err = cursor.All(ctx, &schemas)
+	return schemas, err
+}
+
+// ActiveSchemas returns the latest version of every schema of every
+// service.
+func (r *SchemaRepository) ActiveSchemas(ctx context.Context) ([]*model.Schema, error) {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$sort": bson.M{"version": -1}},
+		bson.M{"$group": bson.M{
+			"_id":    bson.M{"owner": "$owner", "action": "$action", "entity_type": "$entity_type"},
+			"schema": bson.M{"$first": "$$ROOT"},
+		}},
+		bson.M{"$replaceRoot": bson.M{"newRoot": "$schema"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := []*model.Schema{}
+	err = cursor.All(ctx, &schemas)
+	return schemas, err
+}
+
+// DeleteSchema deletes a version of a schema of an owner, and returns it.
+func (r *SchemaRepository) DeleteSchema(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) (*model.Schema, error) {
+	collection := r.client.Database(db).Collection(schemasCollection)
+
+	var schema model.Schema
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": id, "owner": owner}).Decode(&schema)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schema, nil
+}
@@ -0,0 +1,160 @@
+package mongodb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// actorKey identifies an actor in aggregation stages.
+var actorKey = bson.M{"type": "$actor.type", "id": "$actor.id"}
+
+// GetStats aggregates the logs matched by the filter into total and
+// distinct-actor counts, optionally bucketed by time interval and
+// grouped by the values of a field.
+func (r *LogRepository) GetStats(ctx context.Context, filter utils.Filters, opts utils.StatsOptions) (*utils.Stats, error) {
+	collection := r.client.Database(db).Collection(eventLogCollection)
+
+	facets := bson.M{
+		"total":  bson.A{bson.M{"$count": "count"}},
+		"actors": bson.A{bson.M{"$group": bson.M{"_id": actorKey}}, bson.M{"$count": "count"}},
+	}
+
+	var bucket interface{}
+	if opts.Interval != "" {
+		bucket = bson.M{"$dateTrunc": bson.M{
+			"date":     "$timestamp",
+			"unit":     opts.Interval,
+			"timezone": opts.Location.String(),
+		}}
+	}
+
+	switch {
+	case opts.GroupBy != nil && bucket != nil:
+		facets["groups"] = bson.A{
+			bson.M{"$group": bson.M{
+				"_id":    bson.M{"value": "$" + opts.GroupBy.Path, "time": bucket},
+				"count":  bson.M{"$sum": 1},
+				"actors": bson.M{"$addToSet": actorKey},
+			}},
+			bson.M{"$group": bson.M{
+				"_id":    "$_id.value",
+				"count":  bson.M{"$sum": "$count"},
+				"actors": bson.M{"$push": "$actors"},
+				"series": bson.M{"$push": bson.M{
+					"time":            "$_id.time",
+					"count":           "$count",
+					"distinct_actors": bson.M{"$size": "$actors"},
+				}},
+			}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": opts.Top},
+			bson.M{"$project": bson.M{
+				"count":  1,
+				"series": 1,
+				"distinct_actors": bson.M{"$size": bson.M{"$reduce": bson.M{
+					"input":        "$actors",
+					"initialValue": bson.A{},
+					"in":           bson.M{"$setUnion": bson.A{"$$value", "$$this"}},
+				}}},
+			}},
+		}
+	case opts.GroupBy != nil:
+		facets["groups"] = bson.A{
+			bson.M{"$group": bson.M{
+				"_id":    "$" + opts.GroupBy.Path,
+				"count":  bson.M{"$sum": 1},
+				"actors": bson.M{"$addToSet": actorKey},
+			}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": opts.Top},
+			bson.M{"$project": bson.M{"count": 1, "distinct_actors": bson.M{"$size": "$actors"}}},
+		}
+	case bucket != nil:
+		facets["series"] = bson.A{
+			bson.M{"$group": bson.M{
+				"_id":    bucket,
+				"count":  bson.M{"$sum": 1},
+				"actors": bson.M{"$addToSet": actorKey},
+			}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+			bson.M{"$project": bson.M{"time": "$_id", "count": 1, "distinct_actors": bson.M{"$size": "$actors"}}},
+		}
+	}
+
+	pipeline := append(filterStages(filter), bson.M{"$facet": facets})
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		Total  []struct{ Count int } `bson:"total"`
+		Actors []struct{ Count int } `bson:"actors"`
+		Series []struct {
+			Time           time.Time `bson:"time"`
+			Count          int       `bson:"count"`
+			DistinctActors int       `bson:"distinct_actors"`
+		} `bson:"series"`
+		Groups []struct {
+			Value          interface{} `bson:"_id"`
+			Count          int         `bson:"count"`
+			DistinctActors int         `bson:"distinct_actors"`
+			Series         []struct {
+				Time           time.Time `bson:"time"`
+				Count          int       `bson:"count"`
+				DistinctActors int       `bson:"distinct_actors"`
+			} `bson:"series"`
+		} `bson:"groups"`
+	}
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &utils.Stats{}
+	if len(results) == 0 {
+		return stats, nil
+	}
+	result := results[0]
+
+	if len(result.Total) > 0 {
+		stats.Total = result.Total[0].Count
+	}
+	if len(result.Actors) > 0 {
+		stats.DistinctActors = result.Actors[0].Count
+	}
+
+	for _, b := range result.Series {
+		stats.Series = append(stats.Series, utils.StatsBucket{
+			Time:           b.Time.In(opts.Location),
+			Count:          b.Count,
+			DistinctActors: b.DistinctActors,
+		})
+	}
+
+	for _, g := range result.Groups {
+		group := utils.StatsGroup{
+			Value:          g.Value,
+			Count:          g.Count,
+			DistinctActors: g.DistinctActors,
+		}
+		for _, b := range g.Series {
+			group.Series = append(group.Series, utils.StatsBucket{
+				Time:           b.Time.In(opts.Location),
+				Count:          b.Count,
+				DistinctActors: b.DistinctActors,
+			})
+		}
+		sort.Slice(group.Series, func(i, j int) bool {
+			return group.Series[i].Time.Before(group.Series[j].Time)
+		})
+		stats.Groups = append(stats.Groups, group)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,67 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const travelCollection = "actor_travel"
+
+// TravelRepository defines a Mongodb-based repository of the travel
+// histories of actors.
+type TravelRepository struct {
+	client *mongo.Client
+}
+
+// NewTravelRepository instantiates a new Mongodb-based travel repository.
+func NewTravelRepository(client *mongo.Client) *TravelRepository {
+	return &TravelRepository{client}
+}
+
+// EnsureIndexes creates the index travel histories are looked up by. There
+// is one history per actor of a service.
+func (r *TravelRepository) EnsureIndexes(ctx context.Context) error {
+	collection := r.client.Database(db).Collection(travelCollection)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "service_id", Value: 1}, {Key: "actor_type", Value: 1}, {Key: "actor_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// GetHistory retrieves the travel history of an actor in the logs of a
+// service.
+func (r *TravelRepository) GetHistory(ctx context.Context, serviceID model.ServiceID, actorType, actorID string) (*model.TravelHistory, error) {
+	collection := r.client.Database(db).Collection(travelCollection)
+
+	var h model.TravelHistory
+	err := collection.FindOne(ctx, actorDoc(serviceID, actorType, actorID)).Decode(&h)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &h, nil
+}
+
+// SaveHistory saves the travel history of an actor.
+func (r *TravelRepository) SaveHistory(ctx context.Context, h *model.TravelHistory) error {
+	collection := r.client.Database(db).Collection(travelCollection)
+
+	_, err := collection.ReplaceOne(ctx,
+		actorDoc(h.ServiceID, h.ActorType, h.ActorID),
+		h,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
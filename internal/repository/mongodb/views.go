@@ -0,0 +1,157 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const viewsCollection = "views"
+
+// ViewRepository defines a Mongodb-based saved view repository.
+type ViewRepository struct {
+	client *mongo.Client
+}
+
+// NewViewRepository instantiates a new Mongodb-based saved view repository.
+func NewViewRepository(client *mongo.Client) *ViewRepository {
+	return &ViewRepository{client}
+}
+
+// EnsureIndexes creates the indexes the lookups of views rely on. View
+// names are unique per owner.
+func (r *ViewRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.client.Database(db).Collection(viewsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "grants", Value: 1}},
+		},
+	})
+	return err
+}
+
+// readableBy selects the views a service owns or was granted.
+func readableBy(serviceID model.ServiceID) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"owner": serviceID}, bson.M{"grants": serviceID}}}
+}
+
+// AddView adds a view to the views collection.
+func (r *ViewRepository) AddView(ctx context.Context, view *model.View) error {
+	collection := r.client.Database(db).Collection(viewsCollection)
+
+	if view.ID.IsZero() {
+		view.ID = primitive.NewObjectID()
+	}
+	if view.Grants == nil {
+		view.Grants = []model.ServiceID{}
+	}
+
+	_, err := collection.InsertOne(ctx, view)
+	if mongo.IsDuplicateKeyError(err) {
+		return model.ErrDuplicateView
+	}
+	return err
+}
+
+// GetView retrieves a view by its owner and name, provided the given
+// service owns it or was granted it.
+func (r *ViewRepository) GetView(ctx context.Context, owner model.ServiceID, name string, serviceID model.ServiceID) (*model.View, error) {
+	collection := r.client.Database(db).Collection(viewsCollection)
+
+	filter := readableBy(serviceID)
+	filter["owner"], filter["name"] = owner, name
+
+	var view model.View
+	err := collection.FindOne(ctx, filter).Decode(&view)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &view, nil
+}
+
+// ListViews returns the views a service owns or was granted, sorted by
+// owner and name.
+func (r *ViewRepository) ListViews(ctx context.Context, serviceID model.ServiceID) ([]*model.View, error) {
+	collection := r.client.Database(db).Collection(viewsCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := collection.Find(ctx, readableBy(serviceID), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	views := []*model.View{}
+	err = cursor.All(ctx, &views)
+	return views, err
+}
+
+// UpdateView saves the description and parameters of a view.
+func (r *ViewRepository) UpdateView(ctx context.Context, view *model.View) error {
+	view.UpdatedAt = time.Now().UTC()
+	return r.updateOwned(ctx, view.Owner, view.Name, bson.M{"$set": bson.M{
+		"description": view.Description,
+		"params":      view.Params,
+		"updated_at":  view.UpdatedAt,
+	}})
+}
+
+// DeleteView deletes a view.
+func (r *ViewRepository) DeleteView(ctx context.Context, owner model.ServiceID, name string) error {
+	collection := r.client.Database(db).Collection(viewsCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"owner": owner, "name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Grant shares a view with a service.
+func (r *ViewRepository) Grant(ctx context.Context, owner model.ServiceID, name string, grantee model.ServiceID) error {
+	return r.updateOwned(ctx, owner, name, bson.M{
+		"$addToSet": bson.M{"grants": grantee},
+		"$set":      bson.M{"updated_at": time.Now().UTC()},
+	})
+}
+
+// Revoke stops sharing a view with a service.
+func (r *ViewRepository) Revoke(ctx context.Context, owner model.ServiceID, name string, grantee model.ServiceID) error {
+	return r.updateOwned(ctx, owner, name, bson.M{
+		"$pull": bson.M{"grants": grantee},
+		"$set":  bson.M{"updated_at": time.Now().UTC()},
+	})
+}
+
+// updateOwned applies an update to a view of an owner.
+func (r *ViewRepository) updateOwned(ctx context.Context, owner model.ServiceID, name string, update bson.M) error {
+	collection := r.client.Database(db).Collection(viewsCollection)
+
+	result, err := collection.UpdateOne(ctx, bson.M{"owner": owner, "name": name}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return nil
+}
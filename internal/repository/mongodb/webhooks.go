@@ -0,0 +1,265 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhooksCollection   = "webhooks"
+	deliveriesCollection = "webhook_deliveries"
+
+	// deliveryRetention is how long deliveries are kept.
+	deliveryRetention = 30 * 24 * time.Hour
+	// maxDeliveryAttempts bounds the attempts kept per delivery.
+	maxDeliveryAttempts = 20
+)
+
+// WebhookRepository defines a Mongodb-based repository of webhooks and
+// their deliveries.
+type WebhookRepository struct {
+	client *mongo.Client
+}
+
+// NewWebhookRepository instantiates a new Mongodb-based webhook repository.
+func NewWebhookRepository(client *mongo.Client) *WebhookRepository {
+	return &WebhookRepository{client}
+}
+
+// EnsureIndexes creates the indexes the lookups of webhooks and their
+// deliveries rely on. Deliveries expire after 30 days.
+func (r *WebhookRepository) EnsureIndexes(ctx context.Context) error {
+	database := r.client.Database(db)
+
+	_, err := database.Collection(webhooksCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Collection(deliveriesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(deliveryRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// AddWebhook adds a webhook to the webhooks collection.
+func (r *WebhookRepository) AddWebhook(ctx context.Context, hook *model.Webhook) error {
+	collection := r.client.Database(db).Collection(webhooksCollection)
+
+	if hook.ID.IsZero() {
+		hook.ID = primitive.NewObjectID()
+	}
+
+	_, err := collection.InsertOne(ctx, hook)
+	return err
+}
+
+// GetWebhook retrieves a webhook of an owner by its ID.
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) (*model.Webhook, error) {
+	collection := r.client.Database(db).Collection(webhooksCollection)
+
+	var hook model.Webhook
+	err := collection.FindOne(ctx, bson.M{"_id": id, "owner": owner}).Decode(&hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &hook, nil
+}
+
+// ListWebhooks returns the webhooks of an owner, oldest first.
+func (r *WebhookRepository) ListWebhooks(ctx context.Context, owner model.ServiceID) ([]*model.Webhook, error) {
+	return r.findWebhooks(ctx, bson.M{"owner": owner})
+}
+
+// AllWebhooks returns the webhooks of every service.
+func (r *WebhookRepository) AllWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	return r.findWebhooks(ctx, bson.M{})
+}
+
+func (r *WebhookRepository) findWebhooks(ctx context.Context, filter bson.M) ([]*model.Webhook, error) {
+	collection := r.client.Database(db).Collection(webhooksCollection)
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []*model.Webhook{}
+	err = cursor.All(ctx, &hooks)
+	return hooks, err
+}
+
+// UpdateWebhook saves a webhook.
+func (r *WebhookRepository) UpdateWebhook(ctx context.Context, hook *model.Webhook) error {
+	collection := r.client.Database(db).Collection(webhooksCollection)
+
+	hook.UpdatedAt = time.Now().UTC()
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": hook.ID, "owner": hook.Owner}, hook)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes a webhook of an owner along with its deliveries.
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id primitive.ObjectID, owner model.ServiceID) error {
+	database := r.client.Database(db)
+
+	result, err := database.Collection(webhooksCollection).DeleteOne(ctx, bson.M{"_id": id, "owner": owner})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	_, err = database.Collection(deliveriesCollection).DeleteMany(ctx, bson.M{"webhook_id": id})
+	return err
+}
+
+// AddDelivery adds a delivery to the deliveries collection.
+func (r *WebhookRepository) AddDelivery(ctx context.Context, d *model.Delivery) error {
+	collection := r.client.Database(db).Collection(deliveriesCollection)
+
+	if d.ID.IsZero() {
+		d.ID = primitive.NewObjectID()
+	}
+	if d.Attempts == nil {
+		d.Attempts = []model.DeliveryAttempt{}
+	}
+
+	_, err := collection.InsertOne(ctx, d)
+	return err
+}
+
+// ClaimDelivery returns the pending delivery due the longest, and pushes
+// its next attempt back by lease so no one else claims it meanwhile. It
+// returns model.ErrRecordNotFound when no delivery is due.
+func (r *WebhookRepository) ClaimDelivery(ctx context.Context, lease time.Duration) (*model.Delivery, error) {
+	collection := r.client.Database(db).Collection(deliveriesCollection)
+
+	now := time.Now().UTC()
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var d model.Delivery
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"status": model.DeliveryPending, "next_attempt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt": now.Add(lease)}},
+		opts,
+	).Decode(&d)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &d, nil
+}
+
+// RecordAttempt logs an attempt at a delivery, and sets its status and
+// the time of its next attempt, if any. Only the latest attempts are kept.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt model.DeliveryAttempt, status model.DeliveryStatus, next time.Time) error {
+	collection := r.client.Database(db).Collection(deliveriesCollection)
+
+	set := bson.M{"status": status, "updated_at": time.Now().UTC()}
+	update := bson.M{
+		"$push": bson.M{"attempts": bson.M{"$each": bson.A{attempt}, "$slice": -maxDeliveryAttempts}},
+		"$inc":  bson.M{"tries": 1},
+		"$set":  set,
+	}
+	if next.IsZero() {
+		update["$unset"] = bson.M{"next_attempt": ""}
+	} else {
+		set["next_attempt"] = next
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// ListDeliveries returns the latest deliveries of a webhook of an owner,
+// newest first, optionally only those with a status and those older than
+// a delivery ID.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, webhookID primitive.ObjectID, owner model.ServiceID, status model.DeliveryStatus, before primitive.ObjectID, limit int) ([]*model.Delivery, error) {
+	collection := r.client.Database(db).Collection(deliveriesCollection)
+
+	filter := bson.M{"webhook_id": webhookID, "owner": owner}
+	if status != "" {
+		filter["status"] = status
+	}
+	if !before.IsZero() {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*model.Delivery{}
+	err = cursor.All(ctx, &deliveries)
+	return deliveries, err
+}
+
+// Redeliver schedules a delivery of a webhook of an owner to be attempted
+// again right away, with a fresh allowance of attempts.
+func (r *WebhookRepository) Redeliver(ctx context.Context, id, webhookID primitive.ObjectID, owner model.ServiceID) (*model.Delivery, error) {
+	collection := r.client.Database(db).Collection(deliveriesCollection)
+
+	now := time.Now().UTC()
+	var d model.Delivery
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "webhook_id": webhookID, "owner": owner},
+		bson.M{"$set": bson.M{
+			"status":       model.DeliveryPending,
+			"tries":        0,
+			"next_attempt": now,
+			"updated_at":   now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&d)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, model.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,144 @@
+// Package session reconstructs the sessions of an actor from their logs.
+//
+// Logs carrying a session key in their context extension belong to the
+// session the key names. The remaining logs are split into sessions by
+// gaps of inactivity.
+package session
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// Options configures how logs are grouped into sessions.
+type Options struct {
+	// Key is the context extension key holding session identifiers. Logs
+	// are only grouped by inactivity when it is empty.
+	Key string
+	// Gap is the inactivity after which a new session starts.
+	Gap time.Duration
+}
+
+// A Session summarizes a run of activity of an actor.
+type Session struct {
+	// Key is the session identifier the logs carried, if any.
+	Key         string         `json:"key,omitempty"`
+	FirstSeen   time.Time      `json:"first_seen"`
+	LastSeen    time.Time      `json:"last_seen"`
+	Duration    string         `json:"duration"`
+	Events      int            `json:"events"`
+	IPAddresses []string       `json:"ip_addresses"`
+	Locations   []string       `json:"locations"`
+	EntityTypes []string       `json:"entity_types"`
+	Actions     map[string]int `json:"actions"`
+	Logs        []*model.Log   `json:"logs"`
+}
+
+// add records a log in the session.
+func (s *Session) add(log *model.Log) {
+	if s.Events == 0 || log.Timestamp.Before(s.FirstSeen) {
+		s.FirstSeen = log.Timestamp
+	}
+	if log.Timestamp.After(s.LastSeen) {
+		s.LastSeen = log.Timestamp
+	}
+	s.Duration = s.LastSeen.Sub(s.FirstSeen).String()
+	s.Events++
+
+	s.IPAddresses = appendUnique(s.IPAddresses, log.Context.IPAddr)
+	s.Locations = appendUnique(s.Locations, log.Context.Location)
+	s.EntityTypes = appendUnique(s.EntityTypes, log.Entity.Type)
+	s.Actions[log.Action]++
+	s.Logs = append(s.Logs, log)
+}
+
+// appendUnique appends a non-empty value to a list, unless it is already
+// there.
+func appendUnique(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// A Builder groups logs into sessions as they are added, in chronological
+// order.
+type Builder struct {
+	opts     Options
+	keyed    map[string]*Session
+	current  *Session // the session unkeyed logs are added to
+	sessions []*Session
+}
+
+// NewBuilder returns a builder grouping logs with the given options.
+func NewBuilder(opts Options) *Builder {
+	return &Builder{opts: opts, keyed: make(map[string]*Session)}
+}
+
+// Add adds a log to its session. Logs must be added in chronological
+// order.
+func (b *Builder) Add(log *model.Log) {
+	if key, ok := b.key(log); ok {
+		s, ok := b.keyed[key]
+		if !ok {
+			s = b.start(key)
+			b.keyed[key] = s
+		}
+		s.add(log)
+		return
+	}
+
+	if b.current == nil || log.Timestamp.Sub(b.current.LastSeen) > b.opts.Gap {
+		b.current = b.start("")
+	}
+	b.current.add(log)
+}
+
+// key returns the session identifier carried by a log.
+func (b *Builder) key(log *model.Log) (string, bool) {
+	if b.opts.Key == "" {
+		return "", false
+	}
+
+	value, ok := log.Context.Extension[b.opts.Key]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	key, ok := value.(string)
+	if !ok {
+		key = fmt.Sprint(value)
+	}
+	return key, key != ""
+}
+
+func (b *Builder) start(key string) *Session {
+	s := &Session{
+		Key:         key,
+		IPAddresses: []string{},
+		Locations:   []string{},
+		EntityTypes: []string{},
+		Actions:     make(map[string]int),
+	}
+	b.sessions = append(b.sessions, s)
+	return s
+}
+
+// Sessions returns the sessions built so far, in the order they started.
+func (b *Builder) Sessions() []*Session {
+	sessions := make([]*Session, len(b.sessions))
+	copy(sessions, b.sessions)
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].FirstSeen.Before(sessions[j].FirstSeen)
+	})
+	return sessions
+}
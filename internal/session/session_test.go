@@ -0,0 +1,84 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func testLog(minute int, action, ip, sessionKey string) *model.Log {
+	log := &model.Log{
+		Timestamp: time.Date(2023, 7, 14, 9, 0, 0, 0, time.UTC).Add(time.Duration(minute) * time.Minute),
+		Action:    action,
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Entity:    model.Entity{Type: "invoice"},
+		Context:   model.Context{IPAddr: ip, Location: "New York, NY"},
+	}
+	if sessionKey != "" {
+		log.Context.Extension = map[string]interface{}{"session_id": sessionKey}
+	}
+	return log
+}
+
+func TestBuilderGaps(t *testing.T) {
+	b := NewBuilder(Options{Gap: 30 * time.Minute})
+
+	// Test that gaps longer than the inactivity limit start a new session
+	b.Add(testLog(0, "login", "10.0.0.1", ""))
+	b.Add(testLog(10, "viewed", "10.0.0.1", ""))
+	b.Add(testLog(40, "updated", "10.0.0.2", ""))
+	b.Add(testLog(90, "login", "10.0.0.3", ""))
+
+	sessions := b.Sessions()
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	s := sessions[0]
+	if s.Events != 3 || s.Duration != "40m0s" {
+		t.Errorf("Expected 3 events over 40m0s, got %d over %s", s.Events, s.Duration)
+	}
+	if !reflect.DeepEqual(s.IPAddresses, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Unexpected IP addresses %v", s.IPAddresses)
+	}
+	if !reflect.DeepEqual(s.Actions, map[string]int{"login": 1, "viewed": 1, "updated": 1}) {
+		t.Errorf("Unexpected actions %v", s.Actions)
+	}
+	if !reflect.DeepEqual(s.EntityTypes, []string{"invoice"}) || !reflect.DeepEqual(s.Locations, []string{"New York, NY"}) {
+		t.Errorf("Unexpected entity types %v or locations %v", s.EntityTypes, s.Locations)
+	}
+
+	if sessions[1].Events != 1 || !sessions[1].FirstSeen.Equal(testLog(90, "", "", "").Timestamp) {
+		t.Errorf("Unexpected second session %+v", sessions[1])
+	}
+}
+
+func TestBuilderKeys(t *testing.T) {
+	b := NewBuilder(Options{Key: "session_id", Gap: 30 * time.Minute})
+
+	// Test that keyed sessions interleave and ignore gaps, while unkeyed
+	// logs fall back to gaps
+	b.Add(testLog(0, "login", "10.0.0.1", "a"))
+	b.Add(testLog(5, "login", "10.0.0.2", "b"))
+	b.Add(testLog(6, "viewed", "10.0.0.9", ""))
+	b.Add(testLog(120, "logout", "10.0.0.1", "a"))
+	b.Add(testLog(121, "viewed", "10.0.0.9", ""))
+
+	sessions := b.Sessions()
+	if len(sessions) != 4 {
+		t.Fatalf("Expected 4 sessions, got %d", len(sessions))
+	}
+
+	var keys []string
+	for _, s := range sessions {
+		keys = append(keys, s.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "", ""}) {
+		t.Errorf("Unexpected session order %q", keys)
+	}
+	if sessions[0].Events != 2 || sessions[0].Duration != "2h0m0s" {
+		t.Errorf("Expected session a to span 2 events over 2h0m0s, got %d over %s", sessions[0].Events, sessions[0].Duration)
+	}
+}
@@ -0,0 +1,160 @@
+// Package stream fans the logs the service stores out to the clients
+// tailing them live.
+package stream
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+var (
+	// ErrTooManySubscriptions is returned when a service already holds as
+	// many subscriptions as it may.
+	ErrTooManySubscriptions = errors.New("stream: too many subscriptions")
+	// ErrSlowSubscriber ends a subscription whose buffer filled up.
+	ErrSlowSubscriber = errors.New("stream: subscriber fell behind")
+	// ErrClosed ends the subscriptions of a hub that was closed.
+	ErrClosed = errors.New("stream: hub closed")
+)
+
+// A Hub delivers published logs to the subscriptions they match. It never
+// waits on a subscriber: one that does not keep up with the logs it is
+// sent is dropped, and can resume from the last log it received.
+type Hub struct {
+	maxPerService int
+	bufferSize    int
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	counts map[model.ServiceID]int
+	closed bool
+}
+
+// NewHub returns a hub allowing each service maxPerService subscriptions,
+// each buffering up to bufferSize logs.
+func NewHub(maxPerService, bufferSize int) *Hub {
+	return &Hub{
+		maxPerService: maxPerService,
+		bufferSize:    bufferSize,
+		subs:          make(map[*Subscription]struct{}),
+		counts:        make(map[model.ServiceID]int),
+	}
+}
+
+// A Subscription receives the logs visible to a service that it matches.
+type Subscription struct {
+	hub       *Hub
+	serviceID model.ServiceID
+	match     func(*model.Log) bool
+	logs      chan *model.Log
+	done      chan struct{}
+	err       error
+}
+
+// Subscribe registers a subscription of a service to the logs match
+// reports true for. The subscription has to be closed once done with.
+func (h *Hub) Subscribe(serviceID model.ServiceID, match func(*model.Log) bool) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if h.counts[serviceID] >= h.maxPerService {
+		return nil, ErrTooManySubscriptions
+	}
+
+	s := &Subscription{
+		hub:       h,
+		serviceID: serviceID,
+		match:     match,
+		logs:      make(chan *model.Log, h.bufferSize),
+		done:      make(chan struct{}),
+	}
+	h.subs[s] = struct{}{}
+	h.counts[serviceID]++
+
+	return s, nil
+}
+
+// Publish sends a log to the subscriptions of the services it is visible
+// to that match it: those of the service that published it, or every
+// service for logs not tied to one. Subscriptions whose buffer is full are
+// ended with ErrSlowSubscriber. Subscribers must not modify the log.
+func (h *Hub) Publish(log *model.Log) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		if log.ServiceID != model.AnonymousService && log.ServiceID != s.serviceID {
+			continue
+		}
+		if !s.match(log) {
+			continue
+		}
+
+		select {
+		case s.logs <- log:
+		default:
+			h.drop(s, ErrSlowSubscriber)
+		}
+	}
+}
+
+// Close ends every subscription with ErrClosed, and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subs {
+		h.drop(s, ErrClosed)
+	}
+}
+
+// drop ends a subscription with err. The hub's lock must be held.
+func (h *Hub) drop(s *Subscription, err error) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+
+	delete(h.subs, s)
+	if h.counts[s.serviceID]--; h.counts[s.serviceID] == 0 {
+		delete(h.counts, s.serviceID)
+	}
+
+	s.err = err
+	close(s.done)
+}
+
+// Logs returns the channel the subscription receives logs on.
+func (s *Subscription) Logs() <-chan *model.Log {
+	return s.logs
+}
+
+// Done returns a channel closed once the subscription ended, after which
+// Err tells why.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription ended, or nil if it was closed
+// by its subscriber or has not ended.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.drop(s, nil)
+}
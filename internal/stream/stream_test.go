@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func matchAll(*model.Log) bool { return true }
+
+func TestSubscribe(t *testing.T) {
+	hub := NewHub(2, 1)
+
+	// Test the per-service limit
+	a, err := hub.Subscribe("billing", matchAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Subscribe("billing", matchAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Subscribe("billing", matchAll); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("Expected ErrTooManySubscriptions, got %v", err)
+	}
+	if _, err := hub.Subscribe("orders", matchAll); err != nil {
+		t.Errorf("Expected other services to be unaffected, got %v", err)
+	}
+
+	// Test that closing a subscription frees its slot
+	a.Close()
+	if a.Err() != nil {
+		t.Errorf("Expected no error, got %v", a.Err())
+	}
+	if _, err := hub.Subscribe("billing", matchAll); err != nil {
+		t.Errorf("Expected a free slot, got %v", err)
+	}
+
+	// Test that closing the hub ends every subscription
+	hub.Close()
+	if _, err := hub.Subscribe("orders", matchAll); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	hub := NewHub(5, 1)
+
+	billing, _ := hub.Subscribe("billing", matchAll)
+	orders, _ := hub.Subscribe("orders", matchAll)
+	created, _ := hub.Subscribe("orders", func(l *model.Log) bool { return l.Action == "created" })
+
+	// Test tenant scoping and matching
+	hub.Publish(&model.Log{Action: "billed", ServiceID: "billing"})
+	if len(billing.Logs()) != 1 || len(orders.Logs()) != 0 || len(created.Logs()) != 0 {
+		t.Errorf("Expected the log to reach the billing subscription only")
+	}
+	<-billing.Logs()
+
+	// Test that logs not tied to a service reach every service
+	hub.Publish(&model.Log{Action: "created"})
+	if len(billing.Logs()) != 1 || len(orders.Logs()) != 1 || len(created.Logs()) != 1 {
+		t.Errorf("Expected the log to reach every subscription")
+	}
+
+	// Test that a full buffer ends the subscription
+	hub.Publish(&model.Log{Action: "updated", ServiceID: "orders"})
+	select {
+	case <-orders.Done():
+		if !errors.Is(orders.Err(), ErrSlowSubscriber) {
+			t.Errorf("Expected ErrSlowSubscriber, got %v", orders.Err())
+		}
+	default:
+		t.Errorf("Expected slow subscription to end")
+	}
+	if billing.Err() != nil || created.Err() != nil {
+		t.Errorf("Expected other subscriptions to go on")
+	}
+}
@@ -0,0 +1,186 @@
+// Package travel notices actors seen from places they could not have
+// travelled to in time, or from places and networks never seen for them.
+package travel
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/geo"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// Kinds of findings.
+const (
+	KindImpossibleTravel = "impossible-travel"
+	KindNewLocation      = "new-location"
+	KindNewASN           = "new-asn"
+)
+
+const (
+	// maxSeen bounds the locations and networks kept per actor; the least
+	// recently seen are forgotten first.
+	maxSeen = 50
+	// earthRadius is the mean radius of the Earth, in kilometres.
+	earthRadius = 6371.0
+)
+
+// A Detector checks the logs of actors against their travel history.
+type Detector struct {
+	// MaxSpeed is the fastest an actor may travel, in km/h.
+	MaxSpeed float64
+	// MinDistance is the distance, in km, under which moves are ignored,
+	// as geolocation is only accurate up to a point.
+	MinDistance float64
+}
+
+// NewDetector returns a detector flagging moves faster than maxSpeed km/h.
+func NewDetector(maxSpeed float64) *Detector {
+	return &Detector{MaxSpeed: maxSpeed, MinDistance: 500}
+}
+
+// NewHistory returns an empty travel history for the actor of a log.
+func NewHistory(log *model.Log) *model.TravelHistory {
+	return &model.TravelHistory{
+		ServiceID: log.ServiceID,
+		ActorType: log.Actor.Type,
+		ActorID:   log.Actor.ID,
+		Locations: []model.Seen{},
+		ASNs:      []model.Seen{},
+	}
+}
+
+// Observe checks a log against the travel history of its actor, then adds
+// it to the history. The log is placed where its IP address was looked up
+// to be, or else at the location it was published with.
+func (d *Detector) Observe(h *model.TravelHistory, log *model.Log) []model.Finding {
+	t := log.Timestamp
+
+	var place model.IPInfo
+	if log.IP != nil {
+		place = *log.IP
+	}
+	located := place.Latitude != nil && place.Longitude != nil
+
+	location := geo.Name(place)
+	if location == "" {
+		location = strings.TrimSpace(log.Context.Location)
+	}
+	var asn string
+	if place.ASN != 0 {
+		asn = fmt.Sprintf("AS%d", place.ASN)
+		if place.ASOrg != "" {
+			asn += " " + place.ASOrg
+		}
+	}
+
+	var findings []model.Finding
+	if location != "" && len(h.Locations) > 0 && !contains(h.Locations, location) {
+		findings = append(findings, model.Finding{
+			Kind:     KindNewLocation,
+			Severity: "warning",
+			Detail:   fmt.Sprintf("first seen from %s", location),
+		})
+	}
+	if asn != "" && len(h.ASNs) > 0 && !contains(h.ASNs, asn) {
+		findings = append(findings, model.Finding{
+			Kind:     KindNewASN,
+			Severity: "info",
+			Detail:   fmt.Sprintf("first seen from network %s", asn),
+		})
+	}
+
+	if located && h.Last != nil {
+		distance := Distance(h.Last.Latitude, h.Last.Longitude, *place.Latitude, *place.Longitude)
+		elapsed := t.Sub(h.Last.Time)
+		if elapsed < 0 {
+			elapsed = -elapsed
+		}
+		speed := math.Inf(1)
+		if elapsed > 0 {
+			speed = distance / elapsed.Hours()
+		}
+
+		if distance >= d.MinDistance && speed > d.MaxSpeed {
+			findings = append(findings, model.Finding{
+				Kind:     KindImpossibleTravel,
+				Severity: "critical",
+				Detail: fmt.Sprintf("seen from %s %s after %s, %.0f km away",
+					placeName(location, log.Context.IPAddr), elapsed, placeName(h.Last.Location, h.Last.IPAddr), distance),
+			})
+		}
+	}
+
+	if location != "" {
+		h.Locations = see(h.Locations, location, t)
+	}
+	if asn != "" {
+		h.ASNs = see(h.ASNs, asn, t)
+	}
+	if located && (h.Last == nil || !t.Before(h.Last.Time)) {
+		h.Last = &model.Sighting{
+			Time:      t,
+			IPAddr:    log.Context.IPAddr,
+			Location:  location,
+			Latitude:  *place.Latitude,
+			Longitude: *place.Longitude,
+		}
+	}
+
+	return findings
+}
+
+func placeName(location, ip string) string {
+	if location == "" {
+		return ip
+	}
+	return location
+}
+
+// contains reports whether a value was seen, ignoring case.
+func contains(seen []model.Seen, value string) bool {
+	for _, s := range seen {
+		if strings.EqualFold(s.Value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// see records a value seen at t, keeping the most recently seen values.
+func see(seen []model.Seen, value string, t time.Time) []model.Seen {
+	found := false
+	for i := range seen {
+		if strings.EqualFold(seen[i].Value, value) {
+			if t.After(seen[i].LastSeen) {
+				seen[i].LastSeen = t
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		seen = append(seen, model.Seen{Value: value, LastSeen: t})
+	}
+
+	if len(seen) > maxSeen {
+		sort.SliceStable(seen, func(i, j int) bool { return seen[i].LastSeen.After(seen[j].LastSeen) })
+		seen = seen[:maxSeen]
+	}
+	return seen
+}
+
+// Distance returns the great-circle distance, in km, between two points
+// given by their latitude and longitude in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
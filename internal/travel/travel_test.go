@@ -0,0 +1,88 @@
+package travel
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func place(country, city string, lat, lon float64, asn uint) *model.IPInfo {
+	return &model.IPInfo{Country: country, City: city, Latitude: &lat, Longitude: &lon, ASN: asn}
+}
+
+var (
+	london = place("GB", "London", 51.5142, -0.0931, 20712)
+	paris  = place("FR", "Paris", 48.8566, 2.3522, 20712)
+	lagos  = place("NG", "Lagos", 6.4550, 3.3841, 37148)
+)
+
+func login(ip, location string, t time.Time, info *model.IPInfo) *model.Log {
+	return &model.Log{
+		Timestamp: t,
+		Action:    "login",
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Context:   model.Context{IPAddr: ip, Location: location},
+		IP:        info,
+		ServiceID: "auth",
+	}
+}
+
+func kinds(findings []model.Finding) map[string]bool {
+	m := make(map[string]bool)
+	for _, f := range findings {
+		m[f.Kind] = true
+	}
+	return m
+}
+
+func TestDistance(t *testing.T) {
+	if d := Distance(*london.Latitude, *london.Longitude, *lagos.Latitude, *lagos.Longitude); math.Abs(d-5000) > 50 {
+		t.Errorf("Expected about 5000 km from London to Lagos, got %.0f", d)
+	}
+	if d := Distance(1, 2, 1, 2); d != 0 {
+		t.Errorf("Expected no distance, got %v", d)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	detector := NewDetector(1000)
+	start := time.Date(2023, 7, 1, 9, 0, 0, 0, time.UTC)
+	h := NewHistory(login("", "", start, nil))
+
+	// Test that the first sighting is not a finding
+	if f := detector.Observe(h, login("81.2.69.160", "", start, london)); len(f) != 0 {
+		t.Errorf("Expected no finding, got %+v", f)
+	}
+
+	// Test that a nearby new location is not impossible travel
+	f := detector.Observe(h, login("81.2.69.161", "", start.Add(2*time.Hour), paris))
+	if k := kinds(f); len(f) != 1 || !k[KindNewLocation] {
+		t.Errorf("Expected a new location, got %+v", f)
+	}
+
+	// Test that a distant place reached too soon is impossible travel
+	f = detector.Observe(h, login("41.58.0.1", "", start.Add(3*time.Hour), lagos))
+	if k := kinds(f); len(f) != 3 || !k[KindImpossibleTravel] || !k[KindNewLocation] || !k[KindNewASN] {
+		t.Errorf("Expected impossible travel from a new location and network, got %+v", f)
+	}
+
+	// Test that coming back after long enough is not a finding
+	f = detector.Observe(h, login("81.2.69.160", "", start.Add(24*time.Hour), london))
+	if len(f) != 0 {
+		t.Errorf("Expected no finding, got %+v", f)
+	}
+	if h.Last == nil || h.Last.Location != "London, GB" || len(h.Locations) != 3 || len(h.ASNs) != 2 {
+		t.Errorf("Unexpected history %+v", h)
+	}
+
+	// Test that published locations are used for places without a name
+	f = detector.Observe(h, login("", "new york, NY", start.Add(25*time.Hour), nil))
+	if k := kinds(f); len(f) != 1 || !k[KindNewLocation] {
+		t.Errorf("Expected a new location, got %+v", f)
+	}
+	if f := detector.Observe(h, login("", "New York, NY", start.Add(26*time.Hour), nil)); len(f) != 0 {
+		t.Errorf("Expected locations to compare regardless of case, got %+v", f)
+	}
+}
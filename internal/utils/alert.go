@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// AlertSeverities lists the severities alert rules can have.
+var AlertSeverities = []string{"info", "warning", "critical"}
+
+const (
+	// maxAlertWindow bounds the window of alert rules, as their counts are
+	// held in memory.
+	maxAlertWindow = 24 * time.Hour
+	// maxAlertThreshold bounds the threshold of alert rules, as the times
+	// of the logs counted are kept.
+	maxAlertThreshold = 10_000
+)
+
+// ValidateAlertRule validates an alert rule. Its query follows the syntax
+// of the q parameter of 'GET /v1/logs', and its group-by fields are any
+// fields usable in it.
+func ValidateAlertRule(v *Validator, rule *model.AlertRule) {
+	v.Check(ViewNameRX.MatchString(rule.Name), "name", "must be 1 to 64 lowercase letters, digits, '-' or '_'")
+	v.Check(len(rule.Description) <= 500, "description", "must not be more than 500 bytes long")
+	v.Check(PermittedValue(rule.Severity, AlertSeverities...), "severity", "must be one of info, warning or critical")
+
+	if rule.Query == "" {
+		v.AddError("query", "must be provided")
+	} else if _, err := query.Parse(rule.Query); err != nil {
+		v.AddError("query", err.Error())
+	}
+
+	v.Check(rule.Threshold >= 1, "threshold", "must be at least 1")
+	v.Check(rule.Threshold <= maxAlertThreshold, "threshold", "must be a maximum of 10000")
+
+	window := time.Duration(rule.Window)
+	if rule.Threshold > 1 {
+		v.Check(window > 0, "window", "must be provided when threshold is more than 1")
+		v.Check(window <= maxAlertWindow, "window", "must be a maximum of 24h")
+	} else {
+		v.Check(window == 0, "window", "must only be provided when threshold is more than 1")
+		v.Check(len(rule.GroupBy) == 0, "group_by", "must only be provided when threshold is more than 1")
+	}
+
+	v.Check(len(rule.GroupBy) <= 5, "group_by", "must not have more than 5 fields")
+	for _, name := range rule.GroupBy {
+		_, ok := query.LookupField(name)
+		v.Check(ok, "group_by", "unknown field "+name)
+	}
+}
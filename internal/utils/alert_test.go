@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestValidateAlertRule(t *testing.T) {
+	validator := NewValidator()
+
+	// Test with valid rules
+	for _, rule := range []*model.AlertRule{
+		{Name: "deactivations", Severity: "critical", Query: "action = deactivated AND actor.extension.role != admin", Threshold: 1},
+		{Name: "failed-logins", Severity: "warning", Query: "action = login_failed", GroupBy: []string{"context.ip_address"}, Threshold: 11, Window: model.Duration(5 * time.Minute)},
+	} {
+		ValidateAlertRule(validator, rule)
+		if !validator.Valid() {
+			t.Errorf("Expected valid rule %s, got %v", rule.Name, validator.Errors)
+		}
+	}
+
+	// Test with invalid rule
+	validator = NewValidator()
+	ValidateAlertRule(validator, &model.AlertRule{
+		Name:      "Failed logins",
+		Severity:  "urgent",
+		Query:     "action =",
+		GroupBy:   []string{"context.ip"},
+		Threshold: 11,
+		Window:    model.Duration(48 * time.Hour),
+	})
+	for _, key := range []string{"name", "severity", "query", "group_by", "window"} {
+		if _, ok := validator.Errors[key]; !ok {
+			t.Errorf("Expected error message for %s", key)
+		}
+	}
+
+	// Test with windowed fields on a single-event rule
+	validator = NewValidator()
+	ValidateAlertRule(validator, &model.AlertRule{
+		Name:      "deactivations",
+		Severity:  "info",
+		Query:     "action = deactivated",
+		GroupBy:   []string{"actor.id"},
+		Threshold: 1,
+		Window:    model.Duration(time.Minute),
+	})
+	for _, key := range []string{"group_by", "window"} {
+		if _, ok := validator.Errors[key]; !ok {
+			t.Errorf("Expected error message for %s", key)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned when a cursor token cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// A Cursor marks the position of the last record returned on a page. It
+// holds the sort keys, their values and the _id of that record, so the
+// next page can resume right after it regardless of inserts made in the
+// meantime. It also holds the time bounds the page was read within, so
+// that bounds relative to now, such as "now-1h", do not move between
+// pages.
+type Cursor struct {
+	Sort   []SortKey          `bson:"s,omitempty"`
+	Values []interface{}      `bson:"v,omitempty"`
+	ID     primitive.ObjectID `bson:"id"`
+	Start  time.Time          `bson:"a,omitempty"`
+	End    time.Time          `bson:"b,omitempty"`
+}
+
+// EncodeCursor returns the opaque token representation of a cursor. On
+// the default order and without time bounds the position is fully given
+// by the _id, so the token is simply the hex ID of the record.
+func EncodeCursor(c Cursor) (string, error) {
+	if len(c.Sort) == 0 && c.Start.IsZero() && c.End.IsZero() {
+		return c.ID.Hex(), nil
+	}
+
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor parses a token produced by EncodeCursor.
+func DecodeCursor(token string) (*Cursor, error) {
+	if id, err := primitive.ObjectIDFromHex(token); err == nil {
+		return &Cursor{ID: id}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = bson.Unmarshal(data, &c)
+	if err != nil || c.ID.IsZero() || len(c.Values) != len(c.Sort) {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
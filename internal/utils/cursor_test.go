@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	now := time.Date(2022, 8, 16, 12, 34, 56, 0, time.UTC)
+
+	// Test round trip of a cursor
+	token, err := EncodeCursor(Cursor{
+		Sort:   []SortKey{{Field: "timestamp", Descending: true}, {Field: "actor.id"}},
+		Values: []interface{}{now, "12300"},
+		ID:     id,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cursor, err := DecodeCursor(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !SameSort(cursor.Sort, []SortKey{{Field: "timestamp", Descending: true}, {Field: "actor.id"}}) || cursor.ID != id {
+		t.Errorf("unexpected cursor: %+v", cursor)
+	}
+	if value, ok := cursor.Values[0].(primitive.DateTime); !ok || !value.Time().Equal(now) {
+		t.Errorf("Expected '%v', got '%v'", now, cursor.Values[0])
+	}
+	if cursor.Values[1] != "12300" {
+		t.Errorf("Expected '12300', got '%v'", cursor.Values[1])
+	}
+
+	// Test with a cursor on the default order
+	token, _ = EncodeCursor(Cursor{ID: id})
+	if token != id.Hex() {
+		t.Errorf("Expected '%s', got '%s'", id.Hex(), token)
+	}
+	if cursor, err := DecodeCursor(token); err != nil || cursor.ID != id || len(cursor.Sort) != 0 {
+		t.Errorf("unexpected cursor %+v, error %v", cursor, err)
+	}
+
+	// Test that time bounds are carried on the default order
+	start, end := now.Add(-time.Hour), now
+	token, _ = EncodeCursor(Cursor{ID: id, Start: start, End: end})
+	cursor, err = DecodeCursor(token)
+	if err != nil || cursor.ID != id || !cursor.Start.Equal(start) || !cursor.End.Equal(end) {
+		t.Errorf("unexpected cursor %+v, error %v", cursor, err)
+	}
+
+	// Test that relative bounds are taken from the cursor, unlike absolute
+	// ones
+	qs := url.Values{"start_timestamp": {"now-1h"}, "end_timestamp": {"2022-08-16T13:00:00Z"}, "cursor": {token}}
+	v := NewValidator()
+	input := ReadFilters(qs, v)
+	if !v.Valid() {
+		t.Fatalf("unexpected errors %v", v.Errors)
+	}
+	if !input.StartTimestamp.Equal(start) {
+		t.Errorf("Expected start '%v', got '%v'", start, input.StartTimestamp)
+	}
+	if expected := time.Date(2022, 8, 16, 13, 0, 0, 0, time.UTC); !input.EndTimestamp.Equal(expected) {
+		t.Errorf("Expected end '%v', got '%v'", expected, input.EndTimestamp)
+	}
+
+	// Test with malformed tokens
+	for _, token := range []string{"", "not a cursor", "AAAA"} {
+		if _, err := DecodeCursor(token); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", token, err)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
 )
 
 // Filter contains the parsed query_string
@@ -10,18 +16,171 @@ type Filters struct {
 	ActorType      string
 	ActorID        string
 	EntityType     string
+	EntityID       string
 	StartTimestamp time.Time
 	EndTimestamp   time.Time
-	SortField      string
-	SortDescending bool
+	Sort           []SortKey
 	PageSize       int
 	Page           int
+	Cursor         *Cursor
+	Query          query.Expr
+	Search         string
+	Facets         []string
+	Fields         []string
+	// MaxSpan bounds the time span the filters may cover, unless zero.
+	MaxSpan time.Duration
+	// ServiceID restricts the logs to those visible to a service, unless
+	// empty.
+	ServiceID model.ServiceID
+}
+
+// Match reports whether a log meets the filter criteria, apart from the
+// full-text search, which only the database can evaluate.
+func (f Filters) Match(log *model.Log) bool {
+	switch {
+	case f.Action != "" && log.Action != f.Action,
+		f.ActorType != "" && log.Actor.Type != f.ActorType,
+		f.ActorID != "" && log.Actor.ID != f.ActorID,
+		f.EntityType != "" && log.Entity.Type != f.EntityType,
+		f.EntityID != "" && log.Entity.ID != f.EntityID,
+		!f.StartTimestamp.IsZero() && log.Timestamp.Before(f.StartTimestamp),
+		!f.EndTimestamp.IsZero() && log.Timestamp.After(f.EndTimestamp),
+		f.ServiceID != "" && log.ServiceID != "" && log.ServiceID != f.ServiceID:
+		return false
+	}
+
+	return query.Match(f.Query, log)
+}
+
+// A SortKey is one key of the order logs are sorted in.
+type SortKey struct {
+	Field      string `bson:"f"` // path of the field in storage
+	Descending bool   `bson:"d,omitempty"`
+}
+
+// SameSort reports whether two sorts order logs the same way.
+func SameSort(a, b []SortKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// maxSortKeys bounds the keys of a sort.
+const maxSortKeys = 4
+
+// ReadSort parses a comma-separated list of fields to sort on, such as
+// "-timestamp,actor.id", where a leading '-' sorts in descending order.
+// Logs can be sorted on the fixed fields and the relevance score.
+func ReadSort(qs url.Values, key string, v *Validator) []SortKey {
+	var (
+		keys    []SortKey
+		unknown []string
+	)
+	for _, item := range ReadList(qs, key) {
+		name := strings.TrimPrefix(item, "-")
+
+		path, ok := sortPath(name)
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		for _, k := range keys {
+			if k.Field == path {
+				v.AddError(key, fmt.Sprintf("must not list %s more than once", name))
+			}
+		}
+		keys = append(keys, SortKey{Field: path, Descending: item[0] == '-'})
+	}
+
+	if len(unknown) > 0 {
+		v.AddError(key, "unknown or unsortable fields: "+strings.Join(unknown, ", "))
+	}
+	v.Check(len(keys) <= maxSortKeys, key, fmt.Sprintf("must not have more than %d fields", maxSortKeys))
+
+	return keys
+}
+
+// sortPath returns the storage path of a sortable field.
+func sortPath(name string) (string, bool) {
+	if name == "score" {
+		return name, true
+	}
+
+	field, ok := query.LookupField(name)
+	if !ok || field.Extension || field.List {
+		return "", false
+	}
+	return field.Path, true
 }
 
 // A Metadata provides extra info about the filtered, sorted and paginated
 // log records returned on 'GET /v1/event-log?<query_string>'.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int                     `json:"current_page,omitempty"`
+	PageSize     int                     `json:"page_size,omitempty"`
+	TotalRecords int                     `json:"total_records"`
+	NextCursor   string                  `json:"next_cursor,omitempty"`
+	Facets       map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// A FacetCount is the number of filtered logs holding a value of a field.
+type FacetCount struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// facetFields lists the fields value counts can be requested for.
+var facetFields = []string{"action", "actor.type", "entity.type", "context.location", "ip.country", "ip.asn", "user_agent.browser", "user_agent.os", "user_agent.device"}
+
+// facetMessage is the error reported for facets not in facetFields.
+var facetMessage = "must be a list of " + strings.Join(facetFields[:len(facetFields)-1], ", ") + " or " + facetFields[len(facetFields)-1]
+
+// ReadFilters parses the query_string of the endpoints querying logs.
+func ReadFilters(qs url.Values, v *Validator) Filters {
+	var input Filters
+
+	input.Action = ReadStr(qs, "action", "")
+	input.ActorID = ReadStr(qs, "actor_id", "")
+	input.ActorType = ReadStr(qs, "actor_type", "")
+	input.EntityType = ReadStr(qs, "entity_type", "")
+	input.EntityID = ReadStr(qs, "entity_id", "")
+	input.StartTimestamp = ParseTime(qs, "start_timestamp", v)
+	input.EndTimestamp = ParseTime(qs, "end_timestamp", v)
+	input.Sort = ReadSort(qs, "sort", v)
+	input.Page = ReadInt(qs, "page", 1, v)
+	input.PageSize = ReadInt(qs, "page_size", 20, v)
+	input.Cursor = ReadCursor(qs, "cursor", v)
+	input.Query = query.AndOf(ReadQuery(qs, "q", v), ReadExtensionFilters(qs, v))
+	input.Search = ReadStr(qs, "search", "")
+	input.Facets = ReadList(qs, "facets")
+	input.Fields = ReadFields(qs, "fields", v)
+
+	// A cursor carries the time bounds of the first page, so that bounds
+	// relative to now are resolved once for all pages.
+	if input.Cursor != nil {
+		if strings.HasPrefix(qs.Get("start_timestamp"), "now") && !input.Cursor.Start.IsZero() {
+			input.StartTimestamp = input.Cursor.Start
+		}
+		if strings.HasPrefix(qs.Get("end_timestamp"), "now") && !input.Cursor.End.IsZero() {
+			input.EndTimestamp = input.Cursor.End
+		}
+	}
+
+	// A cursor carries its own sort, so follow-up requests need not repeat
+	// it. Searches are otherwise ranked by relevance.
+	switch {
+	case input.Cursor != nil && qs.Get("sort") == "":
+		input.Sort = input.Cursor.Sort
+	case input.Search != "" && qs.Get("sort") == "":
+		input.Sort = []SortKey{{Field: "score", Descending: true}}
+	}
+
+	return input
 }
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// containerFields lists the fields holding documents that can be selected
+// whole, besides the fixed and extension fields.
+var containerFields = []string{
+	"actor", "entity", "context", "changes",
+	"extension", "actor.extension", "entity.extension", "context.extension",
+}
+
+// A projectedField is a field selected by a projection.
+type projectedField struct {
+	name string // name used in the query string
+	path string // dotted path of the field in storage
+}
+
+// ReadFields parses a comma-separated list of the fields to return with
+// logs. Fields are the fixed and extension fields of logs, or the
+// documents holding them.
+func ReadFields(qs url.Values, key string, v *Validator) []string {
+	var (
+		fields  []string
+		unknown []string
+	)
+	for _, name := range ReadList(qs, key) {
+		if _, ok := lookupProjected(name); !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		fields = append(fields, name)
+	}
+
+	if len(unknown) > 0 {
+		v.AddError(key, "unknown fields: "+strings.Join(unknown, ", "))
+	}
+
+	return fields
+}
+
+// lookupProjected returns the field a projection selects by name.
+func lookupProjected(name string) (projectedField, bool) {
+	for _, c := range containerFields {
+		if name == c {
+			return projectedField{name: name, path: name}, true
+		}
+	}
+
+	field, ok := query.LookupField(name)
+	if !ok {
+		return projectedField{}, false
+	}
+	return projectedField{name: name, path: field.Path}, true
+}
+
+// ProjectionPaths returns the storage paths of the given fields, as read
+// by ReadFields.
+func ProjectionPaths(fields []string) []string {
+	paths := make([]string, 0, len(fields))
+	for _, name := range fields {
+		if f, ok := lookupProjected(name); ok {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths
+}
+
+// jsonNames maps the fields whose name differs from their name in the
+// JSON representation of logs.
+var jsonNames = map[string]string{
+	"timestamp": "created_at",
+}
+
+// Project returns the JSON representation of a log restricted to the given
+// fields. The ID of the log and, for search results, its score and
+// highlights are always kept.
+func Project(log *model.Log, fields []string) (map[string]interface{}, error) {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := map[string]interface{}{}
+	for _, key := range []string{"ID", "score", "highlights"} {
+		if value, ok := doc[key]; ok {
+			projected[key] = value
+		}
+	}
+
+	for _, name := range fields {
+		if jsonName, ok := jsonNames[name]; ok {
+			name = jsonName
+		}
+		copyPath(projected, doc, strings.Split(name, "."))
+	}
+
+	return projected, nil
+}
+
+// copyPath copies the value at a path of src to the same path of dst,
+// creating the documents along it as needed. Missing values are skipped.
+func copyPath(dst, src map[string]interface{}, keys []string) {
+	value, ok := src[keys[0]]
+	if !ok {
+		return
+	}
+	if len(keys) == 1 {
+		dst[keys[0]] = value
+		return
+	}
+
+	next, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	sub, ok := dst[keys[0]].(map[string]interface{})
+	if !ok {
+		sub = map[string]interface{}{}
+	}
+	copyPath(sub, next, keys[1:])
+	if len(sub) > 0 {
+		dst[keys[0]] = sub
+	}
+}
+
+// ProjectLogs restricts logs to the given fields with Project. Logs are
+// returned as they are when no fields are given.
+func ProjectLogs(logs []*model.Log, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return logs, nil
+	}
+
+	projected := make([]map[string]interface{}, 0, len(logs))
+	for _, log := range logs {
+		p, err := Project(log, fields)
+		if err != nil {
+			return nil, err
+		}
+		projected = append(projected, p)
+	}
+
+	return projected, nil
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReadFields(t *testing.T) {
+	queryStr := url.Values{}
+	queryStr.Add("fields", "timestamp,actor,extension.amount")
+
+	// Test with valid fields in query string
+	validator := NewValidator()
+	fields := ReadFields(queryStr, "fields", validator)
+	if !validator.Valid() || len(fields) != 3 {
+		t.Errorf("Expected 3 fields, got %v (%v)", fields, validator.Errors)
+	}
+	if paths := ProjectionPaths([]string{"context.ip_address", "actor"}); !reflect.DeepEqual(paths, []string{"context.ipaddr", "actor"}) {
+		t.Errorf("Unexpected projection paths %v", paths)
+	}
+
+	// Test with unknown fields in query string
+	validator = NewValidator()
+	queryStr.Set("fields", "action,unknown,actor.name")
+	ReadFields(queryStr, "fields", validator)
+	if msg := validator.Errors["fields"]; msg != "unknown fields: unknown, actor.name" {
+		t.Errorf("Unexpected error message for fields %q", msg)
+	}
+}
+
+func TestProject(t *testing.T) {
+	id := primitive.NewObjectID()
+	log := &model.Log{
+		ID:        id,
+		Timestamp: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		Action:    "billed",
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Context:   model.Context{IPAddr: "127.0.0.1", Location: "Lagos"},
+		Extension: map[string]interface{}{"amount": 100.0, "currency": "NGN"},
+	}
+
+	projected, err := Project(log, []string{"timestamp", "actor.id", "context.ip_address", "extension.amount", "extension.missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"ID":         id.Hex(),
+		"created_at": "2023-03-01T00:00:00Z",
+		"actor":      map[string]interface{}{"id": "12300"},
+		"context":    map[string]interface{}{"ip_address": "127.0.0.1"},
+		"extension":  map[string]interface{}{"amount": 100.0},
+	}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Expected %v, got %v", expected, projected)
+	}
+}
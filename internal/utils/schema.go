@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Modes logs are checked against their schema in.
+const (
+	SchemaStrict = "strict" // logs not matching their schema are rejected
+	SchemaWarn   = "warn"   // logs not matching their schema are reported
+	SchemaOff    = "off"    // logs are not checked
+)
+
+// maxSchemaSize bounds the size of schema documents.
+const maxSchemaSize = 64 << 10
+
+// CompileSchema compiles a JSON Schema document, draft 2020-12 unless it
+// declares otherwise. Schemas may not refer to other documents.
+func CompileSchema(doc []byte) (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("cannot load %s: schemas may not refer to other documents", s)
+	}
+
+	if err := c.AddResource("schema.json", bytes.NewReader(doc)); err != nil {
+		return nil, err
+	}
+	return c.Compile("schema.json")
+}
+
+// ValidateSchema validates the registration of a schema.
+func ValidateSchema(v *Validator, schema *model.Schema) {
+	v.Check(schema.Action != "", "action", "must be provided")
+	v.Check(len(schema.Action) <= 255, "action", "must not be more than 255 bytes long")
+	v.Check(len(schema.EntityType) <= 255, "entity_type", "must not be more than 255 bytes long")
+	v.Check(PermittedValue(schema.Mode, "", SchemaStrict, SchemaWarn, SchemaOff), "mode", "must be strict, warn or off")
+
+	switch {
+	case len(schema.Document) == 0:
+		v.AddError("schema", "must be provided")
+	case len(schema.Document) > maxSchemaSize:
+		v.AddError("schema", "must not be more than 64KiB")
+	default:
+		if _, err := CompileSchema(schema.Document); err != nil {
+			v.AddError("schema", err.Error())
+		}
+	}
+}
+
+// ValidateLogSchema validates a log, as served by the API, against a
+// schema. Errors are keyed by the JSON Pointer of the value they are
+// about, e.g. "/extension/amount", the log itself being "".
+func ValidateLogSchema(v *Validator, schema *jsonschema.Schema, log *model.Log) {
+	body, err := json.Marshal(log)
+	if err != nil {
+		v.AddError("", err.Error())
+		return
+	}
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		v.AddError("", err.Error())
+		return
+	}
+
+	err = schema.Validate(doc)
+	if err == nil {
+		return
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		v.AddError("", err.Error())
+		return
+	}
+
+	// The causes of errors are more precise than the errors themselves.
+	messages := make(map[string][]string)
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			messages[e.InstanceLocation] = append(messages[e.InstanceLocation], e.Message)
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(ve)
+
+	for pointer, msgs := range messages {
+		sort.Strings(msgs)
+		unique := msgs[:1]
+		for _, m := range msgs[1:] {
+			if m != unique[len(unique)-1] {
+				unique = append(unique, m)
+			}
+		}
+		v.AddError(pointer, strings.Join(unique, "; "))
+	}
+}
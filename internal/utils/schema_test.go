@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+const paymentSchema = `{
+	"type": "object",
+	"required": ["extension"],
+	"properties": {
+		"extension": {
+			"type": "object",
+			"required": ["amount", "currency"],
+			"properties": {
+				"amount": {"type": "number", "exclusiveMinimum": 0},
+				"currency": {"enum": ["NGN", "USD"]},
+				"items": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"actor": {
+			"properties": {"extension": {"properties": {"email": {"format": "email"}}}}
+		}
+	}
+}`
+
+func TestValidateLogSchema(t *testing.T) {
+	schema, err := CompileSchema([]byte(paymentSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test with a log matching the schema
+	log := &model.Log{
+		Action:    "paid",
+		Actor:     model.Actor{Type: "user", ID: "1", Extension: map[string]interface{}{"email": "ada@example.com"}},
+		Extension: map[string]interface{}{"amount": 1500, "currency": "NGN", "items": []interface{}{"sku-1"}},
+	}
+	v := NewValidator()
+	if ValidateLogSchema(v, schema, log); !v.Valid() {
+		t.Errorf("Expected no error, got %v", v.Errors)
+	}
+
+	// Test that violations are keyed by JSON Pointer
+	log.Actor.Extension["email"] = "ada"
+	log.Extension = map[string]interface{}{"amount": -1, "items": []interface{}{"sku-1", 2}}
+	v = NewValidator()
+	ValidateLogSchema(v, schema, log)
+	for _, pointer := range []string{"/extension", "/extension/amount", "/extension/items/1", "/actor/extension/email"} {
+		if _, ok := v.Errors[pointer]; !ok {
+			t.Errorf("Expected error at %s, got %v", pointer, v.Errors)
+		}
+	}
+	if msg := v.Errors["/extension"]; !strings.Contains(msg, "currency") {
+		t.Errorf("Expected the missing currency to be reported, got %q", msg)
+	}
+
+	// Test with a log without extension
+	v = NewValidator()
+	if ValidateLogSchema(v, schema, &model.Log{Action: "paid"}); v.Errors[""] == "" {
+		t.Errorf("Expected error at the root, got %v", v.Errors)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := &model.Schema{Action: "paid", Document: []byte(paymentSchema)}
+	v := NewValidator()
+	if ValidateSchema(v, schema); !v.Valid() {
+		t.Errorf("Expected no error, got %v", v.Errors)
+	}
+
+	tests := []struct {
+		schema *model.Schema
+		key    string
+	}{
+		{&model.Schema{Document: []byte(`{}`)}, "action"},
+		{&model.Schema{Action: "paid", Mode: "loose", Document: []byte(`{}`)}, "mode"},
+		{&model.Schema{Action: "paid"}, "schema"},
+		{&model.Schema{Action: "paid", Document: []byte(`{"type": "object", "minProperties": "two"}`)}, "schema"},
+		{&model.Schema{Action: "paid", Document: []byte(`{"$ref": "https://example.com/schema.json"}`)}, "schema"},
+	}
+	for _, tt := range tests {
+		v := NewValidator()
+		if ValidateSchema(v, tt.schema); v.Errors[tt.key] == "" {
+			t.Errorf("Expected error for %s, got %v", tt.key, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	snippetContext = 40 // characters kept on each side of a match
+	maxSnippets    = 3
+)
+
+// SearchTerms collects the string values of all the extension maps of a
+// log, which are indexed for full-text search.
+func SearchTerms(log *model.Log) []string {
+	var terms []string
+	for _, ext := range []map[string]interface{}{
+		log.Extension, log.Actor.Extension, log.Entity.Extension, log.Context.Extension,
+	} {
+		terms = collectStrings(terms, ext)
+	}
+	return terms
+}
+
+func collectStrings(dst []string, value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			dst = append(dst, value)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			dst = collectStrings(dst, value[key])
+		}
+	case primitive.M:
+		dst = collectStrings(dst, map[string]interface{}(value))
+	case primitive.D:
+		for _, e := range value {
+			dst = collectStrings(dst, e.Value)
+		}
+	case []interface{}:
+		for _, v := range value {
+			dst = collectStrings(dst, v)
+		}
+	case primitive.A:
+		dst = collectStrings(dst, []interface{}(value))
+	}
+	return dst
+}
+
+// Highlight returns snippets of the searchable content of a log in which
+// the words of the search are wrapped in <em> tags.
+func Highlight(log *model.Log, search string) []string {
+	words := searchWords(search)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var snippets []string
+	for _, text := range append([]string{log.Action, log.Entity.Type}, SearchTerms(log)...) {
+		if snippet, ok := highlight(text, words); ok {
+			snippets = append(snippets, snippet)
+			if len(snippets) == maxSnippets {
+				break
+			}
+		}
+	}
+
+	return snippets
+}
+
+// searchWords extracts the lowercased words of a search, leaving out
+// negated ones.
+func searchWords(search string) []string {
+	var words []string
+	for _, field := range strings.Fields(search) {
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(field, isSeparator) {
+			words = append(words, strings.ToLower(word))
+		}
+	}
+	return words
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight marks the words of text starting with one of the search
+// words, so that stemmed matches such as "notes" for "note" are marked
+// too, and trims the text to a window following the first match.
+func highlight(text string, words []string) (string, bool) {
+	type span struct{ start, end int }
+
+	var spans []span
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if isSeparator(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && !isSeparator(runes[j]) {
+			j++
+		}
+		word := strings.ToLower(string(runes[i:j]))
+		for _, w := range words {
+			if strings.HasPrefix(word, w) || (len(w) > 3 && strings.HasPrefix(w, word) && len(word) >= 3) {
+				spans = append(spans, span{i, j})
+				break
+			}
+		}
+		i = j
+	}
+
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	start := spans[0].start - snippetContext
+	if start < 0 {
+		start = 0
+	}
+	end := spans[0].end + 2*snippetContext
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, s := range spans {
+		if s.end > end {
+			break
+		}
+		b.WriteString(string(runes[pos:s.start]))
+		b.WriteString("<em>")
+		b.WriteString(string(runes[s.start:s.end]))
+		b.WriteString("</em>")
+		pos = s.end
+	}
+	b.WriteString(string(runes[pos:end]))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String(), true
+}
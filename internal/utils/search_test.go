@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestSearchTerms(t *testing.T) {
+	log := &model.Log{
+		Action:    "updated",
+		Actor:     model.Actor{Extension: map[string]interface{}{"email": "jane@example.com"}},
+		Entity:    model.Entity{Extension: map[string]interface{}{"item_id": "f66020564728", "count": 3.0}},
+		Context:   model.Context{Extension: map[string]interface{}{"tags": []interface{}{"a", "b"}}},
+		Extension: map[string]interface{}{"notes": "Inventory successfully updated", "meta": map[string]interface{}{"by": "ops"}},
+	}
+
+	expected := []string{"ops", "Inventory successfully updated", "jane@example.com", "f66020564728", "a", "b"}
+	if terms := SearchTerms(log); !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Expected %v, got %v", expected, terms)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	log := &model.Log{
+		Action:    "updated",
+		Entity:    model.Entity{Type: "inventory"},
+		Extension: map[string]interface{}{"notes": "Inventory successfully updated by the warehouse team after the quarterly stock count was completed"},
+	}
+
+	// Test with matching words
+	snippets := Highlight(log, "inventory")
+	expected := []string{
+		"<em>inventory</em>",
+		"<em>Inventory</em> successfully updated by the warehouse team after the quarterly stock count was …",
+	}
+	if !reflect.DeepEqual(snippets, expected) {
+		t.Errorf("Expected %q, got %q", expected, snippets)
+	}
+
+	// Test with stemmed and negated words
+	snippets = Highlight(log, "counts -inventory")
+	expected = []string{"…arehouse team after the quarterly stock <em>count</em> was completed"}
+	if !reflect.DeepEqual(snippets, expected) {
+		t.Errorf("Expected %q, got %q", expected, snippets)
+	}
+
+	// Test with no match
+	if snippets := Highlight(log, "billing"); snippets != nil {
+		t.Errorf("Expected no snippets, got %q", snippets)
+	}
+}
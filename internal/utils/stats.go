@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/IkehAkinyemi/logaudit/internal/query"
+)
+
+// StatsOptions contains the parsed aggregation parameters of
+// 'GET /v1/logs/stats?<query_string>'.
+type StatsOptions struct {
+	GroupBy  *query.Field
+	Interval string
+	Location *time.Location
+	Top      int
+}
+
+// Stats describes the aggregated counts of the logs matching a filter.
+type Stats struct {
+	Total          int           `json:"total"`
+	DistinctActors int           `json:"distinct_actors"`
+	Series         []StatsBucket `json:"series,omitempty"`
+	Groups         []StatsGroup  `json:"groups,omitempty"`
+}
+
+// A StatsBucket holds the counts of one time interval.
+type StatsBucket struct {
+	Time           time.Time `json:"time"`
+	Count          int       `json:"count"`
+	DistinctActors int       `json:"distinct_actors"`
+}
+
+// A StatsGroup holds the counts of one value of the group_by field.
+type StatsGroup struct {
+	Value          interface{}   `json:"value"`
+	Count          int           `json:"count"`
+	DistinctActors int           `json:"distinct_actors"`
+	Series         []StatsBucket `json:"series,omitempty"`
+}
+
+// intervals lists the supported bucket sizes.
+var intervals = []string{"minute", "hour", "day", "week", "month"}
+
+// intervalLengths gives the shortest length of each interval, so that the
+// buckets a time range spans are never underestimated.
+var intervalLengths = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  28 * 24 * time.Hour,
+}
+
+// maxStatsBuckets bounds the buckets a response holds, across all groups,
+// as they are all returned within a single document.
+const maxStatsBuckets = 10_000
+
+// ReadStatsOptions parses the aggregation parameters from the query string.
+func ReadStatsOptions(qs url.Values, v *Validator) StatsOptions {
+	opts := StatsOptions{
+		Interval: ReadStr(qs, "interval", ""),
+		Top:      ReadInt(qs, "top", 10, v),
+		Location: time.UTC,
+	}
+
+	if name := qs.Get("group_by"); name != "" {
+		field, ok := query.LookupField(name)
+		if ok && field.Type != query.TypeTime {
+			opts.GroupBy = &field
+		} else {
+			v.AddError("group_by", "must be a known field other than timestamp")
+		}
+	}
+
+	if tz := qs.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			v.AddError("tz", "must be an IANA time zone name")
+		} else {
+			opts.Location = loc
+		}
+	}
+
+	return opts
+}
+
+// ValidateStatsOptions validates the aggregation parameters of the logs
+// matching a filter. Time buckets are only given within a time range, and
+// at most maxStatsBuckets of them, counting those of every group.
+func ValidateStatsOptions(v *Validator, opts StatsOptions, filter Filters) {
+	v.Check(opts.Top > 0, "top", "must be greater than zero")
+	v.Check(opts.Top <= 100, "top", "must be a maximum of 100")
+
+	if opts.Interval == "" {
+		return
+	}
+	if !PermittedValue(opts.Interval, intervals...) {
+		v.AddError("interval", "must be one of minute, hour, day, week or month")
+		return
+	}
+	if filter.StartTimestamp.IsZero() {
+		v.AddError("start_timestamp", "must be provided with an interval")
+		return
+	}
+
+	end := filter.EndTimestamp
+	if end.IsZero() {
+		end = time.Now().UTC()
+	}
+	buckets := int64(end.Sub(filter.StartTimestamp)/intervalLengths[opts.Interval]) + 2
+	if opts.GroupBy != nil && opts.Top > 0 {
+		buckets *= int64(opts.Top)
+	}
+	v.Check(buckets <= maxStatsBuckets, "interval", fmt.Sprintf("must not split the time range into more than %d buckets, counting those of every group", maxStatsBuckets))
+}
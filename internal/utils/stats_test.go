@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestReadStatsOptions(t *testing.T) {
+	validator := NewValidator()
+
+	// Test with valid options
+	queryStr, _ := url.ParseQuery("group_by=entity.extension.item_id&interval=day&tz=America/New_York&top=5")
+	opts := ReadStatsOptions(queryStr, validator)
+	ValidateStatsOptions(validator, opts, Filters{StartTimestamp: time.Now().AddDate(0, -3, 0)})
+	if !validator.Valid() {
+		t.Errorf("Expected valid options, got %v", validator.Errors)
+	}
+	if opts.GroupBy == nil || opts.GroupBy.Path != "entity.extension.item_id" {
+		t.Errorf("Expected group_by entity.extension.item_id, got %+v", opts.GroupBy)
+	}
+	if opts.Location.String() != "America/New_York" || opts.Top != 5 {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+
+	// Test with invalid options
+	validator = NewValidator()
+	queryStr, _ = url.ParseQuery("group_by=timestamp&interval=fortnight&tz=Mars/Olympus&top=500")
+	opts = ReadStatsOptions(queryStr, validator)
+	ValidateStatsOptions(validator, opts, Filters{})
+	for _, key := range []string{"group_by", "interval", "tz", "top"} {
+		if _, ok := validator.Errors[key]; !ok {
+			t.Errorf("Expected error message for %s", key)
+		}
+	}
+}
+
+func TestValidateStatsBuckets(t *testing.T) {
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 3, 0)
+
+	tests := []struct {
+		query  string
+		filter Filters
+		errKey string
+	}{
+		{"interval=day", Filters{StartTimestamp: start, EndTimestamp: end}, ""},
+		{"interval=hour&group_by=action&top=2", Filters{StartTimestamp: start, EndTimestamp: end}, ""},
+		{"group_by=action", Filters{}, ""},
+		// Test that buckets are only given within a time range
+		{"interval=day", Filters{EndTimestamp: end}, "start_timestamp"},
+		// Test that the buckets of every group are counted
+		{"interval=minute", Filters{StartTimestamp: start, EndTimestamp: end}, "interval"},
+		{"interval=hour&group_by=action&top=100", Filters{StartTimestamp: start, EndTimestamp: end}, "interval"},
+	}
+
+	for _, tt := range tests {
+		v := NewValidator()
+		qs, _ := url.ParseQuery(tt.query)
+		ValidateStatsOptions(v, ReadStatsOptions(qs, v), tt.filter)
+		switch {
+		case tt.errKey == "" && !v.Valid():
+			t.Errorf("%s: expected valid options, got %v", tt.query, v.Errors)
+		case tt.errKey != "" && v.Errors[tt.errKey] == "":
+			t.Errorf("%s: expected error for %s, got %v", tt.query, tt.errKey, v.Errors)
+		}
+	}
+}
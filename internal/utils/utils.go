@@ -9,19 +9,88 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/changeset"
+	"github.com/IkehAkinyemi/logaudit/internal/query"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Config defines the requirement for server configuration.
 type Config struct {
-	Env           string
-	Port          string
-	DBConnURI     string
-	AMQP_CONN_URI string
+	Env              string
+	Port             string
+	DBConnURI        string
+	AMQP_CONN_URI    string
+	AMQPBindUser     bool
+	ExtensionIndexes []string
+	QueryJobTTL      time.Duration
+	RelatedWindow    time.Duration
+	SessionKey       string
+	SessionGap       time.Duration
+	MaxQuerySpan     time.Duration
+	QuerySpanLimits  map[model.ServiceID]time.Duration
+	StreamMaxConns   int
+	StreamBuffer     int
+	AnomalyThreshold float64
+	GeoIPCityDB      string
+	GeoIPASNDB       string
+	TravelMaxSpeed   float64
+	IPTags           map[string]string
+	UserAgentField   string
+	Processors       []Processor
+	PluginDir        string
+	PluginTimeout    time.Duration
+	PluginMemory     uint32
+	PluginReload     time.Duration
+	SchemaMode       string
+}
+
+// QuerySpan returns the longest time span the queries of a service may
+// cover, or zero if they are not limited.
+func (c *Config) QuerySpan(serviceID model.ServiceID) time.Duration {
+	if span, ok := c.QuerySpanLimits[serviceID]; ok {
+		return span
+	}
+	return c.MaxQuerySpan
+}
+
+// BuiltinProcessors lists the processors logs can go through.
+var BuiltinProcessors = []string{"ip", "user_agent", "plugins"}
+
+// A Processor is a processor logs go through before they are stored. The
+// failure of a required one rejects the log instead of being skipped.
+type Processor struct {
+	Name     string
+	Required bool
+}
+
+// ParseProcessors parses a comma-separated list of builtin processors, as
+// set by PROCESSORS, each required when suffixed with '!', e.g. "ip!". The
+// plugins are required or not on their own. "none" lists no processors.
+func ParseProcessors(names string) ([]Processor, error) {
+	processors := []Processor{}
+	if strings.TrimSpace(names) == "none" {
+		return processors, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		p := Processor{Name: strings.TrimSuffix(name, "!")}
+		p.Required = p.Name != name
+		if !PermittedValue(p.Name, BuiltinProcessors...) || seen[p.Name] || (p.Required && p.Name == "plugins") {
+			return nil, fmt.Errorf("invalid processor %q in PROCESSORS", name)
+		}
+		seen[p.Name] = true
+		processors = append(processors, p)
+	}
+	return processors, nil
 }
 
 // parseConfig retrieves the environment variables.
@@ -30,6 +99,19 @@ func ParseConfig() (*Config, error) {
 	if amqpURI == "" {
 		return nil, fmt.Errorf("no provided value for AMQP_CONN_URI")
 	}
+
+	// Whether publishers must set the user_id of their messages to their
+	// app_id. The broker checks user_id against the user a publisher
+	// connected as, so a service can then only publish as itself.
+	var amqpBindUser bool
+	if b := os.Getenv("AMQP_BIND_USER"); b != "" {
+		var err error
+		amqpBindUser, err = strconv.ParseBool(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q in AMQP_BIND_USER", b)
+		}
+	}
+
 	env := os.Getenv("ENV")
 	if env == "" {
 		env = "dev"
@@ -45,11 +127,235 @@ func ParseConfig() (*Config, error) {
 		httpPort = "8080"
 	}
 
+	// Extension paths queried often enough to deserve an index, e.g.
+	// "entity.extension.item_id,extension.amount".
+	var extensionIndexes []string
+	for _, path := range strings.Split(os.Getenv("EXTENSION_INDEXES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if field, ok := query.LookupField(path); !ok || !field.Extension {
+			return nil, fmt.Errorf("invalid extension path %q in EXTENSION_INDEXES", path)
+		}
+		extensionIndexes = append(extensionIndexes, path)
+	}
+
+	// How long query jobs and their results are kept after submission.
+	queryJobTTL := 24 * time.Hour
+	if ttl := os.Getenv("QUERY_JOB_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in QUERY_JOB_TTL", ttl)
+		}
+		queryJobTTL = d
+	}
+
+	// How far either side of a log related events are looked up by
+	// default.
+	relatedWindow := time.Hour
+	if window := os.Getenv("RELATED_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in RELATED_WINDOW", window)
+		}
+		relatedWindow = d
+	}
+
+	// Actor activity is grouped into sessions by the context extension key
+	// holding session identifiers, or else by gaps of inactivity. Set
+	// empty, activity is only grouped by gaps.
+	sessionKey, ok := os.LookupEnv("SESSION_KEY")
+	if !ok {
+		sessionKey = "session_id"
+	}
+
+	sessionGap := 30 * time.Minute
+	if gap := os.Getenv("SESSION_GAP"); gap != "" {
+		d, err := time.ParseDuration(gap)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in SESSION_GAP", gap)
+		}
+		sessionGap = d
+	}
+
+	// The longest time span queries may cover, e.g. "720h", with limits
+	// for given services overriding it, e.g. "billing=2160h,ops=0". Zero
+	// leaves queries unlimited.
+	var maxQuerySpan time.Duration
+	if span := os.Getenv("MAX_QUERY_SPAN"); span != "" {
+		d, err := time.ParseDuration(span)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q in MAX_QUERY_SPAN", span)
+		}
+		maxQuerySpan = d
+	}
+
+	querySpanLimits := make(map[model.ServiceID]time.Duration)
+	for _, limit := range strings.Split(os.Getenv("QUERY_SPAN_LIMITS"), ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+		serviceID, span, ok := strings.Cut(limit, "=")
+		d, err := time.ParseDuration(span)
+		if !ok || serviceID == "" || err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid limit %q in QUERY_SPAN_LIMITS", limit)
+		}
+		querySpanLimits[model.ServiceID(serviceID)] = d
+	}
+
+	// Live streams a service may hold open at once, and the logs buffered
+	// for each before a client that does not keep up is disconnected.
+	streamMaxConns := 5
+	if n := os.Getenv("STREAM_MAX_CONNECTIONS"); n != "" {
+		i, err := strconv.Atoi(n)
+		if err != nil || i <= 0 {
+			return nil, fmt.Errorf("invalid number %q in STREAM_MAX_CONNECTIONS", n)
+		}
+		streamMaxConns = i
+	}
+
+	streamBuffer := 256
+	if n := os.Getenv("STREAM_BUFFER"); n != "" {
+		i, err := strconv.Atoi(n)
+		if err != nil || i <= 0 {
+			return nil, fmt.Errorf("invalid number %q in STREAM_BUFFER", n)
+		}
+		streamBuffer = i
+	}
+
+	// The score, between 0 and 1, from which logs deviating from the
+	// baseline of their actor are flagged. Zero turns detection off.
+	anomalyThreshold := 0.6
+	if n := os.Getenv("ANOMALY_THRESHOLD"); n != "" {
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("invalid score %q in ANOMALY_THRESHOLD", n)
+		}
+		anomalyThreshold = f
+	}
+
+	// Local MaxMind DB files, such as GeoLite2-City and GeoLite2-ASN,
+	// logs are located with by their IP address.
+	geoIPCityDB := os.Getenv("GEOIP_CITY_DB")
+	geoIPASNDB := os.Getenv("GEOIP_ASN_DB")
+
+	// The fastest an actor may travel between two logs, in km/h.
+	travelMaxSpeed := 1000.0
+	if n := os.Getenv("TRAVEL_MAX_SPEED"); n != "" {
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid speed %q in TRAVEL_MAX_SPEED", n)
+		}
+		travelMaxSpeed = f
+	}
+
+	// Tags given to the IP addresses of logs in the networks listed, one
+	// CIDR per line, by a file, e.g. "tor-exit=/etc/logaudit/tor-exits".
+	ipTags := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("IP_TAGS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tag, path, ok := strings.Cut(entry, "=")
+		if !ok || !ViewNameRX.MatchString(tag) || path == "" {
+			return nil, fmt.Errorf("invalid tag %q in IP_TAGS", entry)
+		}
+		ipTags[tag] = path
+	}
+
+	// The field the user agents logs were recorded from are read from.
+	userAgentField := "actor.extension.userAgent"
+	if path := os.Getenv("USER_AGENT_FIELD"); path != "" {
+		field, ok := query.LookupField(path)
+		if !ok || (field.Type != query.TypeString && field.Type != query.TypeAny) || field.List {
+			return nil, fmt.Errorf("invalid field %q in USER_AGENT_FIELD", path)
+		}
+		userAgentField = path
+	}
+
+	// The processors logs go through, in order, before they are stored.
+	// "none" stores logs as they were published.
+	processors := []Processor{{Name: "ip"}, {Name: "user_agent"}, {Name: "plugins"}}
+	if names := strings.TrimSpace(os.Getenv("PROCESSORS")); names != "" {
+		var err error
+		processors, err = ParseProcessors(names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The directory WebAssembly plugins are loaded from, the time and
+	// memory, in MiB, each may use by default, and how often the directory
+	// is checked for changes.
+	pluginDir := os.Getenv("PLUGIN_DIR")
+
+	pluginTimeout := 100 * time.Millisecond
+	if timeout := os.Getenv("PLUGIN_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in PLUGIN_TIMEOUT", timeout)
+		}
+		pluginTimeout = d
+	}
+
+	pluginMemory := uint32(64)
+	if n := os.Getenv("PLUGIN_MEMORY"); n != "" {
+		i, err := strconv.ParseUint(n, 10, 32)
+		if err != nil || i == 0 || i > 4096 {
+			return nil, fmt.Errorf("invalid size %q in PLUGIN_MEMORY", n)
+		}
+		pluginMemory = uint32(i)
+	}
+
+	pluginReload := 10 * time.Second
+	if interval := os.Getenv("PLUGIN_RELOAD_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in PLUGIN_RELOAD_INTERVAL", interval)
+		}
+		pluginReload = d
+	}
+
+	// The mode logs are checked against the schemas registered for their
+	// action in, unless a schema sets its own.
+	schemaMode := SchemaWarn
+	if mode := os.Getenv("SCHEMA_MODE"); mode != "" {
+		if !PermittedValue(mode, SchemaStrict, SchemaWarn, SchemaOff) {
+			return nil, fmt.Errorf("invalid mode %q in SCHEMA_MODE", mode)
+		}
+		schemaMode = mode
+	}
+
 	return &Config{
-		Env:           env,
-		Port:          httpPort,
-		DBConnURI:     dbURI,
-		AMQP_CONN_URI: amqpURI,
+		Env:              env,
+		Port:             httpPort,
+		DBConnURI:        dbURI,
+		AMQP_CONN_URI:    amqpURI,
+		AMQPBindUser:     amqpBindUser,
+		ExtensionIndexes: extensionIndexes,
+		QueryJobTTL:      queryJobTTL,
+		RelatedWindow:    relatedWindow,
+		SessionKey:       sessionKey,
+		SessionGap:       sessionGap,
+		MaxQuerySpan:     maxQuerySpan,
+		QuerySpanLimits:  querySpanLimits,
+		StreamMaxConns:   streamMaxConns,
+		StreamBuffer:     streamBuffer,
+		AnomalyThreshold: anomalyThreshold,
+		GeoIPCityDB:      geoIPCityDB,
+		GeoIPASNDB:       geoIPASNDB,
+		TravelMaxSpeed:   travelMaxSpeed,
+		IPTags:           ipTags,
+		UserAgentField:   userAgentField,
+		Processors:       processors,
+		PluginDir:        pluginDir,
+		PluginTimeout:    pluginTimeout,
+		PluginMemory:     pluginMemory,
+		PluginReload:     pluginReload,
+		SchemaMode:       schemaMode,
 	}, nil
 }
 
@@ -138,36 +444,179 @@ func ReadStr(queryStr url.Values, key, defaultValue string) string {
 	return str
 }
 
-// ParseTime parse the query string for timestamps values.
-func ParseTime(queryStr url.Values, key string) time.Time {
+// ParseTime parses a time provided through the query string. It accepts
+// RFC3339 timestamps with optional fractional seconds, dates, Unix times
+// in seconds or milliseconds, and times relative to now such as "now-24h"
+// or "now/d". A zero time is returned when the key is missing.
+func ParseTime(queryStr url.Values, key string, v *Validator) time.Time {
 	value := queryStr.Get(key)
 	if value == "" {
 		return time.Time{}
 	}
 
-	parsedTime, err := time.Parse("2006-01-02T15:04:05Z07:00", value)
+	t, err := parseTime(value, time.Now().UTC())
 	if err != nil {
+		v.AddError(key, err.Error())
 		return time.Time{}
 	}
 
-	return parsedTime
+	return t
 }
 
-// SortValues retrieve the sort and sort direction values.
-func SortValues(queryStr url.Values, key string) (string, bool) {
-	sortField := queryStr.Get(key)
-	sortDesc := false
+// epochMillisThreshold tells Unix times in milliseconds from those in
+// seconds: as seconds, it lies in the year 5138.
+const epochMillisThreshold = 100_000_000_000
 
-	if sortField == "" {
-		return sortField, sortDesc
+// parseTime parses a time as accepted by ParseTime, relative to now.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "now") {
+		return parseRelativeTime(value[len("now"):], now)
 	}
 
-	if sortField[0] == '-' {
-		sortDesc = true
-		sortField = sortField[1:]
+	if isDigits(value) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("must be a Unix time in seconds or milliseconds")
+		}
+		if n >= epochMillisThreshold {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
 	}
 
-	return sortField, sortDesc
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC3339 timestamp, a date, a Unix time or a time relative to now such as now-24h")
+	}
+	return t, nil
+}
+
+// parseRelativeTime applies the offsets and roundings following "now" in
+// a relative time, in order, e.g. "-7d/d" for midnight a week ago. Units
+// are s, m, h, d, w, M (months) and y, and times are rounded down in UTC.
+func parseRelativeTime(expr string, now time.Time) (time.Time, error) {
+	t := now
+	for expr != "" {
+		op := expr[0]
+		expr = expr[1:]
+
+		// An unescaped '+' in a query string decodes to a space.
+		if op == ' ' {
+			op = '+'
+		}
+
+		switch op {
+		case '+', '-':
+			i := 0
+			for i < len(expr) && expr[i] >= '0' && expr[i] <= '9' {
+				i++
+			}
+			if i == 0 || i == len(expr) {
+				return time.Time{}, errors.New("must have an amount and a unit after each + or -, e.g. now-24h")
+			}
+			n, err := strconv.Atoi(expr[:i])
+			if err != nil {
+				return time.Time{}, errors.New("must have a smaller offset")
+			}
+			if op == '-' {
+				n = -n
+			}
+
+			var ok bool
+			t, ok = addUnit(t, n, expr[i])
+			if !ok {
+				return time.Time{}, fmt.Errorf("must use one of the units s, m, h, d, w, M or y, not %q", expr[i])
+			}
+			expr = expr[i+1:]
+		case '/':
+			if expr == "" {
+				return time.Time{}, errors.New("must have a unit after /, e.g. now/d")
+			}
+
+			var ok bool
+			t, ok = roundDown(t, expr[0])
+			if !ok {
+				return time.Time{}, fmt.Errorf("must use one of the units s, m, h, d, w, M or y, not %q", expr[0])
+			}
+			expr = expr[1:]
+		default:
+			return time.Time{}, errors.New("must be now followed by offsets such as -24h or roundings such as /d")
+		}
+	}
+
+	return t, nil
+}
+
+// addUnit adds n of a unit to t.
+func addUnit(t time.Time, n int, unit byte) (time.Time, bool) {
+	switch unit {
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), true
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), true
+	case 'h':
+		return t.Add(time.Duration(n) * time.Hour), true
+	case 'd':
+		return t.AddDate(0, 0, n), true
+	case 'w':
+		return t.AddDate(0, 0, 7*n), true
+	case 'M':
+		return t.AddDate(0, n, 0), true
+	case 'y':
+		return t.AddDate(n, 0, 0), true
+	default:
+		return t, false
+	}
+}
+
+// roundDown rounds t down to the start of a unit. Weeks start on Monday.
+func roundDown(t time.Time, unit byte) (time.Time, bool) {
+	t = t.UTC()
+	switch unit {
+	case 's':
+		return t.Truncate(time.Second), true
+	case 'm':
+		return t.Truncate(time.Minute), true
+	case 'h':
+		return t.Truncate(time.Hour), true
+	case 'd':
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	case 'w':
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC), true
+	case 'M':
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	case 'y':
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC), true
+	default:
+		return t, false
+	}
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ReadList parses a comma-separated list provided through the query
+// string, dropping empty items.
+func ReadList(queryStr url.Values, key string) []string {
+	var list []string
+	for _, item := range strings.Split(queryStr.Get(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 // ReadInt parses integer values provided through the query string
@@ -185,6 +634,150 @@ func ReadInt(queryStr url.Values, key string, defaultValue int, v *Validator) in
 	return intValue
 }
 
+// ReadPage parses the page of a list provided through the query string:
+// at most limit records, 50 by default and 100 at most, older than the
+// record whose ID is before, if any. Records are named by item in errors.
+func ReadPage(queryStr url.Values, item string, v *Validator) (before primitive.ObjectID, limit int) {
+	limit = ReadInt(queryStr, "limit", 50, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	if str := queryStr.Get("before"); str != "" {
+		id, err := primitive.ObjectIDFromHex(str)
+		v.Check(err == nil, "before", "must be the id of "+item)
+		before = id
+	}
+	return before, limit
+}
+
+// ReadDuration parses durations such as "30m" or "2h" provided through
+// the query string.
+func ReadDuration(queryStr url.Values, key string, defaultValue time.Duration, v *Validator) time.Duration {
+	str := queryStr.Get(key)
+	if str == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		v.AddError(key, "must be a duration such as 30m or 2h")
+		return defaultValue
+	}
+
+	return d
+}
+
+// ReadCursor decodes a pagination cursor provided through the query string.
+func ReadCursor(queryStr url.Values, key string, v *Validator) *Cursor {
+	token := queryStr.Get(key)
+	if token == "" {
+		return nil
+	}
+
+	cursor, err := DecodeCursor(token)
+	if err != nil {
+		v.AddError(key, "must be a cursor returned by a previous request")
+		return nil
+	}
+
+	return cursor
+}
+
+// ReadIDParam retrieves the "id" URL parameter of a request.
+func ReadIDParam(r *http.Request) (primitive.ObjectID, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+// ReadQuery parses a filter expression provided through the query string.
+func ReadQuery(queryStr url.Values, key string, v *Validator) query.Expr {
+	str := queryStr.Get(key)
+	if str == "" {
+		return nil
+	}
+
+	expr, err := query.Parse(str)
+	if err != nil {
+		v.AddError(key, err.Error())
+		return nil
+	}
+
+	return expr
+}
+
+// ReadExtensionFilters parses conditions on extension fields given
+// directly as query parameters, such as "entity.extension.item_id=f66020564728"
+// or "extension.amount>100". As the operator ends up in the parameter name,
+// it is read from there, and the value is compared as a literal. The
+// conditions are combined with AND.
+func ReadExtensionFilters(queryStr url.Values, v *Validator) query.Expr {
+	keys := make([]string, 0, len(queryStr))
+	for key := range queryStr {
+		if query.IsExtensionPath(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var exprs []query.Expr
+	for _, key := range keys {
+		for _, value := range queryStr[key] {
+			name, op, value := splitCondition(key, value)
+
+			var expr query.Expr
+			var err error
+			switch {
+			case op == "":
+				err = errors.New("unknown operator")
+			case value == "":
+				err = errors.New("must be compared with a value")
+			default:
+				expr, err = query.Condition(name, op, value)
+			}
+			if err != nil {
+				v.AddError(name, err.Error())
+				continue
+			}
+			exprs = append(exprs, expr)
+		}
+	}
+
+	return query.AndOf(exprs...)
+}
+
+// splitCondition splits a condition given as a query parameter into the
+// field, operator and value it compares. The name of the parameter holds
+// the field and, but for equality, the operator, along with the value for
+// "<" and ">": "extension.total>=5" is read as the parameter
+// "extension.total>" of value "5", and "extension.total>5" as the
+// parameter "extension.total>5" without value. The operator is empty
+// when unknown.
+func splitCondition(key, value string) (string, query.Op, string) {
+	i := strings.IndexAny(key, "=!<>")
+	if i < 0 {
+		return key, query.OpEq, value
+	}
+
+	name, rest := key[:i], key[i:]
+	switch {
+	case rest == "!":
+		return name, query.OpNe, value
+	case rest == "<":
+		return name, query.OpLte, value
+	case rest == ">":
+		return name, query.OpGte, value
+	case value == "" && (rest[0] == '<' || rest[0] == '>') && !strings.ContainsAny(rest[1:2], "=!<>"):
+		return name, query.Op(rest[:1]), rest[1:]
+	default:
+		return name, "", value
+	}
+}
+
 // A Validator defines a custom type for validation.
 type Validator struct {
 	Errors map[string]string
@@ -216,6 +809,16 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// PermittedValue reports whether value is one of the permitted values.
+func PermittedValue(value string, permittedValues ...string) bool {
+	for _, permitted := range permittedValues {
+		if value == permitted {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidateTokenPlaintext checks that the plaintext token was provided and is exactly 26 bytes long.
 func ValidateTokenPlaintext(v *Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "key", "must be provided")
@@ -229,6 +832,11 @@ func ValidateLog(v *Validator, log *model.Log) {
 	v.Check(log.Actor.ID != "", "actor.id", "must be provided")
 	v.Check(log.Actor.Type != "", "action.type", "must be provided")
 	v.Check(log.Entity.Type != "", "entity.type", "must be provided")
+	v.Check(len(log.Entity.ID) <= 255, "entity.id", "must not be more than 255 bytes long")
+	v.Check(strings.TrimSpace(log.Entity.ID) == log.Entity.ID, "entity.id", "must not have leading or trailing whitespace")
+	if err := changeset.Validate(log.Changes); err != nil {
+		v.AddError("changes", err.Error())
+	}
 	v.Check(net.ParseIP(log.Context.IPAddr) != nil, "context.ip_address", "not a valid IP address")
 	v.Check(log.Context.Location != "", "context.location", "must be provided")
 }
@@ -240,4 +848,35 @@ func ValidateFilters(v *Validator, f Filters) {
 
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	v.Check(len(f.Search) <= 512, "search", "must not be more than 512 bytes long")
+	for _, key := range f.Sort {
+		v.Check(key.Field != "score" || f.Search != "", "sort", "score can only be sorted on with a search")
+	}
+
+	if f.Cursor != nil {
+		v.Check(SameSort(f.Cursor.Sort, f.Sort), "cursor", "does not match the requested sort")
+	}
+
+	for _, facet := range f.Facets {
+		v.Check(PermittedValue(facet, facetFields...), "facets", facetMessage)
+	}
+
+	if !f.StartTimestamp.IsZero() && !f.EndTimestamp.IsZero() {
+		v.Check(!f.EndTimestamp.Before(f.StartTimestamp), "end_timestamp", "must not be before start_timestamp")
+	}
+
+	if f.MaxSpan > 0 {
+		end := f.EndTimestamp
+		if end.IsZero() {
+			end = time.Now().UTC()
+		}
+
+		switch {
+		case f.StartTimestamp.IsZero():
+			v.AddError("start_timestamp", fmt.Sprintf("must be provided, as queries may span at most %s", f.MaxSpan))
+		case end.Sub(f.StartTimestamp) > f.MaxSpan:
+			v.AddError("start_timestamp", fmt.Sprintf("must be at most %s before end_timestamp", f.MaxSpan))
+		}
+	}
 }
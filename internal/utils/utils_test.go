@@ -2,14 +2,20 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/IkehAkinyemi/logaudit/internal/query"
 	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestWriteJSON(t *testing.T) {
@@ -113,47 +119,97 @@ func TestParseTime(t *testing.T) {
 	queryStr.Add("key", "2022-01-01T00:00:00Z")
 
 	// Test with valid time string in query string
-	result := ParseTime(queryStr, "key")
-	expected, _ := time.Parse("2006-01-02T15:04:05Z07:00", "2022-01-01T00:00:00Z")
-	if result != expected {
+	validator := NewValidator()
+	result := ParseTime(queryStr, "key", validator)
+	expected := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !validator.Valid() || !result.Equal(expected) {
 		t.Errorf("Expected '%v', got '%v'", expected, result)
 	}
 
 	// Test with invalid time string in query string
 	queryStr.Set("key", "invalid-time")
-	result = ParseTime(queryStr, "key")
-	if !result.IsZero() {
-		t.Errorf("Expected zero time, got '%v'", result)
+	result = ParseTime(queryStr, "key", validator)
+	if _, ok := validator.Errors["key"]; !ok || !result.IsZero() {
+		t.Errorf("Expected zero time and error message, got '%v'", result)
 	}
 
 	// Test with missing key in query string
-	result = ParseTime(queryStr, "missing")
-	if !result.IsZero() {
+	validator = NewValidator()
+	result = ParseTime(queryStr, "missing", validator)
+	if !validator.Valid() || !result.IsZero() {
 		t.Errorf("Expected zero time, got '%v'", result)
 	}
+
+	// Test with each accepted format, relative to a Wednesday
+	now := time.Date(2023, 7, 12, 15, 4, 5, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2023-07-01T10:00:00.123456789+01:00": time.Date(2023, 7, 1, 9, 0, 0, 123456789, time.UTC),
+		"2023-07-01":                          time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC),
+		"1688169600":                          time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC),
+		"1688169600500":                       time.Date(2023, 7, 1, 0, 0, 0, 500_000_000, time.UTC),
+		"now":                                 now,
+		"now-24h":                             now.Add(-24 * time.Hour),
+		"now 1h":                              now.Add(time.Hour),
+		"now/d":                               time.Date(2023, 7, 12, 0, 0, 0, 0, time.UTC),
+		"now-1d/d":                            time.Date(2023, 7, 11, 0, 0, 0, 0, time.UTC),
+		"now/w":                               time.Date(2023, 7, 10, 0, 0, 0, 0, time.UTC),
+		"now-1M/M":                            time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for value, expected := range tests {
+		result, err := parseTime(value, now)
+		if err != nil || !result.Equal(expected) {
+			t.Errorf("Expected '%v' for %q, got '%v' (%v)", expected, value, result, err)
+		}
+	}
+
+	for _, value := range []string{"2023-07-01T10:00:00", "2023-13-01", "now-", "now-24", "now-1q", "now/", "now*2", "yesterday"} {
+		if _, err := parseTime(value, now); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
 }
 
-func TestSortValues(t *testing.T) {
+func TestReadSort(t *testing.T) {
 	queryStr := url.Values{}
-	queryStr.Add("sort", "field")
+	queryStr.Add("sort", "-timestamp,actor.id,context.ip_address")
 
-	// Test with valid sort field in query string
-	sortField, sortDesc := SortValues(queryStr, "sort")
-	if sortField != "field" || sortDesc != false {
-		t.Errorf("Expected 'field' and false, got '%s' and %v", sortField, sortDesc)
+	// Test with valid sort fields in query string
+	validator := NewValidator()
+	keys := ReadSort(queryStr, "sort", validator)
+	expected := []SortKey{{Field: "timestamp", Descending: true}, {Field: "actor.id"}, {Field: "context.ipaddr"}}
+	if !validator.Valid() || !SameSort(keys, expected) {
+		t.Errorf("Expected %v, got %v (%v)", expected, keys, validator.Errors)
 	}
 
-	// Test with valid sort field and sort direction in query string
-	queryStr.Set("sort", "-field")
-	sortField, sortDesc = SortValues(queryStr, "sort")
-	if sortField != "field" || sortDesc != true {
-		t.Errorf("Expected 'field' and true, got '%s' and %v", sortField, sortDesc)
+	// Test with missing key in query string
+	if keys := ReadSort(queryStr, "missing", validator); len(keys) != 0 {
+		t.Errorf("Expected no sort keys, got %v", keys)
 	}
 
-	// Test with missing key in query string
-	sortField, sortDesc = SortValues(queryStr, "missing")
-	if sortField != "" || sortDesc != false {
-		t.Errorf("Expected '' and false, got '%s' and %v", sortField, sortDesc)
+	// Test with unknown, unsortable and repeated fields in query string
+	for _, sort := range []string{"-unknown", "extension.amount", "actor.id,-actor.id", "action,actor.type,actor.id,entity.type,entity.id"} {
+		validator = NewValidator()
+		queryStr.Set("sort", sort)
+		ReadSort(queryStr, "sort", validator)
+		if _, ok := validator.Errors["sort"]; !ok {
+			t.Errorf("Expected error message for sort %q", sort)
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	queryStr := url.Values{}
+	queryStr.Add("facets", "action, entity.type,,")
+
+	// Test with list in query string
+	list := ReadList(queryStr, "facets")
+	if len(list) != 2 || list[0] != "action" || list[1] != "entity.type" {
+		t.Errorf("Expected [action entity.type], got %q", list)
+	}
+
+	// Test with missing list in query string
+	if list := ReadList(queryStr, "missing"); list != nil {
+		t.Errorf("Expected nil list, got %q", list)
 	}
 }
 
@@ -185,6 +241,136 @@ func TestReadInt(t *testing.T) {
 	}
 }
 
+func TestReadDuration(t *testing.T) {
+	validator := NewValidator()
+	queryStr := url.Values{}
+	queryStr.Add("window", "90m")
+
+	// Test with valid duration in query string
+	if d := ReadDuration(queryStr, "window", time.Hour, validator); d != 90*time.Minute {
+		t.Errorf("Expected 1h30m0s, got %s", d)
+	}
+
+	// Test with missing duration in query string
+	if d := ReadDuration(queryStr, "missing", time.Hour, validator); d != time.Hour {
+		t.Errorf("Expected default 1h0m0s, got %s", d)
+	}
+
+	// Test with invalid duration in query string
+	queryStr.Set("window", "a while")
+	if d := ReadDuration(queryStr, "window", time.Hour, validator); d != time.Hour {
+		t.Errorf("Expected default 1h0m0s, got %s", d)
+	}
+	if _, ok := validator.Errors["window"]; !ok {
+		t.Errorf("Expected error message for window")
+	}
+}
+
+func TestReadCursor(t *testing.T) {
+	validator := NewValidator()
+	token, _ := EncodeCursor(Cursor{ID: primitive.NewObjectID()})
+
+	queryStr := url.Values{}
+	queryStr.Add("cursor", token)
+
+	// Test with valid cursor in query string
+	if cursor := ReadCursor(queryStr, "cursor", validator); cursor == nil {
+		t.Errorf("Expected cursor")
+	}
+
+	// Test with invalid cursor in query string
+	queryStr.Set("cursor", "invalid")
+	if cursor := ReadCursor(queryStr, "cursor", validator); cursor != nil {
+		t.Errorf("Expected nil cursor, got %+v", cursor)
+	}
+	if _, ok := validator.Errors["cursor"]; !ok {
+		t.Errorf("Expected error message for cursor")
+	}
+}
+
+func TestReadIDParam(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	// Test with valid id parameter
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	params := httprouter.Params{{Key: "id", Value: id.Hex()}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+	if got, err := ReadIDParam(r); err != nil || got != id {
+		t.Errorf("Expected id %s, got %s (%v)", id.Hex(), got.Hex(), err)
+	}
+
+	// Test with invalid id parameter
+	params = httprouter.Params{{Key: "id", Value: "invalid"}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+	if _, err := ReadIDParam(r); err == nil {
+		t.Errorf("Expected error for invalid id")
+	}
+}
+
+func TestReadExtensionFilters(t *testing.T) {
+	validator := NewValidator()
+
+	queryStr, _ := url.ParseQuery("entity.extension.item_id=f66020564728&extension.amount>100&extension.total>=5&action=created")
+
+	// Test with conditions given as query parameters
+	expr := ReadExtensionFilters(queryStr, validator)
+	expected := "entity.extension.item_id = 'f66020564728' AND extension.amount > 100 AND extension.total >= 5"
+	if expr == nil || expr.String() != expected {
+		t.Errorf("Expected '%s', got '%v'", expected, expr)
+	}
+	if !validator.Valid() {
+		t.Errorf("Expected no errors, got %v", validator.Errors)
+	}
+
+	// Test with invalid condition
+	queryStr, _ = url.ParseQuery("extension.amount>")
+	if expr := ReadExtensionFilters(queryStr, validator); expr != nil {
+		t.Errorf("Expected nil expression, got '%v'", expr)
+	}
+	if _, ok := validator.Errors["extension.amount"]; !ok {
+		t.Errorf("Expected error message for extension.amount")
+	}
+
+	// Test that values are compared as literals, never parsed as
+	// expressions
+	queryStr = url.Values{
+		"extension.note":         {"two words"},
+		"extension.code":         {"x OR action EXISTS"},
+		"extension.quote!":       {`it's "here"`},
+		"extension.ref:string":   {"100"},
+		"entity.extension.label": {`'42'`},
+	}
+	validator = NewValidator()
+	expr = ReadExtensionFilters(queryStr, validator)
+	if !validator.Valid() {
+		t.Fatalf("Expected no errors, got %v", validator.Errors)
+	}
+	and, ok := expr.(query.And)
+	if !ok || len(and.Exprs) != len(queryStr) {
+		t.Fatalf("Expected %d comparisons, got '%v'", len(queryStr), expr)
+	}
+	values := map[string]interface{}{
+		"entity.extension.label": "42",
+		"extension.code":         "x OR action EXISTS",
+		"extension.note":         "two words",
+		"extension.quote":        `it's "here"`,
+		"extension.ref":          "100",
+	}
+	for _, e := range and.Exprs {
+		c, ok := e.(query.Compare)
+		if !ok {
+			t.Errorf("Expected a comparison, got '%v'", e)
+			continue
+		}
+		if c.Value != values[c.Field.Name] {
+			t.Errorf("Expected %s to be compared with %q, got %#v", c.Field.Name, values[c.Field.Name], c.Value)
+		}
+	}
+	if c := and.Exprs[3].(query.Compare); c.Op != query.OpNe {
+		t.Errorf("Expected extension.quote to be compared with !=, got %s", c.Op)
+	}
+}
+
 func TestValidator(t *testing.T) {
 	validator := NewValidator()
 
@@ -287,6 +473,32 @@ func TestValidateLog(t *testing.T) {
 	if _, ok := validator.Errors["context.ip_address"]; !ok {
 		t.Errorf("Expected error message for context.ip_address")
 	}
+
+	// Test with valid entity id
+	validator = NewValidator()
+	log.Context.IPAddr = "127.0.0.1"
+	log.Entity.ID = "invoice-123"
+	ValidateLog(validator, log)
+	if !validator.Valid() {
+		t.Errorf("Expected valid log, got %v", validator.Errors)
+	}
+
+	// Test with invalid entity id
+	validator = NewValidator()
+	log.Entity.ID = " invoice-123"
+	ValidateLog(validator, log)
+	if _, ok := validator.Errors["entity.id"]; !ok {
+		t.Errorf("Expected error message for entity.id")
+	}
+
+	// Test with invalid changes
+	validator = NewValidator()
+	log.Entity.ID = "invoice-123"
+	log.Changes = []model.Change{{Path: "status", Before: "draft", After: "draft"}}
+	ValidateLog(validator, log)
+	if _, ok := validator.Errors["changes"]; !ok {
+		t.Errorf("Expected error message for changes")
+	}
 }
 
 func TestValidateFilters(t *testing.T) {
@@ -334,4 +546,164 @@ func TestValidateFilters(t *testing.T) {
 	if _, ok := validator.Errors["page_size"]; !ok {
 		t.Errorf("Expected error message for page_size")
 	}
+
+	// Test with cursor issued for a different sort
+	validator = NewValidator()
+	filters.PageSize = 50
+	filters.Sort = []SortKey{{Field: "timestamp"}}
+	filters.Cursor = &Cursor{Sort: []SortKey{{Field: "timestamp", Descending: true}}, Values: []interface{}{nil}}
+	ValidateFilters(validator, filters)
+	if _, ok := validator.Errors["cursor"]; !ok {
+		t.Errorf("Expected error message for cursor")
+	}
+
+	// Test with score sort without a search
+	validator = NewValidator()
+	filters.Cursor = nil
+	filters.Sort = []SortKey{{Field: "score", Descending: true}}
+	ValidateFilters(validator, filters)
+	if _, ok := validator.Errors["sort"]; !ok {
+		t.Errorf("Expected error message for sort")
+	}
+
+	// Test with end before start
+	validator = NewValidator()
+	filters.Sort = nil
+	filters.StartTimestamp = time.Now().Add(-time.Hour)
+	filters.EndTimestamp = time.Now().Add(-2 * time.Hour)
+	ValidateFilters(validator, filters)
+	if _, ok := validator.Errors["end_timestamp"]; !ok {
+		t.Errorf("Expected error message for end_timestamp")
+	}
+
+	// Test with span longer than allowed, and with no start
+	validator = NewValidator()
+	filters.MaxSpan = 24 * time.Hour
+	filters.StartTimestamp = time.Now().Add(-48 * time.Hour)
+	filters.EndTimestamp = time.Time{}
+	ValidateFilters(validator, filters)
+	if _, ok := validator.Errors["start_timestamp"]; !ok {
+		t.Errorf("Expected error message for start_timestamp")
+	}
+
+	validator = NewValidator()
+	filters.StartTimestamp = time.Time{}
+	ValidateFilters(validator, filters)
+	if _, ok := validator.Errors["start_timestamp"]; !ok {
+		t.Errorf("Expected error message for start_timestamp")
+	}
+	filters.MaxSpan = 0
+
+	// Test with unknown facet
+	validator = NewValidator()
+	filters.Sort = nil
+	filters.Facets = []string{"action", "extension.amount"}
+	ValidateFilters(validator, filters)
+	if msg := validator.Errors["facets"]; !strings.Contains(msg, "user_agent.device") {
+		t.Errorf("Expected error message listing the facets, got %q", msg)
+	}
+
+	// Test with the facets of enriched fields
+	validator = NewValidator()
+	filters.Facets = []string{"ip.country", "ip.asn", "user_agent.browser", "user_agent.os"}
+	if ValidateFilters(validator, filters); !validator.Valid() {
+		t.Errorf("Expected no error, got %v", validator.Errors)
+	}
+}
+
+func TestFiltersMatch(t *testing.T) {
+	log := &model.Log{
+		Timestamp: time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC),
+		Action:    "billed",
+		Actor:     model.Actor{Type: "user", ID: "12300"},
+		Entity:    model.Entity{Type: "invoice", ID: "123"},
+		Extension: map[string]interface{}{"amount": 150.0},
+		ServiceID: "billing",
+	}
+
+	expr, err := query.Parse("extension.amount > 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test with matching filters
+	filters := Filters{
+		Action:         "billed",
+		ActorID:        "12300",
+		EntityType:     "invoice",
+		StartTimestamp: time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC),
+		Query:          expr,
+		ServiceID:      "billing",
+	}
+	if !filters.Match(log) {
+		t.Errorf("Expected log to match")
+	}
+
+	// Test with filters the log fails
+	tests := map[string]Filters{
+		"action":        {Action: "created"},
+		"entity id":     {EntityID: "456"},
+		"end timestamp": {EndTimestamp: time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)},
+		"query":         {Query: query.Not{Expr: expr}},
+		"service":       {ServiceID: "orders"},
+	}
+	for name, filters := range tests {
+		if filters.Match(log) {
+			t.Errorf("Expected log not to match on %s", name)
+		}
+	}
+}
+
+func TestParseProcessors(t *testing.T) {
+	// Test with valid processors
+	processors, err := ParseProcessors("user_agent, ip!,plugins")
+	if err != nil {
+		t.Fatalf("Expected valid processors, got %v", err)
+	}
+	expected := []Processor{{Name: "user_agent"}, {Name: "ip", Required: true}, {Name: "plugins"}}
+	if !reflect.DeepEqual(processors, expected) {
+		t.Errorf("Expected %v, got %v", expected, processors)
+	}
+
+	processors, err = ParseProcessors("none")
+	if err != nil || len(processors) != 0 {
+		t.Errorf("Expected no processors, got %v, %v", processors, err)
+	}
+
+	// Test with invalid processors
+	for _, names := range []string{"ip,geo", "ip,ip!", "plugins!", "ip,", "IP"} {
+		if _, err := ParseProcessors(names); err == nil {
+			t.Errorf("Expected error for %q", names)
+		}
+	}
+}
+
+func TestReadPage(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	// Test with defaults
+	v := NewValidator()
+	before, limit := ReadPage(url.Values{}, "an alert", v)
+	if !v.Valid() || !before.IsZero() || limit != 50 {
+		t.Errorf("Expected default page, got %s, %d, %v", before.Hex(), limit, v.Errors)
+	}
+
+	// Test with a valid page
+	v = NewValidator()
+	before, limit = ReadPage(url.Values{"before": {id.Hex()}, "limit": {"100"}}, "an alert", v)
+	if !v.Valid() || before != id || limit != 100 {
+		t.Errorf("Expected page before %s of 100, got %s, %d, %v", id.Hex(), before.Hex(), limit, v.Errors)
+	}
+
+	// Test with an invalid page
+	for _, qs := range []url.Values{{"limit": {"0"}}, {"limit": {"101"}}, {"limit": {"ten"}}, {"before": {"abc"}}} {
+		v = NewValidator()
+		ReadPage(qs, "an alert", v)
+		if v.Valid() {
+			t.Errorf("Expected error for %v", qs)
+		}
+	}
+	if v.Errors["before"] != "must be the id of an alert" {
+		t.Errorf("Unexpected error message %q", v.Errors["before"])
+	}
 }
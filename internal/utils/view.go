@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+// ViewNameRX matches the names views can be saved under, which appear in
+// URLs.
+var ViewNameRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ViewValues returns the query string a view stands for, with the given
+// overrides replacing the saved parameters of the same name. A cursor is a
+// position in the results of a request, so it is only ever taken from the
+// overrides, as on 'GET /v1/logs', and never saved.
+func ViewValues(params map[string]string, overrides url.Values) url.Values {
+	qs := make(url.Values, len(params)+len(overrides))
+	for key, value := range params {
+		if key == "cursor" {
+			continue
+		}
+		qs.Set(key, value)
+	}
+	for key, values := range overrides {
+		qs[key] = values
+	}
+	return qs
+}
+
+// ValidateView validates a view, checking its parameters the same way as
+// those of 'GET /v1/logs?<query_string>'. Errors about the parameters are
+// keyed by "params." and the parameter name.
+func ValidateView(v *Validator, view *model.View) {
+	v.Check(ViewNameRX.MatchString(view.Name), "name", "must be 1 to 64 lowercase letters, digits, '-' or '_'")
+	v.Check(len(view.Description) <= 500, "description", "must not be more than 500 bytes long")
+	v.Check(len(view.Params) > 0, "params", "must be provided")
+
+	_, ok := view.Params["cursor"]
+	v.Check(!ok, "params.cursor", "cannot be saved")
+
+	pv := NewValidator()
+	ValidateFilters(pv, ReadFilters(ViewValues(view.Params, nil), pv))
+	for key, msg := range pv.Errors {
+		v.AddError("params."+key, msg)
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestViewValues(t *testing.T) {
+	params := map[string]string{"action": "billed", "sort": "-timestamp"}
+	overrides := url.Values{"sort": {"timestamp"}, "page_size": {"50"}}
+
+	// Test that overrides replace saved parameters
+	qs := ViewValues(params, overrides)
+	if qs.Get("action") != "billed" || qs.Get("sort") != "timestamp" || qs.Get("page_size") != "50" {
+		t.Errorf("Unexpected query string %v", qs)
+	}
+	if params["sort"] != "-timestamp" {
+		t.Errorf("Expected saved parameters to be left untouched")
+	}
+
+	// Test that cursors are only taken from the overrides
+	params["cursor"] = "saved"
+	if qs := ViewValues(params, nil); qs.Has("cursor") {
+		t.Errorf("Expected saved cursor to be ignored, got %v", qs)
+	}
+	if qs := ViewValues(params, url.Values{"cursor": {"given"}}); qs.Get("cursor") != "given" {
+		t.Errorf("Expected cursor override, got %v", qs)
+	}
+}
+
+func TestValidateView(t *testing.T) {
+	validator := NewValidator()
+
+	// Test with valid view
+	view := &model.View{
+		Name:   "q3-billing",
+		Params: map[string]string{"action": "billed", "sort": "-timestamp", "q": "extension.amount > 100"},
+	}
+	ValidateView(validator, view)
+	if !validator.Valid() {
+		t.Errorf("Expected valid view, got %v", validator.Errors)
+	}
+
+	// Test with invalid view
+	validator = NewValidator()
+	view = &model.View{
+		Name:   "Q3 billing",
+		Params: map[string]string{"page_size": "500", "cursor": "abc", "q": "amount >"},
+	}
+	ValidateView(validator, view)
+	for _, key := range []string{"name", "params.page_size", "params.cursor", "params.q"} {
+		if _, ok := validator.Errors[key]; !ok {
+			t.Errorf("Expected error message for %s", key)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/url"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+	"github.com/IkehAkinyemi/logaudit/internal/webhook"
+)
+
+// webhookFilterParams lists the parameters of 'GET /v1/logs' that make no
+// sense for logs notified as they are stored.
+var webhookFilterParams = []string{
+	"start_timestamp", "end_timestamp", "search", "sort", "facets",
+	"fields", "page", "page_size", "cursor",
+}
+
+// WebhookFilters returns the filter criteria the stored logs notified to a
+// webhook have to match, restricted to the logs visible to its owner.
+func WebhookFilters(hook *model.Webhook) Filters {
+	filter := ReadFilters(ViewValues(hook.Filter, nil), NewValidator())
+	filter.ServiceID = hook.Owner
+	return filter
+}
+
+// ValidateWebhook validates a webhook. Its filter holds parameters of
+// 'GET /v1/logs?<query_string>', errors about which are keyed by "filter."
+// and the parameter name.
+func ValidateWebhook(v *Validator, hook *model.Webhook) {
+	u, err := url.Parse(hook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	if err == nil {
+		v.Check(webhook.CheckHost(u.Hostname()) == nil, "url", "must be on the public internet")
+	}
+	v.Check(len(hook.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+
+	v.Check(len(hook.Events) > 0, "events", "must be provided")
+	seen := make(map[string]bool, len(hook.Events))
+	for _, event := range hook.Events {
+		v.Check(PermittedValue(event, webhook.Events...), "events", "unknown event "+event)
+		v.Check(!seen[event], "events", "must not contain duplicate values")
+		seen[event] = true
+	}
+
+	for _, key := range webhookFilterParams {
+		_, ok := hook.Filter[key]
+		v.Check(!ok, "filter."+key, "cannot be used in a webhook filter")
+	}
+
+	fv := NewValidator()
+	ValidateFilters(fv, ReadFilters(ViewValues(hook.Filter, nil), fv))
+	for key, msg := range fv.Errors {
+		v.AddError("filter."+key, msg)
+	}
+}
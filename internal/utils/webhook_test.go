@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/IkehAkinyemi/logaudit/internal/repository/model"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	validator := NewValidator()
+
+	// Test with valid webhooks
+	for _, hook := range []*model.Webhook{
+		{URL: "https://hooks.example.com/logaudit", Events: []string{"alert.raised"}},
+		{URL: "http://93.184.216.34:9000/", Events: []string{"log.stored", "alert.raised"}, Filter: map[string]string{"action": "deactivated", "q": "actor.type = user"}},
+	} {
+		ValidateWebhook(validator, hook)
+		if !validator.Valid() {
+			t.Errorf("Expected valid webhook %s, got %v", hook.URL, validator.Errors)
+		}
+	}
+
+	// Test with invalid webhook
+	validator = NewValidator()
+	ValidateWebhook(validator, &model.Webhook{
+		URL:    "ftp://hooks.example.com",
+		Events: []string{"log.stored", "log.deleted", "log.stored"},
+		Filter: map[string]string{"q": "action =", "sort": "-timestamp"},
+	})
+	for _, key := range []string{"url", "events", "filter.q", "filter.sort"} {
+		if _, ok := validator.Errors[key]; !ok {
+			t.Errorf("Expected error message for %s", key)
+		}
+	}
+
+	// Test with receivers off the public internet
+	for _, u := range []string{
+		"http://localhost:9000/",
+		"http://api.localhost/",
+		"http://127.0.0.1/",
+		"https://10.0.0.12/hook",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:8080/",
+		"http://[fd00:ec2::254]/",
+		"http://0.0.0.0/",
+	} {
+		validator = NewValidator()
+		ValidateWebhook(validator, &model.Webhook{URL: u, Events: []string{"log.stored"}})
+		if _, ok := validator.Errors["url"]; !ok {
+			t.Errorf("Expected error message for url %s", u)
+		}
+	}
+
+	// Test that filters are restricted to the logs visible to the owner
+	filter := WebhookFilters(&model.Webhook{Owner: "billing", Filter: map[string]string{"action": "refunded"}})
+	if filter.ServiceID != "billing" || filter.Action != "refunded" {
+		t.Errorf("Unexpected filters %+v", filter)
+	}
+}
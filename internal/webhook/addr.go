@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for receivers that are not on the public
+// internet, which notifications are never sent to.
+var ErrPrivateAddress = errors.New("webhook: receiver address is not public")
+
+// reserved lists the networks, apart from the loopback, private and
+// link-local ones, that are not reachable on the public internet.
+var reserved = parseCIDRs(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // shared address space
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, and broadcast
+	"2001:db8::/32",   // documentation
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// PublicIP reports whether an address is on the public internet, unlike
+// the loopback, private, link-local, such as the 169.254.169.254 of cloud
+// metadata services, and other reserved addresses.
+func PublicIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost returns ErrPrivateAddress when the host of a URL is known not
+// to be on the public internet: a non-public address, or localhost. Other
+// host names are only checked once resolved, when connecting.
+func CheckHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if ip != nil && !PublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// NewTransport returns the transport notifications are sent through. It
+// only connects to public addresses, checked once host names are resolved
+// so that DNS cannot point receivers at internal services, and never
+// through a proxy, which would connect on its behalf.
+func NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
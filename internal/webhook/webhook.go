@@ -0,0 +1,151 @@
+// Package webhook signs and sends the notifications posted to the URLs
+// services register.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events that can be subscribed to.
+const (
+	EventAlertRaised     = "alert.raised"
+	EventAnomalyDetected = "anomaly.detected"
+	EventLogStored       = "log.stored"
+)
+
+// Events lists the events that can be subscribed to.
+var Events = []string{EventAlertRaised, EventAnomalyDetected, EventLogStored}
+
+// Headers set on notifications.
+const (
+	HeaderEvent     = "X-Logaudit-Event"
+	HeaderDelivery  = "X-Logaudit-Delivery"
+	HeaderSignature = "X-Logaudit-Signature"
+)
+
+const (
+	// MaxAttempts bounds the attempts made to deliver a notification.
+	MaxAttempts = 8
+	// baseBackoff is the delay before the first retry, doubled for each
+	// retry after it.
+	baseBackoff = 30 * time.Second
+	// maxBackoff bounds the delay between two attempts.
+	maxBackoff = 6 * time.Hour
+)
+
+// NewSecret returns a random secret to sign notifications with.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of a payload sent at a time: the Unix
+// time and the hex-encoded HMAC-SHA256, keyed by the secret, of the time
+// and the payload joined by a dot, e.g. "t=1690000000,v1=5257a8...".
+func Sign(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, mac(secret, timestamp, payload))
+}
+
+func mac(secret, timestamp string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the signature header of a payload, as receivers would,
+// rejecting signatures made more than tolerance before now.
+func Verify(secret, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return errors.New("webhook: malformed signature header")
+	}
+	if now.Sub(time.Unix(sec, 0)) > tolerance {
+		return errors.New("webhook: signature too old")
+	}
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, payload))) {
+		return errors.New("webhook: signature mismatch")
+	}
+
+	return nil
+}
+
+// A Notification is a payload to post to a URL.
+type Notification struct {
+	ID      string // ID of the delivery
+	Event   string
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+// Send posts a notification, signed at the current time. Receivers
+// acknowledge it with a 2xx status; any other status is returned along
+// with an error.
+func Send(ctx context.Context, client *http.Client, n Notification) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(n.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "logaudit-webhooks/1.0")
+	req.Header.Set(HeaderEvent, n.Event)
+	req.Header.Set(HeaderDelivery, n.ID)
+	req.Header.Set(HeaderSignature, Sign(n.Secret, time.Now(), n.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Backoff returns the delay before the next attempt after the given
+// number of failed attempts.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"event":"alert.raised"}`)
+	now := time.Unix(1690000000, 0)
+
+	// Test that signatures verify with the secret they were made with
+	header := Sign("whsec_test", now, payload)
+	if !strings.HasPrefix(header, "t=1690000000,v1=") {
+		t.Errorf("Unexpected signature header %q", header)
+	}
+	if err := Verify("whsec_test", header, payload, now, time.Minute); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	// Test with another secret, another payload, an old signature and a
+	// malformed header
+	if err := Verify("whsec_other", header, payload, now, time.Minute); err == nil {
+		t.Errorf("Expected error for another secret")
+	}
+	if err := Verify("whsec_test", header, []byte(`{}`), now, time.Minute); err == nil {
+		t.Errorf("Expected error for another payload")
+	}
+	if err := Verify("whsec_test", header, payload, now.Add(time.Hour), time.Minute); err == nil {
+		t.Errorf("Expected error for an old signature")
+	}
+	if err := Verify("whsec_test", "v1=abc", payload, now, time.Minute); err == nil {
+		t.Errorf("Expected error for a malformed header")
+	}
+}
+
+func TestSend(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []byte
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		if r.Header.Get(HeaderEvent) != EventLogStored || r.Header.Get(HeaderDelivery) != "d1" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		if err := Verify(secret, r.Header.Get(HeaderSignature), received, time.Now(), time.Minute); err != nil {
+			t.Errorf("Expected valid signature, got %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	n := Notification{
+		ID:      "d1",
+		Event:   EventLogStored,
+		URL:     receiver.URL,
+		Secret:  secret,
+		Payload: []byte(`{"event":"log.stored","data":{}}`),
+	}
+
+	// Test with a receiver acknowledging the notification
+	code, err := Send(context.Background(), receiver.Client(), n)
+	if err != nil || code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d (%v)", code, err)
+	}
+	if string(received) != string(n.Payload) {
+		t.Errorf("Expected payload %s, got %s", n.Payload, received)
+	}
+
+	// Test with a receiver failing
+	status = http.StatusBadGateway
+	code, err = Send(context.Background(), receiver.Client(), n)
+	if err == nil || code != http.StatusBadGateway {
+		t.Errorf("Expected error with status 502, got %d (%v)", code, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: 6 * time.Hour,
+	}
+	for attempts, expected := range tests {
+		if d := Backoff(attempts); d != expected {
+			t.Errorf("Expected %v after %d attempts, got %v", expected, attempts, d)
+		}
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"255.255.255.255": false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00:ec2::254":   false,
+		"::ffff:10.0.0.1": false,
+	}
+	for addr, want := range tests {
+		if got := PublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("Expected PublicIP(%s) = %t, got %t", addr, want, got)
+		}
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach a loopback receiver")
+	}))
+	defer receiver.Close()
+
+	// Test that addresses are checked when connecting, whatever the URL
+	client := &http.Client{Transport: NewTransport()}
+	_, err := Send(context.Background(), client, Notification{ID: "d1", Event: EventLogStored, URL: receiver.URL})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build wasip1
+
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// input is the memory the service writes the next log to, and output the
+// result of the last call. They are kept here so that they are not
+// collected while the service reads them.
+var input, output []byte
+
+//go:wasmexport logaudit_alloc
+func alloc(size uint32) uint32 {
+	input = make([]byte, size)
+	return uint32(uintptr(unsafe.Pointer(unsafe.SliceData(input))))
+}
+
+//go:wasmexport logaudit_process
+func process(ptr, size uint32) uint64 {
+	output, _ = json.Marshal(handle(input[:size]))
+	return uint64(uintptr(unsafe.Pointer(unsafe.SliceData(output))))<<32 | uint64(len(output))
+}
@@ -0,0 +1,3 @@
+module github.com/IkehAkinyemi/logaudit/plugins/redact
+
+go 1.24
@@ -0,0 +1,84 @@
+// Command redact is an example plugin. It masks secrets published in the
+// extension maps of logs, drops health checks, and rejects logs of
+// anonymous actors.
+//
+// Build it with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o redact.wasm
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// secrets lists the extension keys whose values are masked, ignoring case.
+var secrets = []string{"password", "token", "secret", "card_number"}
+
+type request struct {
+	Log      map[string]interface{} `json:"log"`
+	Delivery struct {
+		ServiceID string `json:"service_id"`
+	} `json:"delivery"`
+}
+
+type result struct {
+	Log    map[string]interface{} `json:"log,omitempty"`
+	Drop   bool                   `json:"drop,omitempty"`
+	Reject string                 `json:"reject,omitempty"`
+}
+
+func handle(body []byte) result {
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return result{Reject: "unreadable log"}
+	}
+	log := req.Log
+
+	if log["action"] == "healthcheck" {
+		return result{Drop: true}
+	}
+	if actor, ok := log["actor"].(map[string]interface{}); ok && actor["type"] == "anonymous" {
+		return result{Reject: "anonymous actors are not audited"}
+	}
+
+	changed := redact(log["extension"])
+	for _, key := range []string{"actor", "entity", "context"} {
+		if m, ok := log[key].(map[string]interface{}); ok && redact(m["extension"]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return result{}
+	}
+	return result{Log: log}
+}
+
+// redact masks the secrets of an extension map, and of the maps it holds.
+func redact(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	changed := false
+	for key, value := range m {
+		if isSecret(key) {
+			m[key] = "[REDACTED]"
+			changed = true
+		} else if redact(value) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func isSecret(key string) bool {
+	for _, s := range secrets {
+		if strings.EqualFold(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	// Test that secrets are masked wherever they are published
+	res := handle([]byte(`{"log": {"action": "login", "actor": {"type": "user", "id": "1", "extension": {"Password": "hunter2"}},
+		"extension": {"payment": {"card_number": "4111111111111111", "amount": 10}}}}`))
+	body, _ := json.Marshal(res.Log)
+	expected := `{"action":"login","actor":{"extension":{"Password":"[REDACTED]"},"id":"1","type":"user"},"extension":{"payment":{"amount":10,"card_number":"[REDACTED]"}}}`
+	if string(body) != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+
+	// Test that logs without secrets are kept as they are
+	if res := handle([]byte(`{"log": {"action": "login", "extension": {"amount": 10}}}`)); res.Log != nil || res.Drop || res.Reject != "" {
+		t.Errorf("Expected no change, got %+v", res)
+	}
+
+	// Test that health checks are dropped, and anonymous actors rejected
+	if res := handle([]byte(`{"log": {"action": "healthcheck"}}`)); !res.Drop {
+		t.Errorf("Expected the log to be dropped, got %+v", res)
+	}
+	if res := handle([]byte(`{"log": {"action": "viewed", "actor": {"type": "anonymous"}}}`)); res.Reject == "" {
+		t.Errorf("Expected the log to be rejected, got %+v", res)
+	}
+}